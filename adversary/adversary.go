@@ -0,0 +1,182 @@
+package adversary
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/gitferry/bamboo/blockchain"
+	"github.com/gitferry/bamboo/config"
+	"github.com/gitferry/bamboo/crypto"
+	"github.com/gitferry/bamboo/identity"
+	"github.com/gitferry/bamboo/log"
+	"github.com/gitferry/bamboo/node"
+	"github.com/gitferry/bamboo/types"
+)
+
+// the strategies built in the protocols and the nodes rather than in the adversary
+const (
+	FORK    = "fork"
+	SILENCE = "silence"
+)
+
+// keep is the number of views of which the adversary remembers the blocks
+const keep = 10
+
+// Strategy is a Byzantine behavior, it rewrites the proposals and the outgoing messages of a replica
+type Strategy interface {
+	// Propose returns the block to propose instead of the block made by the protocol
+	Propose(a *Adversary, block *blockchain.Block) *blockchain.Block
+	// Send returns the messages to send to the peer instead of m, nothing is sent if it is empty
+	Send(a *Adversary, to identity.NodeID, m interface{}) []interface{}
+}
+
+var strategies = map[string]Strategy{
+	EQUIVOCATE:    equivocate{},
+	WITHHOLD:      withhold{},
+	SELECTIVE_TMO: selectiveTmo{},
+	STALE_QC:      staleQC{},
+	DOUBLE_VOTE:   doubleVote{},
+}
+
+// Register makes a strategy available to the nodes under the name
+func Register(name string, s Strategy) {
+	strategies[name] = s
+}
+
+// Adversary wraps the node of a Byzantine replica and applies its strategies
+// to every message the replica sends, the protocol itself is left unchanged
+type Adversary struct {
+	node.Node
+	strategies []Strategy
+	peers      []identity.NodeID // every other node, sorted
+	blocks     map[types.View][]*blockchain.Block
+	twins      map[crypto.Identifier]*blockchain.Block // the conflicting block of every equivocated block
+
+	mu sync.Mutex
+}
+
+// NewAdversary creates an adversary for the node with the named strategies,
+// it returns nil if none of them is implemented by the adversary
+func NewAdversary(n node.Node, names []string) *Adversary {
+	a := &Adversary{
+		Node:   n,
+		blocks: make(map[types.View][]*blockchain.Block),
+		twins:  make(map[crypto.Identifier]*blockchain.Block),
+	}
+	for _, name := range names {
+		s, ok := strategies[name]
+		if !ok {
+			if name != FORK && name != SILENCE {
+				log.Warningf("[%v] unknown Byzantine strategy %v", n.ID(), name)
+			}
+			continue
+		}
+		a.strategies = append(a.strategies, s)
+	}
+	if len(a.strategies) == 0 {
+		return nil
+	}
+	for _, id := range config.GetConfig().IDs() {
+		if id != n.ID() {
+			a.peers = append(a.peers, id)
+		}
+	}
+	sort.Slice(a.peers, func(i, j int) bool { return a.peers[i].Node() < a.peers[j].Node() })
+	return a
+}
+
+// Send sends the messages the strategies make of m to the peer
+func (a *Adversary) Send(to identity.NodeID, m interface{}) {
+	for _, m := range a.tamper(to, m) {
+		a.Node.Send(to, m)
+	}
+}
+
+// Broadcast sends the messages the strategies make of m to every peer, one by one in the order of the ids
+func (a *Adversary) Broadcast(m interface{}) {
+	for _, to := range a.peers {
+		a.Send(to, m)
+	}
+}
+
+func (a *Adversary) tamper(to identity.NodeID, m interface{}) []interface{} {
+	if block, ok := m.(*blockchain.Block); ok {
+		a.observe(block)
+	}
+	msgs := []interface{}{m}
+	for _, s := range a.strategies {
+		var next []interface{}
+		for _, m := range msgs {
+			next = append(next, s.Send(a, to, m)...)
+		}
+		msgs = next
+	}
+	return msgs
+}
+
+// propose applies the strategies to a block made by the protocol
+func (a *Adversary) propose(block *blockchain.Block) *blockchain.Block {
+	for _, s := range a.strategies {
+		block = s.Propose(a, block)
+	}
+	a.observe(block)
+	return block
+}
+
+// observe remembers a block seen by the replica
+func (a *Adversary) observe(block *blockchain.Block) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, b := range a.blocks[block.View] {
+		if b.ID == block.ID {
+			return
+		}
+	}
+	a.blocks[block.View] = append(a.blocks[block.View], block)
+	for view := range a.blocks {
+		if view+keep < block.View {
+			delete(a.blocks, view)
+		}
+	}
+	for id, twin := range a.twins {
+		if twin.View+keep < block.View {
+			delete(a.twins, id)
+		}
+	}
+}
+
+// Block returns the block of the id seen in the last views, nil if there is none
+func (a *Adversary) Block(id crypto.Identifier) *blockchain.Block {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, blocks := range a.blocks {
+		for _, b := range blocks {
+			if b.ID == id {
+				return b
+			}
+		}
+	}
+	return nil
+}
+
+// Blocks returns the blocks of the view seen by the replica
+func (a *Adversary) Blocks(view types.View) []*blockchain.Block {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]*blockchain.Block(nil), a.blocks[view]...)
+}
+
+// Peers returns the other nodes sorted by id
+func (a *Adversary) Peers() []identity.NodeID {
+	return a.peers
+}
+
+// InSecondHalf returns true if the peer is in the second half of the sorted peers
+func (a *Adversary) InSecondHalf(to identity.NodeID) bool {
+	for i, id := range a.peers {
+		if id == to {
+			return i >= len(a.peers)/2
+		}
+	}
+	return false
+}
@@ -0,0 +1,105 @@
+package adversary
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/gitferry/bamboo/blockchain"
+	"github.com/gitferry/bamboo/config"
+	"github.com/gitferry/bamboo/crypto"
+	"github.com/gitferry/bamboo/identity"
+	"github.com/gitferry/bamboo/message"
+	"github.com/gitferry/bamboo/node"
+	"github.com/gitferry/bamboo/pacemaker"
+	"github.com/gitferry/bamboo/types"
+)
+
+type fakeNode struct {
+	node.Node
+	id   identity.NodeID
+	sent map[identity.NodeID][]interface{}
+}
+
+func (n *fakeNode) ID() identity.NodeID {
+	return n.id
+}
+
+func (n *fakeNode) Send(to identity.NodeID, m interface{}) {
+	n.sent[to] = append(n.sent[to], m)
+}
+
+func newAdversary(t *testing.T, strategies ...string) (*Adversary, *fakeNode) {
+	config.Configuration.Signer = crypto.BLS_BLS12381
+	config.Configuration.Addrs = make(map[identity.NodeID]string)
+	for i := 1; i <= 4; i++ {
+		config.Configuration.Addrs[identity.NewNodeID(i)] = ""
+	}
+	crypto.SetKeysWith(4, crypto.BLS_BLS12381)
+	n := &fakeNode{id: identity.NewNodeID(1), sent: make(map[identity.NodeID][]interface{})}
+	a := NewAdversary(n, strategies)
+	require.NotNil(t, a)
+	return a, n
+}
+
+func TestNewAdversary(t *testing.T) {
+	n := &fakeNode{id: identity.NewNodeID(1)}
+	require.Nil(t, NewAdversary(n, []string{FORK, SILENCE}))
+	a, _ := newAdversary(t, FORK, WITHHOLD)
+	require.Len(t, a.strategies, 1)
+	require.Equal(t, []identity.NodeID{"2", "3", "4"}, a.Peers())
+}
+
+func TestEquivocate(t *testing.T) {
+	a, n := newAdversary(t, EQUIVOCATE, DOUBLE_VOTE)
+	block := blockchain.MakeBlock(2, &blockchain.QC{View: 1}, crypto.Identifier{1}, nil, a.ID())
+	a.Broadcast(block)
+	require.Equal(t, []interface{}{block}, n.sent["2"])
+	twin := n.sent["3"][0].(*blockchain.Block)
+	require.NotEqual(t, block.ID, twin.ID)
+	require.Equal(t, block.View, twin.View)
+	require.Equal(t, block.PrevID, twin.PrevID)
+	require.Equal(t, []interface{}{twin}, n.sent["4"])
+
+	// the adversary votes for both blocks
+	a.Send("3", blockchain.MakeVote(2, a.ID(), block.ID))
+	require.Len(t, n.sent["3"], 3)
+	require.Equal(t, twin.ID, n.sent["3"][2].(*blockchain.Vote).BlockID)
+}
+
+func TestWithholdAndSelectiveTmo(t *testing.T) {
+	a, n := newAdversary(t, WITHHOLD, SELECTIVE_TMO)
+	a.Send("2", blockchain.MakeVote(2, a.ID(), crypto.Identifier{1}))
+	require.Empty(t, n.sent["2"])
+	a.Broadcast(pacemaker.MakeTMO(2, a.ID(), nil))
+	require.Len(t, n.sent["2"], 1)
+	require.Empty(t, n.sent["3"])
+	require.Empty(t, n.sent["4"])
+}
+
+type fakeSafety struct {
+	Safety
+	block *blockchain.Block
+}
+
+func (s *fakeSafety) ProcessBlock(block *blockchain.Block) error {
+	return nil
+}
+
+func (s *fakeSafety) MakeProposal(view types.View, payload []*message.Transaction) *blockchain.Block {
+	return s.block
+}
+
+func TestStaleQC(t *testing.T) {
+	a, _ := newAdversary(t, STALE_QC)
+	grandparent := blockchain.MakeBlock(1, &blockchain.QC{}, crypto.Identifier{}, nil, "2")
+	parent := blockchain.MakeBlock(2, &blockchain.QC{View: 1, BlockID: grandparent.ID}, grandparent.ID, nil, "3")
+	block := blockchain.MakeBlock(3, &blockchain.QC{View: 2, BlockID: parent.ID}, parent.ID, nil, a.ID())
+	s := a.Wrap(&fakeSafety{block: block})
+	require.NoError(t, s.ProcessBlock(grandparent))
+	require.NoError(t, s.ProcessBlock(parent))
+	stale := s.MakeProposal(3, nil)
+	require.Equal(t, types.View(3), stale.View)
+	require.Equal(t, grandparent.ID, stale.PrevID)
+	require.Equal(t, types.View(1), stale.QC.View)
+}
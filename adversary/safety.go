@@ -0,0 +1,41 @@
+package adversary
+
+import (
+	"github.com/gitferry/bamboo/blockchain"
+	"github.com/gitferry/bamboo/message"
+	"github.com/gitferry/bamboo/pacemaker"
+	"github.com/gitferry/bamboo/types"
+)
+
+// Safety is the interface of the protocols, the same as the one the replica runs
+type Safety interface {
+	ProcessBlock(block *blockchain.Block) error
+	ProcessVote(vote *blockchain.Vote)
+	ProcessRemoteTmo(tmo *pacemaker.TMO)
+	ProcessLocalTmo(view types.View)
+	ProcessTC(tc *pacemaker.TC)
+	ProcessSyncRequest(req *blockchain.SyncRequest)
+	ProcessSyncResponse(resp *blockchain.SyncResponse)
+	MakeProposal(view types.View, payload []*message.Transaction) *blockchain.Block
+	GetChainStatus() string
+}
+
+// safety lets the adversary see the blocks processed by the protocol and rewrite its proposals
+type safety struct {
+	Safety
+	a *Adversary
+}
+
+// Wrap wraps the protocol of the replica so that the strategies apply to its proposals
+func (a *Adversary) Wrap(s Safety) Safety {
+	return &safety{Safety: s, a: a}
+}
+
+func (s *safety) ProcessBlock(block *blockchain.Block) error {
+	s.a.observe(block)
+	return s.Safety.ProcessBlock(block)
+}
+
+func (s *safety) MakeProposal(view types.View, payload []*message.Transaction) *blockchain.Block {
+	return s.a.propose(s.Safety.MakeProposal(view, payload))
+}
@@ -0,0 +1,131 @@
+package adversary
+
+import (
+	"fmt"
+
+	"github.com/gitferry/bamboo/blockchain"
+	"github.com/gitferry/bamboo/identity"
+	"github.com/gitferry/bamboo/log"
+	"github.com/gitferry/bamboo/message"
+	"github.com/gitferry/bamboo/pacemaker"
+)
+
+// Byzantine strategies of the adversary
+const (
+	EQUIVOCATE    = "equivocate"        // propose two conflicting blocks per view, one to each half of the peers
+	WITHHOLD      = "withhold_vote"     // never send votes
+	SELECTIVE_TMO = "selective_timeout" // send timeouts to the first half of the peers only
+	STALE_QC      = "stale_qc"          // propose blocks extending the certificate before the highest one
+	DOUBLE_VOTE   = "double_vote"       // vote for every block seen in the view
+)
+
+// the strategies rewrite the messages as the protocols send them, i.e., as pointers
+
+type equivocate struct{}
+
+func (equivocate) Propose(a *Adversary, block *blockchain.Block) *blockchain.Block {
+	return block
+}
+
+func (equivocate) Send(a *Adversary, to identity.NodeID, m interface{}) []interface{} {
+	block, ok := m.(*blockchain.Block)
+	if !ok || block.Proposer != a.ID() || !a.InSecondHalf(to) {
+		return []interface{}{m}
+	}
+	return []interface{}{a.Twin(block)}
+}
+
+// Twin returns a block of the same view, parent and certificate conflicting with the block,
+// the same twin is returned for every call with the block
+func (a *Adversary) Twin(block *blockchain.Block) *blockchain.Block {
+	a.mu.Lock()
+	twin, ok := a.twins[block.ID]
+	a.mu.Unlock()
+	if ok {
+		return twin
+	}
+	// an extra transaction makes the id of the twin differ
+	payload := append([]*message.Transaction(nil), block.Payload...)
+	payload = append(payload, &message.Transaction{ID: fmt.Sprintf("equivocation-%x", block.ID)})
+	twin = blockchain.MakeBlock(block.View, block.QC, block.PrevID, payload, block.Proposer)
+	if len(block.Digests) > 0 {
+		twin.SetDigests(block.Digests)
+	}
+	twin.Timestamp = block.Timestamp
+	log.Debugf("[%v] equivocates in view %v, id: %x, twin id: %x", a.ID(), block.View, block.ID, twin.ID)
+	a.mu.Lock()
+	a.twins[block.ID] = twin
+	a.mu.Unlock()
+	a.observe(twin)
+	return twin
+}
+
+type withhold struct{}
+
+func (withhold) Propose(a *Adversary, block *blockchain.Block) *blockchain.Block {
+	return block
+}
+
+func (withhold) Send(a *Adversary, to identity.NodeID, m interface{}) []interface{} {
+	if vote, ok := m.(*blockchain.Vote); ok && vote.Voter == a.ID() {
+		log.Debugf("[%v] withholds the vote of view %v to %v", a.ID(), vote.View, to)
+		return nil
+	}
+	return []interface{}{m}
+}
+
+type selectiveTmo struct{}
+
+func (selectiveTmo) Propose(a *Adversary, block *blockchain.Block) *blockchain.Block {
+	return block
+}
+
+func (selectiveTmo) Send(a *Adversary, to identity.NodeID, m interface{}) []interface{} {
+	if tmo, ok := m.(*pacemaker.TMO); ok && tmo.NodeID == a.ID() && a.InSecondHalf(to) {
+		log.Debugf("[%v] keeps the timeout of view %v from %v", a.ID(), tmo.View, to)
+		return nil
+	}
+	return []interface{}{m}
+}
+
+type staleQC struct{}
+
+// Propose extends the block certified by the certificate of the parent instead of the parent
+func (staleQC) Propose(a *Adversary, block *blockchain.Block) *blockchain.Block {
+	if block.QC == nil {
+		return block
+	}
+	parent := a.Block(block.QC.BlockID)
+	if parent == nil || parent.QC == nil {
+		return block
+	}
+	stale := blockchain.MakeBlock(block.View, parent.QC, parent.QC.BlockID, block.Payload, block.Proposer)
+	log.Debugf("[%v] proposes a block with the stale qc of view %v instead of view %v, id: %x", a.ID(), parent.QC.View, block.QC.View, stale.ID)
+	return stale
+}
+
+func (staleQC) Send(a *Adversary, to identity.NodeID, m interface{}) []interface{} {
+	return []interface{}{m}
+}
+
+type doubleVote struct{}
+
+func (doubleVote) Propose(a *Adversary, block *blockchain.Block) *blockchain.Block {
+	return block
+}
+
+// Send adds a vote for every other block of the view seen by the replica
+func (doubleVote) Send(a *Adversary, to identity.NodeID, m interface{}) []interface{} {
+	msgs := []interface{}{m}
+	vote, ok := m.(*blockchain.Vote)
+	if !ok || vote.Voter != a.ID() {
+		return msgs
+	}
+	for _, b := range a.Blocks(vote.View) {
+		if b.ID != vote.BlockID {
+			log.Debugf("[%v] votes again in view %v for %v, id: %x", a.ID(), vote.View, to, b.ID)
+			msgs = append(msgs, blockchain.MakeVote(b.View, a.ID(), b.ID))
+		}
+	}
+	return msgs
+}
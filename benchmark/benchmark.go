@@ -1,8 +1,11 @@
 package benchmark
 
 import (
+	"math"
 	"math/rand"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gitferry/bamboo/config"
@@ -11,9 +14,13 @@ import (
 
 var count uint64
 
+// written is the number of generated values
+var written uint64
+
 // DB is general interface implemented by client to call client library
 type DB interface {
 	Init() error
+	Read(key int) ([]byte, error)
 	Write(key int, value []byte) error
 	Stop() error
 }
@@ -25,6 +32,7 @@ func DefaultBConfig() config.Bconfig {
 		N:           0,
 		Throttle:    0,
 		Concurrency: 1,
+		W:           1,
 	}
 }
 
@@ -36,8 +44,10 @@ type Benchmark struct {
 
 	rate      *Limiter
 	latency   []time.Duration // latency per operation
+	mu        sync.Mutex      // guards latency
 	startTime time.Time
 	counter   int
+	errCount  uint64 // failed operations, including the ones rejected by an overloaded memory pool
 
 	wait sync.WaitGroup // waiting for all generated keys to complete
 }
@@ -61,7 +71,6 @@ func (b *Benchmark) Run() {
 	b.latency = make([]time.Duration, 0)
 	keys := make(chan int, b.Concurrency)
 	latencies := make(chan time.Duration, 1000)
-	defer close(latencies)
 	go b.collect(latencies)
 
 	for i := 0; i < b.Concurrency; i++ {
@@ -100,46 +109,68 @@ func (b *Benchmark) Run() {
 
 	b.db.Stop()
 	close(keys)
-	stat := Statistic(b.latency)
-	confirmCount = uint64(len(b.latency))
+	b.mu.Lock()
+	latency := append([]time.Duration(nil), b.latency...)
+	b.mu.Unlock()
+	stat := Statistic(latency)
+	confirmCount = uint64(len(latency))
 	log.Infof("Concurrency = %d", b.Concurrency)
 	log.Infof("Benchmark Time = %v\n", t)
-	log.Infof("Throughput = %f\n", float64(len(b.latency))/t.Seconds())
-	log.Infof("genCount: %d, sendCount: %d, confirmCount: %d", genCount, sendCount, confirmCount)
+	log.Infof("Throughput = %f\n", float64(len(latency))/t.Seconds())
+	log.Infof("genCount: %d, sendCount: %d, confirmCount: %d, errCount: %d", genCount, sendCount, confirmCount, atomic.LoadUint64(&b.errCount))
 	log.Info(stat)
+	if b.LinearizabilityCheck {
+		log.Infof("The number of linearizability anomalies is %d", b.History.Linearizable())
+	}
 
 	//stat.WriteFile("latency")
 	//b.History.WriteFile("history")
 }
 
 func (b *Benchmark) worker(keys <-chan int, result chan<- time.Duration) {
-	//var s time.Time
-	//var e time.Time
-	//var v int
-	//var err error
+	var s time.Time
+	var e time.Time
+	var err error
 	for k := range keys {
-		//op := new(operation)
-		//v = rand.Int()
-		//s = time.Now()
-		value := make([]byte, config.GetConfig().PayloadSize)
-		rand.Read(value)
-		//rand.Read(value)
-		_ = b.db.Write(k, value)
-		//res, err := strconv.Atoi(r)
-		//log.Debugf("latency is %v", time.Duration(res)*time.Nanosecond)
-		//e = time.Now()
-		//op.input = v
-		//op.start = s.Sub(b.startTime).Nanoseconds()
-		//if err == nil {
-		//op.end = e.Sub(b.startTime).Nanoseconds()
-		//result <- e.Sub(s)
-		//result <- time.Duration(res) * time.Nanosecond
-		//} else {
-		//op.end = math.MaxInt64
-		//log.Error(err)
-		//}
-		//b.History.AddOperation(k, op)
+		op := new(operation)
+		s = time.Now()
+		if rand.Float64() < b.W {
+			value := b.value()
+			op.input = string(value)
+			err = b.db.Write(k, value)
+		} else {
+			var v []byte
+			v, err = b.db.Read(k)
+			op.output = string(v)
+		}
+		e = time.Now()
+		op.start = s.Sub(b.startTime).Nanoseconds()
+		if err == nil {
+			op.end = e.Sub(b.startTime).Nanoseconds()
+			// the latency is perceived by the client, from sending the request to the commit reply
+			result <- e.Sub(s)
+		} else {
+			op.end = math.MaxInt64
+			atomic.AddUint64(&b.errCount, 1)
+			log.Error(err)
+			b.wait.Done()
+		}
+		if b.LinearizabilityCheck {
+			b.History.AddOperation(k, op)
+		}
+	}
+}
+
+// value generates a random payload, which is prefixed with a unique number
+// when the history is checked so that every written value can be told apart
+func (b *Benchmark) value() []byte {
+	value := make([]byte, config.GetConfig().PayloadSize)
+	rand.Read(value)
+	if b.LinearizabilityCheck {
+		prefix := strconv.FormatUint(atomic.AddUint64(&written, 1), 10) + "-"
+		value = append([]byte(prefix), value...)
 	}
+	return value
 }
 
 // generates key based on distribution
@@ -162,7 +193,9 @@ func (b *Benchmark) next() int {
 
 func (b *Benchmark) collect(latencies <-chan time.Duration) {
 	for t := range latencies {
+		b.mu.Lock()
 		b.latency = append(b.latency, t)
+		b.mu.Unlock()
 		b.wait.Done()
 	}
 }
@@ -0,0 +1,65 @@
+package benchmark
+
+import "math"
+
+// Linearizable checks the recorded operations of every key and returns the number of anomalies.
+// A write has the written value as input and a read has the returned value as output,
+// written values are expected to be unique per key.
+// A read is an anomaly if it returns a value that has not been written when the read ends,
+// or a value that has been overwritten before the read starts.
+func (h *History) Linearizable() int {
+	h.RLock()
+	defer h.RUnlock()
+	anomalies := 0
+	for _, ops := range h.shard {
+		anomalies += len(checkKey(ops))
+	}
+	return anomalies
+}
+
+// checkKey returns the anomalous reads of a single key
+func checkKey(ops []*operation) []*operation {
+	var writes, reads []*operation
+	for _, o := range ops {
+		// failed operations have no effect
+		if o.end == math.MaxInt64 {
+			continue
+		}
+		if o.input != nil {
+			writes = append(writes, o)
+		} else {
+			reads = append(reads, o)
+		}
+	}
+	var anomalies []*operation
+	for _, r := range reads {
+		if r.output == nil || r.output == "" {
+			// the initial value can be read only if no write has completed before the read
+			for _, w := range writes {
+				if w.happenBefore(*r) {
+					anomalies = append(anomalies, r)
+					break
+				}
+			}
+			continue
+		}
+		var source *operation
+		for _, w := range writes {
+			if w.input == r.output {
+				source = w
+				break
+			}
+		}
+		if source == nil || r.happenBefore(*source) {
+			anomalies = append(anomalies, r)
+			continue
+		}
+		for _, w := range writes {
+			if w != source && source.happenBefore(*w) && w.happenBefore(*r) {
+				anomalies = append(anomalies, r)
+				break
+			}
+		}
+	}
+	return anomalies
+}
@@ -52,6 +52,9 @@ func Statistic(latency []time.Duration) Stat {
 		sum += m
 	}
 	size := len(ms)
+	if size == 0 {
+		return Stat{}
+	}
 	return Stat{
 		Data:   ms,
 		Size:   size,
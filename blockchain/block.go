@@ -15,10 +15,12 @@ type Block struct {
 	Proposer  identity.NodeID
 	Timestamp time.Time
 	Payload   []*message.Transaction
+	Digests   []crypto.Identifier // certificates of the DAG mempool standing for the payload
 	PrevID    crypto.Identifier
 	Sig       crypto.Signature
 	ID        crypto.Identifier
 	Ts        time.Duration
+	Strength  int // x of the x-strong commit, only reported by SFT
 }
 
 type rawBlock struct {
@@ -26,6 +28,7 @@ type rawBlock struct {
 	QC       *QC
 	Proposer identity.NodeID
 	Payload  []string
+	Digests  []crypto.Identifier
 	PrevID   crypto.Identifier
 	Sig      crypto.Signature
 	ID       crypto.Identifier
@@ -43,11 +46,25 @@ func MakeBlock(view types.View, qc *QC, prevID crypto.Identifier, payload []*mes
 	return b
 }
 
+// SetDigests replaces the payload of the block with digests and signs the block again
+func (b *Block) SetDigests(digests []crypto.Identifier) {
+	b.Digests = digests
+	b.makeID(b.Proposer)
+}
+
 func (b *Block) makeID(nodeID identity.NodeID) {
+	b.ID = b.computeID()
+	// TODO: uncomment the following
+	b.Sig, _ = crypto.PrivSign(crypto.IDToByte(b.ID), nodeID, nil)
+}
+
+// computeID hashes the content of the block
+func (b *Block) computeID() crypto.Identifier {
 	raw := &rawBlock{
 		View:     b.View,
 		QC:       b.QC,
 		Proposer: b.Proposer,
+		Digests:  b.Digests,
 		PrevID:   b.PrevID,
 	}
 	var payloadIDs []string
@@ -55,7 +72,5 @@ func (b *Block) makeID(nodeID identity.NodeID) {
 		payloadIDs = append(payloadIDs, txn.ID)
 	}
 	raw.Payload = payloadIDs
-	b.ID = crypto.MakeID(raw)
-	// TODO: uncomment the following
-	b.Sig, _ = crypto.PrivSign(crypto.IDToByte(b.ID), nodeID, nil)
+	return crypto.MakeID(raw)
 }
@@ -10,6 +10,7 @@ type BlockChain struct {
 	forrest          *LevelledForest
 	quorum           *Quorum
 	longestTailBlock *Block
+	store            Store
 	// measurement
 	highestComitted     int
 	committedBlockNo    int
@@ -24,6 +25,24 @@ func NewBlockchain(n int) *BlockChain {
 	return bc
 }
 
+// NewBlockchainWithStore creates a blockchain that persists committed blocks into the store.
+// If the store already holds committed blocks, the chain is recovered from the last one.
+func NewBlockchainWithStore(n int, store Store) (*BlockChain, error) {
+	bc := NewBlockchain(n)
+	bc.store = store
+	last, err := store.LastBlock()
+	if err != nil {
+		return nil, fmt.Errorf("cannot recover the blockchain: %w", err)
+	}
+	if last != nil {
+		// the last committed block becomes the root of the forest
+		bc.forrest.LowestLevel = uint64(last.View)
+		bc.AddBlock(last)
+		bc.highestComitted = int(last.View)
+	}
+	return bc, nil
+}
+
 func (bc *BlockChain) Exists(id crypto.Identifier) bool {
 	return bc.forrest.HasVertex(id)
 }
@@ -66,7 +85,7 @@ func (bc *BlockChain) GetGrandParentBlock(id crypto.Identifier) (*Block, error)
 	return bc.GetParentBlock(parentBlock.ID)
 }
 
-// CommitBlock prunes blocks and returns committed blocks up to the last committed one and prunedBlocks
+// CommitBlock prunes blocks and returns committed blocks up to the last committed one in the commit order and prunedBlocks
 func (bc *BlockChain) CommitBlock(id crypto.Identifier, view types.View) ([]*Block, []*Block, error) {
 	vertex, ok := bc.forrest.GetVertex(id)
 	if !ok {
@@ -89,6 +108,18 @@ func (bc *BlockChain) CommitBlock(id crypto.Identifier, view types.View) ([]*Blo
 		}
 		block = vertex.GetBlock()
 	}
+	// blocks are collected from the newest, they are committed from the oldest
+	for i, j := 0, len(committedBlocks)-1; i < j; i, j = i+1, j-1 {
+		committedBlocks[i], committedBlocks[j] = committedBlocks[j], committedBlocks[i]
+	}
+	if bc.store != nil {
+		for _, block := range committedBlocks {
+			err := bc.store.AppendBlock(block)
+			if err != nil {
+				return nil, nil, fmt.Errorf("cannot persist the committed block, id: %x: %w", block.ID, err)
+			}
+		}
+	}
 	forkedBlocks, prunedNo, err := bc.forrest.PruneUpToLevel(uint64(committedView))
 	if err != nil {
 		return nil, nil, fmt.Errorf("cannot prune the blockchain to the committed block, id: %w", err)
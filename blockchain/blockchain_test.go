@@ -3,54 +3,15 @@ package blockchain
 import (
 	"testing"
 
+	"github.com/gitferry/bamboo/config"
+	"github.com/gitferry/bamboo/crypto"
 	"github.com/gitferry/bamboo/utils"
 	"github.com/stretchr/testify/require"
 )
 
-// add one qc
-func TestUpdateHighQC1(t *testing.T) {
-	bc := NewBlockchain(10)
-	qc1 := &QC{
-		View:    1,
-		BlockID: utils.IdentifierFixture(),
-	}
-	bc.UpdateHighQC(qc1)
-	highQC := bc.GetHighQC()
-	require.Equal(t, qc1, highQC)
-}
-
-// add two qcs, the second is higher
-func TestUpdateHighQC2(t *testing.T) {
-	bc := NewBlockchain(10)
-	qc1 := &QC{
-		View:    1,
-		BlockID: utils.IdentifierFixture(),
-	}
-	bc.UpdateHighQC(qc1)
-	qc2 := &QC{
-		View:    2,
-		BlockID: utils.IdentifierFixture(),
-	}
-	bc.UpdateHighQC(qc2)
-	highQC := bc.GetHighQC()
-	require.Equal(t, qc2, highQC)
-}
-
-// add two qcs, the first is higher
-func TestUpdateHighQC3(t *testing.T) {
-	bc := NewBlockchain(10)
-	qc1 := &QC{
-		View:    2,
-		BlockID: utils.IdentifierFixture(),
-	}
-	bc.UpdateHighQC(qc1)
-	qc2 := &QC{
-		View:    1,
-		BlockID: utils.IdentifierFixture(),
-	}
-	bc.UpdateHighQC(qc2)
-	highQC := bc.GetHighQC()
-	require.Equal(t, qc1, highQC)
+func init() {
+	config.Configuration.Signer = crypto.BLS_BLS12381
+	_ = crypto.SetKeysWith(4, crypto.BLS_BLS12381)
 }
 
 func TestAddBlock(t *testing.T) {
@@ -65,8 +26,7 @@ func TestAddBlock(t *testing.T) {
 		PrevID: qc.BlockID,
 	}
 	bc.AddBlock(b)
-	highQC := bc.GetHighQC()
-	require.Equal(t, qc, highQC)
+	require.True(t, bc.Exists(b.ID))
 }
 
 // add two blocks with parent-child relationship
@@ -76,13 +36,13 @@ func TestParentBlock1(t *testing.T) {
 		View:    1,
 		BlockID: utils.IdentifierFixture(),
 	}
-	b1 := MakeBlock(2, qc1, nil, "1")
+	b1 := MakeBlock(2, qc1, qc1.BlockID, nil, "1")
 	bc.AddBlock(b1)
 	qc2 := &QC{
 		View:    2,
 		BlockID: b1.ID,
 	}
-	b2 := MakeBlock(3, qc2, nil, "1")
+	b2 := MakeBlock(3, qc2, qc2.BlockID, nil, "1")
 	bc.AddBlock(b2)
 	parent, err := bc.GetParentBlock(b2.ID)
 	require.NoError(t, err)
@@ -96,13 +56,13 @@ func TestParentBlock2(t *testing.T) {
 		View:    1,
 		BlockID: utils.IdentifierFixture(),
 	}
-	b1 := MakeBlock(2, qc1, nil, "1")
+	b1 := MakeBlock(2, qc1, qc1.BlockID, nil, "1")
 	bc.AddBlock(b1)
 	qc2 := &QC{
 		View:    2,
 		BlockID: utils.IdentifierFixture(),
 	}
-	b2 := MakeBlock(3, qc2, nil, "1")
+	b2 := MakeBlock(3, qc2, qc2.BlockID, nil, "1")
 	bc.AddBlock(b2)
 	parent, err := bc.GetParentBlock(b2.ID)
 	require.Error(t, err)
@@ -116,19 +76,19 @@ func TestGrandParentBlock1(t *testing.T) {
 		View:    1,
 		BlockID: utils.IdentifierFixture(),
 	}
-	b1 := MakeBlock(2, qc1, nil, "1")
+	b1 := MakeBlock(2, qc1, qc1.BlockID, nil, "1")
 	bc.AddBlock(b1)
 	qc2 := &QC{
 		View:    2,
 		BlockID: b1.ID,
 	}
-	b2 := MakeBlock(3, qc2, nil, "1")
+	b2 := MakeBlock(3, qc2, qc2.BlockID, nil, "1")
 	bc.AddBlock(b2)
 	qc3 := &QC{
 		View:    3,
 		BlockID: b2.ID,
 	}
-	b3 := MakeBlock(4, qc3, nil, "1")
+	b3 := MakeBlock(4, qc3, qc3.BlockID, nil, "1")
 	bc.AddBlock(b3)
 	grandParent, err := bc.GetGrandParentBlock(b3.ID)
 	require.NoError(t, err)
@@ -142,19 +102,19 @@ func TestGrandParentBlock2(t *testing.T) {
 		View:    1,
 		BlockID: utils.IdentifierFixture(),
 	}
-	b1 := MakeBlock(2, qc1, nil, "1")
+	b1 := MakeBlock(2, qc1, qc1.BlockID, nil, "1")
 	bc.AddBlock(b1)
 	qc2 := &QC{
 		View:    2,
 		BlockID: b1.ID,
 	}
-	b2 := MakeBlock(3, qc2, nil, "1")
+	b2 := MakeBlock(3, qc2, qc2.BlockID, nil, "1")
 	bc.AddBlock(b2)
 	qc3 := &QC{
 		View:    3,
 		BlockID: utils.IdentifierFixture(),
 	}
-	b3 := MakeBlock(4, qc3, nil, "1")
+	b3 := MakeBlock(4, qc3, qc3.BlockID, nil, "1")
 	bc.AddBlock(b3)
 	grandParent, err := bc.GetGrandParentBlock(b3.ID)
 	require.Error(t, err)
@@ -168,9 +128,9 @@ func TestCommitBlock1(t *testing.T) {
 		View:    0,
 		BlockID: utils.IdentifierFixture(),
 	}
-	b1 := MakeBlock(1, qc1, nil, "1")
+	b1 := MakeBlock(1, qc1, qc1.BlockID, nil, "1")
 	bc.AddBlock(b1)
-	blocks, err := bc.CommitBlock(b1.ID)
+	blocks, _, err := bc.CommitBlock(b1.ID, b1.View+1)
 	require.NoError(t, err)
 	require.Equal(t, 1, len(blocks))
 	require.Equal(t, b1, blocks[0])
@@ -185,19 +145,19 @@ func TestCommitBlock2(t *testing.T) {
 		View:    0,
 		BlockID: utils.IdentifierFixture(),
 	}
-	b1 := MakeBlock(1, qc1, nil, "1")
+	b1 := MakeBlock(1, qc1, qc1.BlockID, nil, "1")
 	bc.AddBlock(b1)
 	qc2 := &QC{
 		View:    1,
 		BlockID: b1.ID,
 	}
-	b2 := MakeBlock(2, qc2, nil, "1")
+	b2 := MakeBlock(2, qc2, qc2.BlockID, nil, "1")
 	bc.AddBlock(b2)
-	blocks, err := bc.CommitBlock(b2.ID)
+	blocks, _, err := bc.CommitBlock(b2.ID, b2.View+1)
 	require.NoError(t, err)
 	require.Equal(t, 2, len(blocks))
-	require.Equal(t, b2, blocks[0])
-	require.Equal(t, b1, blocks[1])
+	require.Equal(t, b1, blocks[0])
+	require.Equal(t, b2, blocks[1])
 	exists := bc.forrest.HasVertex(b1.ID)
 	require.False(t, exists)
 	exists = bc.forrest.HasVertex(b2.ID)
@@ -211,23 +171,23 @@ func TestCommitBlock3(t *testing.T) {
 		View:    0,
 		BlockID: utils.IdentifierFixture(),
 	}
-	b1 := MakeBlock(1, qc1, nil, "1")
+	b1 := MakeBlock(1, qc1, qc1.BlockID, nil, "1")
 	bc.AddBlock(b1)
 	qc2 := &QC{
 		View:    1,
 		BlockID: b1.ID,
 	}
-	b2 := MakeBlock(2, qc2, nil, "1")
+	b2 := MakeBlock(2, qc2, qc2.BlockID, nil, "1")
 	bc.AddBlock(b2)
 	qc3 := &QC{
 		View:    0,
 		BlockID: utils.IdentifierFixture(),
 	}
-	b3 := MakeBlock(1, qc3, nil, "1")
+	b3 := MakeBlock(1, qc3, qc3.BlockID, nil, "1")
 	bc.AddBlock(b3)
-	blocks, err := bc.CommitBlock(b2.ID)
+	blocks, _, err := bc.CommitBlock(b2.ID, b2.View+1)
 	require.NoError(t, err)
 	require.Equal(t, 2, len(blocks))
-	require.Equal(t, b2, blocks[0])
-	require.Equal(t, b1, blocks[1])
+	require.Equal(t, b1, blocks[0])
+	require.Equal(t, b2, blocks[1])
 }
@@ -2,6 +2,7 @@ package blockchain
 
 import (
 	"fmt"
+	"sort"
 
 	"github.com/gitferry/bamboo/crypto"
 	"github.com/gitferry/bamboo/identity"
@@ -16,11 +17,12 @@ type Vote struct {
 	crypto.Signature
 }
 
+// QC carries one aggregated signature of the quorum plus a bitmap of its signers
 type QC struct {
 	Leader  identity.NodeID
 	View    types.View
 	BlockID crypto.Identifier
-	Signers []identity.NodeID
+	Signers crypto.Bitmap
 	crypto.AggSig
 	crypto.Signature
 }
@@ -66,7 +68,7 @@ func (q *Quorum) Add(vote *Vote) (bool, *QC) {
 	if q.superMajority(vote.BlockID) {
 		aggSig, signers, err := q.getSigs(vote.BlockID)
 		if err != nil {
-			log.Warningf("cannot generate a valid qc, view: %v, block id: %x: %w", vote.View, vote.BlockID, err)
+			log.Warningf("cannot generate a valid qc, view: %v, block id: %x: %v", vote.View, vote.BlockID, err)
 		}
 		qc := &QC{
 			View:    vote.View,
@@ -89,17 +91,64 @@ func (q *Quorum) size(blockID crypto.Identifier) int {
 	return len(q.votes[blockID])
 }
 
-func (q *Quorum) getSigs(blockID crypto.Identifier) (crypto.AggSig, []identity.NodeID, error) {
-	var sigs crypto.AggSig
-	var signers []identity.NodeID
+func (q *Quorum) getSigs(blockID crypto.Identifier) (crypto.AggSig, crypto.Bitmap, error) {
+	var sigs []crypto.Signature
+	signers := crypto.NewBitmap(q.total)
 	_, exists := q.votes[blockID]
 	if !exists {
 		return nil, nil, fmt.Errorf("sigs does not exist, id: %x", blockID)
 	}
-	for _, vote := range q.votes[blockID] {
-		sigs = append(sigs, vote.Signature)
-		signers = append(signers, vote.Voter)
+	// signatures are ordered as the signers in the bitmap
+	voters := make([]identity.NodeID, 0, len(q.votes[blockID]))
+	for voter := range q.votes[blockID] {
+		voters = append(voters, voter)
 	}
+	sort.Slice(voters, func(i, j int) bool { return voters[i].Node() < voters[j].Node() })
+	for _, voter := range voters {
+		sigs = append(sigs, q.votes[blockID][voter].Signature)
+		signers.Set(voter)
+	}
+	aggSig, err := crypto.AggregateSignatures(sigs)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot aggregate sigs, id: %x: %w", blockID, err)
+	}
+
+	return aggSig, signers, nil
+}
 
-	return sigs, signers, nil
+// VerifyFor checks that the qc certifies the parent of the block and carries the signatures of a quorum,
+// the qc of the blocks extending the genesis is of view 0 and carries no signature
+func (qc *QC) VerifyFor(block *Block) error {
+	if qc == nil {
+		return fmt.Errorf("the block should contain a QC")
+	}
+	if qc.BlockID != block.PrevID {
+		return fmt.Errorf("the qc does not certify the parent, view: %v", block.View)
+	}
+	if qc.View == 0 {
+		if qc.BlockID != (crypto.Identifier{}) {
+			return fmt.Errorf("the qc of view 0 does not certify the genesis")
+		}
+		return nil
+	}
+	ok, err := crypto.VerifyQuorumSignature(qc.AggSig, qc.BlockID, qc.Signers)
+	if err != nil {
+		return fmt.Errorf("cannot verify the qc of view %v: %w", qc.View, err)
+	}
+	if !ok {
+		return fmt.Errorf("the qc of view %v has invalid signatures", qc.View)
+	}
+	return nil
+}
+
+// Certifies checks that the qc is of the view of the certified block,
+// the votes only sign the block id so the view of the qc is trusted through the block
+func (qc *QC) Certifies(certified *Block) error {
+	if qc.BlockID != certified.ID {
+		return fmt.Errorf("the qc does not certify the block, id: %x", certified.ID)
+	}
+	if qc.View != certified.View {
+		return fmt.Errorf("the qc view %v is not the view %v of the certified block", qc.View, certified.View)
+	}
+	return nil
 }
@@ -13,7 +13,7 @@ func TestQuorum_SuperMajority1(t *testing.T) {
 	blockID := utils.IdentifierFixture()
 	v1 := MakeVote(1, "1", blockID)
 	quorum.Add(v1)
-	require.False(t, quorum.SuperMajority(blockID))
+	require.False(t, quorum.superMajority(blockID))
 }
 
 // add only two votes
@@ -24,7 +24,7 @@ func TestQuorum_SuperMajority2(t *testing.T) {
 	quorum.Add(v1)
 	v2 := MakeVote(1, "2", blockID)
 	quorum.Add(v2)
-	require.False(t, quorum.SuperMajority(blockID))
+	require.False(t, quorum.superMajority(blockID))
 }
 
 // add three votes for the same block from different nodes
@@ -37,7 +37,7 @@ func TestQuorum_SuperMajority3(t *testing.T) {
 	quorum.Add(v2)
 	v3 := MakeVote(1, "3", blockID)
 	quorum.Add(v3)
-	require.True(t, quorum.SuperMajority(blockID))
+	require.True(t, quorum.superMajority(blockID))
 }
 
 // add three votes, two for the same block from different nodes
@@ -51,7 +51,7 @@ func TestQuorum_SuperMajority4(t *testing.T) {
 	quorum.Add(v2)
 	v3 := MakeVote(1, "3", utils.IdentifierFixture())
 	quorum.Add(v3)
-	require.False(t, quorum.SuperMajority(blockID))
+	require.False(t, quorum.superMajority(blockID))
 }
 
 // add three votes, two for the same block from different nodes
@@ -65,5 +65,5 @@ func TestQuorum_SuperMajority5(t *testing.T) {
 	quorum.Add(v2)
 	v3 := MakeVote(1, "2", blockID)
 	quorum.Add(v3)
-	require.False(t, quorum.SuperMajority(blockID))
+	require.False(t, quorum.superMajority(blockID))
 }
@@ -0,0 +1,29 @@
+package blockchain
+
+import (
+	"github.com/gitferry/bamboo/crypto"
+	"github.com/gitferry/bamboo/types"
+)
+
+// Store persists committed blocks and the safety state of a replica
+// so that it can recover after a restart
+type Store interface {
+	// AppendBlock persists a committed block, blocks are appended in the commit order
+	AppendBlock(block *Block) error
+	// GetBlock returns a committed block
+	GetBlock(id crypto.Identifier) (*Block, error)
+	// LastBlock returns the last committed block, or nil if nothing is committed
+	LastBlock() (*Block, error)
+	// SaveState persists the safety state
+	SaveState(state *SafetyState) error
+	// LoadState returns the last saved safety state, or nil if nothing is saved
+	LoadState() (*SafetyState, error)
+	Close() error
+}
+
+// SafetyState is what a safety module has to remember across restarts to avoid equivocating
+type SafetyState struct {
+	LastVotedView types.View
+	PreferredView types.View
+	HighQC        *QC
+}
@@ -0,0 +1,143 @@
+package blockchain
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gitferry/bamboo/crypto"
+	"github.com/gitferry/bamboo/identity"
+)
+
+// MaxSyncBlocks bounds the number of blocks carried by a single sync response
+const MaxSyncBlocks = 100
+
+// SyncRequest asks a peer for the block ID and up to Count-1 of its ancestors
+type SyncRequest struct {
+	Requester identity.NodeID
+	ID        crypto.Identifier
+	Count     int
+}
+
+// SyncResponse carries the requested blocks in ascending order of view
+type SyncResponse struct {
+	Responder identity.NodeID
+	Blocks    []*Block
+}
+
+// MakeSyncRequest creates a request for the block and its ancestors
+func MakeSyncRequest(requester identity.NodeID, id crypto.Identifier, count int) *SyncRequest {
+	if count < 1 {
+		count = 1
+	}
+	if count > MaxSyncBlocks {
+		count = MaxSyncBlocks
+	}
+	return &SyncRequest{
+		Requester: requester,
+		ID:        id,
+		Count:     count,
+	}
+}
+
+// GetBlocks returns the blocks asked by the request in ascending order of view.
+// Committed blocks that have been pruned are looked up in the store.
+func (bc *BlockChain) GetBlocks(req *SyncRequest) []*Block {
+	return bc.getAncestors(req.ID, req.Count)
+}
+
+func (bc *BlockChain) getAncestors(id crypto.Identifier, count int) []*Block {
+	if count > MaxSyncBlocks {
+		count = MaxSyncBlocks
+	}
+	var blocks []*Block
+	for len(blocks) < count {
+		block, err := bc.getBlock(id)
+		if err != nil {
+			break
+		}
+		blocks = append(blocks, block)
+		if block.View == 0 {
+			break
+		}
+		id = block.PrevID
+	}
+	// blocks are collected from the newest
+	for i, j := 0, len(blocks)-1; i < j; i, j = i+1, j-1 {
+		blocks[i], blocks[j] = blocks[j], blocks[i]
+	}
+	return blocks
+}
+
+// getBlock looks up the block in the forest and then in the store
+func (bc *BlockChain) getBlock(id crypto.Identifier) (*Block, error) {
+	block, err := bc.GetBlockByID(id)
+	if err == nil {
+		return block, nil
+	}
+	if bc.store == nil {
+		return nil, err
+	}
+	return bc.store.GetBlock(id)
+}
+
+// AddSyncedBlock checks that a block received from a sync response is well-formed
+// and signed by its proposer before adding it to the chain
+func (bc *BlockChain) AddSyncedBlock(block *Block) error {
+	if bc.Exists(block.ID) {
+		return nil
+	}
+	if block.computeID() != block.ID {
+		return fmt.Errorf("the synced block does not match its id: %x", block.ID)
+	}
+	ok, err := crypto.PubVerify(block.Sig, crypto.IDToByte(block.ID), block.Proposer)
+	if err != nil || !ok {
+		return fmt.Errorf("the synced block has an invalid signature, id: %x", block.ID)
+	}
+	bc.AddBlock(block)
+	return nil
+}
+
+// now returns the current time of the synchronizers
+var now = time.Now
+
+// SetClock replaces the clock of the synchronizers, the deterministic simulator uses its virtual clock
+func SetClock(clock func() time.Time) {
+	now = clock
+}
+
+// Synchronizer keeps track of the outstanding sync requests
+// so that a missing block is requested at most once per timeout
+type Synchronizer struct {
+	timeout time.Duration
+	pending map[crypto.Identifier]time.Time
+	mu      sync.Mutex
+}
+
+// NewSynchronizer creates a synchronizer which retries a request after the timeout
+func NewSynchronizer(timeout time.Duration) *Synchronizer {
+	return &Synchronizer{
+		timeout: timeout,
+		pending: make(map[crypto.Identifier]time.Time),
+	}
+}
+
+// ShouldRequest marks the block as requested and returns false if
+// there is already an outstanding request for it
+func (s *Synchronizer) ShouldRequest(id crypto.Identifier) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	requested, ok := s.pending[id]
+	if ok && now().Sub(requested) < s.timeout {
+		return false
+	}
+	s.pending[id] = now()
+	return true
+}
+
+// Done clears the outstanding request of the block
+func (s *Synchronizer) Done(id crypto.Identifier) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.pending, id)
+}
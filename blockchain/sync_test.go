@@ -0,0 +1,51 @@
+package blockchain_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/gitferry/bamboo/blockchain"
+	"github.com/gitferry/bamboo/store"
+	"github.com/gitferry/bamboo/utils"
+	"github.com/stretchr/testify/require"
+)
+
+// a lagging replica fetches the ancestors of a block, including the committed ones
+func TestBlockchain_Sync(t *testing.T) {
+	var blocks []*blockchain.Block
+	qc := &blockchain.QC{View: 0}
+	for i := 1; i <= 5; i++ {
+		b := blockchain.MakeBlock(qc.View+1, qc, qc.BlockID, nil, "1")
+		blocks = append(blocks, b)
+		qc = &blockchain.QC{View: b.View, BlockID: b.ID}
+	}
+	bc, err := blockchain.NewBlockchainWithStore(4, store.NewMemStore())
+	require.NoError(t, err)
+	for _, b := range blocks {
+		bc.AddBlock(b)
+	}
+	_, _, err = bc.CommitBlock(blocks[2].ID, 6)
+	require.NoError(t, err)
+
+	synced := bc.GetBlocks(blockchain.MakeSyncRequest("2", blocks[4].ID, 4))
+	require.Len(t, synced, 4)
+	for i, b := range synced {
+		require.Equal(t, blocks[i+1].ID, b.ID)
+	}
+	synced = bc.GetBlocks(blockchain.MakeSyncRequest("2", utils.IdentifierFixture(), 4))
+	require.Empty(t, synced)
+	synced = bc.GetBlocks(blockchain.MakeSyncRequest("2", blocks[4].ID, math.MaxInt32))
+	require.Len(t, synced, 5)
+	synced = bc.GetBlocks(blockchain.MakeSyncRequest("2", blocks[4].ID, 2))
+	require.Len(t, synced, 2)
+
+	lagging := blockchain.NewBlockchain(4)
+	for _, b := range synced {
+		require.NoError(t, lagging.AddSyncedBlock(b))
+	}
+	require.True(t, lagging.Exists(blocks[4].ID))
+
+	forged := *blocks[4]
+	forged.Proposer = "2"
+	require.Error(t, blockchain.NewBlockchain(4).AddSyncedBlock(&forged))
+}
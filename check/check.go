@@ -0,0 +1,46 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"time"
+
+	"github.com/gitferry/bamboo"
+	"github.com/gitferry/bamboo/checker"
+	"github.com/gitferry/bamboo/config"
+	"github.com/gitferry/bamboo/identity"
+	"github.com/gitferry/bamboo/log"
+)
+
+var maxGap = flag.Int("max_gap", 0, "longest time in ms an honest replica may go without commits, unchecked if 0")
+
+// check verifies the commits in the server logs given as arguments, the Byzantine replicas of the config are skipped
+func main() {
+	bamboo.Init()
+	c := checker.NewChecker(nil, time.Time{}, time.Duration(*maxGap)*time.Millisecond)
+	honest := func(id identity.NodeID) bool {
+		return !config.GetConfig().IsByzantine(id)
+	}
+	var end time.Time
+	for _, name := range flag.Args() {
+		file, err := os.Open(name)
+		if err != nil {
+			log.Fatal(err)
+		}
+		last, err := checker.ParseLog(file, c, honest)
+		file.Close()
+		if err != nil {
+			log.Fatalf("cannot parse %v: %v", name, err)
+		}
+		if last.After(end) {
+			end = last
+		}
+	}
+	for _, g := range c.Gaps(end) {
+		log.Infof("%v", g)
+	}
+	if err := c.Check(end); err != nil {
+		log.Fatalf("the replicas violate the invariants:\n%v", err)
+	}
+	log.Infof("the commit logs are consistent")
+}
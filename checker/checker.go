@@ -0,0 +1,179 @@
+package checker
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gitferry/bamboo/blockchain"
+	"github.com/gitferry/bamboo/crypto"
+	"github.com/gitferry/bamboo/identity"
+	"github.com/gitferry/bamboo/types"
+)
+
+// Entry is a block committed by a replica, the height is its position in the commit log of the replica
+type Entry struct {
+	View   types.View
+	ID     crypto.Identifier
+	PrevID crypto.Identifier // zero if unknown
+	At     time.Time
+}
+
+// Gap is the longest time a replica went without committing a block
+type Gap struct {
+	Replica  identity.NodeID
+	From, To time.Time
+}
+
+// Duration returns the length of the gap
+func (g Gap) Duration() time.Duration {
+	return g.To.Sub(g.From)
+}
+
+func (g Gap) String() string {
+	return fmt.Sprintf("replica %v committed nothing for %v", g.Replica, g.Duration())
+}
+
+// Checker observes the commit logs of the honest replicas and verifies that
+// they agree on the committed blocks, that no two conflicting blocks are committed
+// at the same height or view, that every commit log is a chain extending
+// the same prefix, and that no replica goes without commits for longer than the bound
+type Checker struct {
+	maxGap     time.Duration
+	start      time.Time
+	logs       map[identity.NodeID][]Entry
+	committed  map[identity.NodeID]map[crypto.Identifier]bool
+	heights    []Entry // the first block committed at every height
+	views      map[types.View]crypto.Identifier
+	gaps       map[identity.NodeID]Gap
+	violations []string
+
+	mu sync.Mutex
+}
+
+// NewChecker creates a checker of the replicas starting at the time,
+// a liveness gap longer than maxGap is a violation unless maxGap is 0
+func NewChecker(ids []identity.NodeID, start time.Time, maxGap time.Duration) *Checker {
+	c := &Checker{
+		maxGap:    maxGap,
+		start:     start,
+		logs:      make(map[identity.NodeID][]Entry),
+		committed: make(map[identity.NodeID]map[crypto.Identifier]bool),
+		views:     make(map[types.View]crypto.Identifier),
+		gaps:      make(map[identity.NodeID]Gap),
+	}
+	for _, id := range ids {
+		c.logs[id] = nil
+		c.committed[id] = make(map[crypto.Identifier]bool)
+	}
+	return c
+}
+
+// Commit records a block committed by the replica at the time
+func (c *Checker) Commit(id identity.NodeID, block *blockchain.Block, at time.Time) {
+	c.Record(id, Entry{View: block.View, ID: block.ID, PrevID: block.PrevID, At: at})
+}
+
+// Record adds the entry to the commit log of the replica and checks it against the other logs
+func (c *Checker) Record(id identity.NodeID, e Entry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.committed[id] == nil {
+		c.committed[id] = make(map[crypto.Identifier]bool)
+	}
+	if c.committed[id][e.ID] {
+		// reported again, e.g., when an sft commit gets stronger
+		return
+	}
+	log := c.logs[id]
+	if len(log) > 0 {
+		last := log[len(log)-1]
+		if e.PrevID != (crypto.Identifier{}) && e.PrevID != last.ID {
+			c.violate("prefix: replica %v committed block %x of view %v which does not extend its last committed block %x of view %v", id, e.ID, e.View, last.ID, last.View)
+		}
+		c.updateGap(id, last.At, e.At)
+	} else if !c.start.IsZero() {
+		c.updateGap(id, c.start, e.At)
+	}
+	height := len(log)
+	if height < len(c.heights) {
+		if first := c.heights[height]; first.ID != e.ID {
+			c.violate("agreement: replica %v committed block %x of view %v at height %v where block %x of view %v was committed", id, e.ID, e.View, height+1, first.ID, first.View)
+		}
+	} else {
+		c.heights = append(c.heights, e)
+	}
+	if other, ok := c.views[e.View]; ok && other != e.ID {
+		c.violate("conflict: replica %v committed block %x of view %v while block %x of the same view was committed", id, e.ID, e.View, other)
+	} else {
+		c.views[e.View] = e.ID
+	}
+	c.logs[id] = append(log, e)
+	c.committed[id][e.ID] = true
+}
+
+func (c *Checker) updateGap(id identity.NodeID, from, to time.Time) {
+	if g, ok := c.gaps[id]; !ok || to.Sub(from) > g.Duration() {
+		c.gaps[id] = Gap{Replica: id, From: from, To: to}
+	}
+}
+
+func (c *Checker) violate(format string, args ...interface{}) {
+	c.violations = append(c.violations, fmt.Sprintf(format, args...))
+}
+
+// Log returns the commit log of the replica
+func (c *Checker) Log(id identity.NodeID) []Entry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]Entry(nil), c.logs[id]...)
+}
+
+// Violations returns the safety violations found so far
+func (c *Checker) Violations() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.violations...)
+}
+
+// Gaps returns the longest liveness gap of every replica up to now, sorted by replica
+func (c *Checker) Gaps(now time.Time) []Gap {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var gaps []Gap
+	for id, log := range c.logs {
+		g, ok := c.gaps[id]
+		from := c.start
+		if len(log) > 0 {
+			from = log[len(log)-1].At
+		}
+		if !from.IsZero() && (!ok || now.Sub(from) > g.Duration()) {
+			g = Gap{Replica: id, From: from, To: now}
+			ok = true
+		}
+		if ok {
+			gaps = append(gaps, g)
+		}
+	}
+	sort.Slice(gaps, func(i, j int) bool { return gaps[i].Replica.Node() < gaps[j].Replica.Node() })
+	return gaps
+}
+
+// Check returns an error listing the safety violations and the liveness gaps longer than the bound
+func (c *Checker) Check(now time.Time) error {
+	problems := c.Violations()
+	if c.maxGap > 0 {
+		for _, g := range c.Gaps(now) {
+			if g.Duration() > c.maxGap {
+				problems = append(problems, fmt.Sprintf("liveness: %v, longer than %v", g, c.maxGap))
+			}
+		}
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	return errors.New(strings.Join(problems, "\n"))
+}
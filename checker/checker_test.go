@@ -0,0 +1,79 @@
+package checker
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/gitferry/bamboo/crypto"
+	"github.com/gitferry/bamboo/identity"
+	"github.com/gitferry/bamboo/types"
+)
+
+var start = time.Unix(0, 0)
+
+func id(i byte) crypto.Identifier {
+	return crypto.Identifier{i}
+}
+
+func entry(view types.View, i byte, prev byte, at int) Entry {
+	return Entry{View: view, ID: id(i), PrevID: id(prev), At: start.Add(time.Duration(at) * time.Millisecond)}
+}
+
+func TestChecker_Agreement(t *testing.T) {
+	c := NewChecker([]identity.NodeID{"1", "2"}, start, 0)
+	c.Record("1", entry(1, 1, 0, 10))
+	c.Record("1", entry(2, 2, 1, 20))
+	c.Record("2", entry(1, 1, 0, 10))
+	// reported again
+	c.Record("2", entry(1, 1, 0, 30))
+	require.NoError(t, c.Check(start.Add(time.Second)))
+
+	// a different block at the second height and view
+	c.Record("2", entry(2, 3, 1, 40))
+	err := c.Check(start.Add(time.Second))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "agreement")
+	require.Contains(t, err.Error(), "conflict")
+}
+
+func TestChecker_Prefix(t *testing.T) {
+	c := NewChecker([]identity.NodeID{"1"}, start, 0)
+	c.Record("1", entry(1, 1, 0, 10))
+	c.Record("1", entry(3, 3, 2, 20))
+	require.Len(t, c.Violations(), 1)
+	require.True(t, strings.HasPrefix(c.Violations()[0], "prefix"))
+}
+
+func TestChecker_Gaps(t *testing.T) {
+	c := NewChecker([]identity.NodeID{"1", "2"}, start, 100*time.Millisecond)
+	c.Record("1", entry(1, 1, 0, 10))
+	c.Record("1", entry(2, 2, 1, 150))
+	c.Record("2", entry(1, 1, 0, 50))
+	gaps := c.Gaps(start.Add(120 * time.Millisecond))
+	require.Equal(t, []Gap{
+		{Replica: "1", From: start.Add(10 * time.Millisecond), To: start.Add(150 * time.Millisecond)},
+		{Replica: "2", From: start.Add(50 * time.Millisecond), To: start.Add(120 * time.Millisecond)},
+	}, gaps)
+	err := c.Check(start.Add(120 * time.Millisecond))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "replica 1 committed nothing for 140ms")
+	require.NotContains(t, err.Error(), "replica 2")
+}
+
+func TestParseLog(t *testing.T) {
+	logs := `[INFO] 2026/10/18 10:54:26.100000 replica.go:344: [1] the block is committed, No. of transactions: 3, view: 1, current view: 3, id: 0100000000000000000000000000000000000000000000000000000000000000, prevID: 0000000000000000000000000000000000000000000000000000000000000000
+[DEBUG] 2026/10/18 10:54:26.150000 replica.go:100: [2] received a block
+[INFO] 2026/10/18 10:54:26.200000 replica.go:344: [2] the block is committed, No. of transactions: 3, view: 1, current view: 3, id: 0200000000000000000000000000000000000000000000000000000000000000, prevID: 0000000000000000000000000000000000000000000000000000000000000000
+[INFO] 2026/10/18 10:54:26.300000 replica.go:344: [3] the block is committed, No. of transactions: 3, view: 1, current view: 3, id: 0300000000000000000000000000000000000000000000000000000000000000, prevID: 0000000000000000000000000000000000000000000000000000000000000000
+`
+	c := NewChecker(nil, time.Time{}, 0)
+	last, err := ParseLog(strings.NewReader(logs), c, func(id identity.NodeID) bool { return id != "3" })
+	require.NoError(t, err)
+	require.Equal(t, 200, last.Nanosecond()/int(time.Millisecond))
+	require.Equal(t, id(1), c.Log("1")[0].ID)
+	require.Empty(t, c.Log("3"))
+	require.Len(t, c.Violations(), 2)
+}
@@ -0,0 +1,67 @@
+package checker
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/gitferry/bamboo/crypto"
+	"github.com/gitferry/bamboo/identity"
+	"github.com/gitferry/bamboo/types"
+)
+
+// logTime is the layout of the timestamps of the log package
+const logTime = "2006/01/02 15:04:05.000000"
+
+// commitLine matches the commit logs of the replicas
+var commitLine = regexp.MustCompile(`^\[INFO\] (\S+ \S+) \S+ \[(\S+)\] the block is committed, .*view: (\d+), current view: \d+, id: ([0-9a-f]+), prevID: ([0-9a-f]+)`)
+
+// ParseLog feeds the commits found in the log of real nodes into the checker and returns the time of the last one,
+// the commits of the replicas that are not honest are skipped
+func ParseLog(r io.Reader, c *Checker, honest func(id identity.NodeID) bool) (time.Time, error) {
+	var last time.Time
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		m := commitLine.FindStringSubmatch(scanner.Text())
+		if m == nil {
+			continue
+		}
+		at, err := time.ParseInLocation(logTime, m[1], time.Local)
+		if err != nil {
+			return last, fmt.Errorf("cannot parse the time of the commit %q: %w", scanner.Text(), err)
+		}
+		id := identity.NodeID(m[2])
+		if !honest(id) {
+			continue
+		}
+		view, err := strconv.Atoi(m[3])
+		if err != nil {
+			return last, fmt.Errorf("cannot parse the view of the commit %q: %w", scanner.Text(), err)
+		}
+		e := Entry{View: types.View(view), At: at}
+		if e.ID, err = parseID(m[4]); err != nil {
+			return last, err
+		}
+		if e.PrevID, err = parseID(m[5]); err != nil {
+			return last, err
+		}
+		c.Record(id, e)
+		last = at
+	}
+	return last, scanner.Err()
+}
+
+func parseID(s string) (crypto.Identifier, error) {
+	var id crypto.Identifier
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) != len(id) {
+		return id, fmt.Errorf("invalid block id %v", s)
+	}
+	copy(id[:], b)
+	return id, nil
+}
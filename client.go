@@ -4,12 +4,19 @@ import (
 	"bytes"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
+	"math/rand"
 	"net/http"
 	"net/http/httputil"
+	"net/url"
+	"os"
+	"reflect"
 	"strconv"
-	"sync"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/gitferry/bamboo/config"
 	"github.com/gitferry/bamboo/db"
@@ -30,13 +37,14 @@ type AdminClient interface {
 	Crash(identity.NodeID, int)
 	Drop(identity.NodeID, identity.NodeID, int)
 	Partition(int, ...identity.NodeID)
+	Heal()
 }
 
 // HTTPClient implements Client interface with REST API
 type HTTPClient struct {
 	Addrs map[identity.NodeID]string
 	HTTP  map[identity.NodeID]string
-	ID    identity.NodeID // client id use the same id as servers in local site
+	ID    identity.NodeID // unique id of the client, it keys the commands of the client at the replicas
 	N     int             // total number of nodes
 
 	CID int // command id
@@ -46,16 +54,15 @@ type HTTPClient struct {
 // NewHTTPClient creates a new Client from config
 func NewHTTPClient() *HTTPClient {
 	c := &HTTPClient{
+		ID:     newClientID(),
 		N:      len(config.Configuration.Addrs),
 		Addrs:  config.Configuration.Addrs,
 		HTTP:   config.Configuration.HTTPAddrs,
 		Client: &http.Client{},
 	}
 	// will not send request to Byzantine nodes
-	bzn := config.GetConfig().ByzNo
-	if config.GetConfig().Strategy == "silence" {
-		for i := 1; i <= bzn; i++ {
-			id := identity.NewNodeID(i)
+	for id := range config.GetConfig().Addrs {
+		if config.GetConfig().HasStrategy(id, "silence") {
 			delete(c.Addrs, id)
 			delete(c.HTTP, id)
 		}
@@ -63,13 +70,21 @@ func NewHTTPClient() *HTTPClient {
 	return c
 }
 
+// newClientID returns an id that differs between the clients of a process and between runs,
+// so that the commands of distinct clients are neither deduplicated nor share a quota
+func newClientID() identity.NodeID {
+	n := atomic.AddUint64(&clients, 1)
+	return identity.NodeID(fmt.Sprintf("c%d.%d.%d", os.Getpid(), time.Now().UnixNano(), n))
+}
+
+var clients uint64
+
 // Get gets value of given key (use REST)
 // Default implementation of Client interface
 func (c *HTTPClient) Get(key db.Key) (string, error) {
 	c.CID++
-	//v, _, err := c.RESTGet(key)
-	//return v, err
-	return "", nil
+	v, err := c.RESTGet(key)
+	return string(v), err
 }
 
 // Put puts new key value pair and return previous value (use REST)
@@ -79,18 +94,19 @@ func (c *HTTPClient) Put(key db.Key, value db.Value) error {
 	return c.RESTPut(key, value)
 }
 
-//func (c *HTTPClient) GetURL(key db.Key) (identity.NodeID, string) {
-//	replicaID := config.GetConfig().Master
-//	if replicaID == "0" {
-//		keys := reflect.ValueOf(c.HTTP).MapKeys()
-//		replicaID = keys[rand.Intn(len(keys))].Interface().(identity.NodeID)
-//	}
-//	return replicaID, c.HTTP[replicaID] + "/" + strconv.Itoa(int(key))
-//}
+// GetURL returns the url of the key at the master, or at a random replica if there is no master
+func (c *HTTPClient) GetURL(key db.Key) (identity.NodeID, string) {
+	replicaID := config.GetConfig().Master
+	if replicaID == "0" || c.HTTP[replicaID] == "" {
+		keys := reflect.ValueOf(c.HTTP).MapKeys()
+		replicaID = keys[rand.Intn(len(keys))].Interface().(identity.NodeID)
+	}
+	return replicaID, c.HTTP[replicaID] + "/" + strconv.Itoa(int(key))
+}
 
 // rest accesses server's REST API with url = http://ip:port/key
 // if value == nil, it's a read
-func (c *HTTPClient) rest(url string, value db.Value) error {
+func (c *HTTPClient) rest(url string, value db.Value) (db.Value, error) {
 	method := http.MethodGet
 	var body io.Reader
 	if value != nil {
@@ -102,7 +118,7 @@ func (c *HTTPClient) rest(url string, value db.Value) error {
 	req, err := http.NewRequest(method, url, body)
 	if err != nil {
 		log.Error(err)
-		return err
+		return nil, err
 	}
 	req.Header.Set(node.HTTPClientID, string(c.ID))
 	req.Header.Set(node.HTTPCommandID, strconv.Itoa(c.CID))
@@ -112,28 +128,36 @@ func (c *HTTPClient) rest(url string, value db.Value) error {
 	rep, err := c.Client.Do(req)
 	if err != nil {
 		log.Error(err)
-		return err
+		return nil, err
 	}
 	defer rep.Body.Close()
 
 	if rep.StatusCode == http.StatusOK {
-		return nil
+		b, err := ioutil.ReadAll(rep.Body)
+		if err != nil {
+			log.Error(err)
+			return nil, err
+		}
+		return db.Value(b), nil
 	}
 
 	// http call failed
 	dump, _ := httputil.DumpResponse(rep, true)
 	log.Debugf("%q", dump)
-	return errors.New(rep.Status)
+	return nil, errors.New(rep.Status)
 }
 
-// RESTGet issues a http call to node and return value and headers
-//func (c *HTTPClient) RESTGet(key db.Key) (string, error) {
-//	return c.rest(key, nil)
-//}
+// RESTGet issues a http call to node and return value
+func (c *HTTPClient) RESTGet(key db.Key) (db.Value, error) {
+	_, url := c.GetURL(key)
+	return c.rest(url, nil)
+}
 
-// RESTPut puts new value as http.request body and return previous value
+// RESTPut puts new value as http.request body to a single replica, which gossips it to the others
 func (c *HTTPClient) RESTPut(key db.Key, value db.Value) error {
-	return c.AllPut(key, value)
+	_, url := c.GetURL(key)
+	_, err := c.rest(url, value)
+	return err
 }
 
 func (c *HTTPClient) json(id identity.NodeID, key db.Key, value db.Value) (db.Value, error) {
@@ -206,23 +230,6 @@ func (c *HTTPClient) JSONPut(key db.Key, value db.Value) (db.Value, error) {
 //	return values, metas
 //}
 
-// QuorumPut concurrently write values to majority of nodes
-// TODO get headers
-func (c *HTTPClient) AllPut(key db.Key, value db.Value) error {
-	var wait sync.WaitGroup
-	var err error
-	for id, ip := range c.HTTP {
-		wait.Add(1)
-		go func(id int, ip string) {
-			url := ip + "/" + strconv.Itoa(int(key)+id)
-			err = c.rest(url, value)
-			wait.Done()
-		}(id.Node(), ip)
-	}
-	wait.Wait()
-	return err
-}
-
 // Consensus collects /history/key from every node and compare their values
 func (c *HTTPClient) Consensus(k db.Key) bool {
 	h := make(map[identity.NodeID][]db.Value)
@@ -289,3 +296,41 @@ func (c *HTTPClient) Drop(from, to identity.NodeID, t int) {
 	}
 	r.Body.Close()
 }
+
+// Partition separates the nodes from the others for t seconds, for ever if t is not positive
+func (c *HTTPClient) Partition(t int, ids ...identity.NodeID) {
+	c.PartitionGroups(t, true, ids)
+}
+
+// PartitionGroups cuts the links between the groups of nodes for t seconds, the nodes in no group form one more group,
+// only the messages from a group to the groups after it are dropped if the partition is not symmetric
+func (c *HTTPClient) PartitionGroups(t int, symmetric bool, groups ...[]identity.NodeID) {
+	query := url.Values{}
+	query.Set("t", strconv.Itoa(t))
+	query.Set("symmetric", strconv.FormatBool(symmetric))
+	for _, g := range groups {
+		ids := make([]string, len(g))
+		for i, id := range g {
+			ids[i] = string(id)
+		}
+		query.Add("group", strings.Join(ids, ","))
+	}
+	c.admin("/partition?" + query.Encode())
+}
+
+// Heal removes the partition of every node
+func (c *HTTPClient) Heal() {
+	c.admin("/heal")
+}
+
+// admin sends the admin request to every node
+func (c *HTTPClient) admin(path string) {
+	for id := range c.HTTP {
+		r, err := c.Client.Get(c.HTTP[id] + path)
+		if err != nil {
+			log.Error(err)
+			continue
+		}
+		r.Body.Close()
+	}
+}
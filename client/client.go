@@ -19,6 +19,12 @@ func (d *Database) Stop() error {
 	return nil
 }
 
+func (d *Database) Read(k int) ([]byte, error) {
+	key := db.Key(k)
+	v, err := d.Get(key)
+	return []byte(v), err
+}
+
 func (d *Database) Write(k int, v []byte) error {
 	key := db.Key(k)
 	err := d.Put(key, v)
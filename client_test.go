@@ -0,0 +1,50 @@
+package bamboo
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/gitferry/bamboo/config"
+	"github.com/gitferry/bamboo/db"
+	"github.com/gitferry/bamboo/identity"
+	"github.com/gitferry/bamboo/mempool"
+	"github.com/gitferry/bamboo/message"
+	"github.com/gitferry/bamboo/node"
+)
+
+// the fair policy gives every client its own quota
+func TestHTTPClient_FairQuota(t *testing.T) {
+	config.Configuration.MempoolPolicy = mempool.FAIR
+	config.Configuration.ClientQuota = 1
+	defer func() {
+		config.Configuration.MempoolPolicy = mempool.FIFO
+		config.Configuration.ClientQuota = 0
+	}()
+	pd := mempool.NewProducer()
+	// admits the transaction the way a replica does, without waiting for its commit
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		txn := &message.Transaction{}
+		txn.Command.ClientID = identity.NodeID(r.Header.Get(node.HTTPClientID))
+		txn.Command.CommandID, _ = strconv.Atoi(r.Header.Get(node.HTTPCommandID))
+		txn.ID = fmt.Sprintf("%v-%v", txn.Command.ClientID, txn.Command.CommandID)
+		if err := pd.AddTxn(txn); err != nil {
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+		}
+	}))
+	defer srv.Close()
+	config.Configuration.HTTPAddrs = map[identity.NodeID]string{"1": srv.URL}
+	config.Configuration.Addrs = map[identity.NodeID]string{"1": "tcp://127.0.0.1:0"}
+
+	c1, c2 := NewHTTPClient(), NewHTTPClient()
+	require.NotEmpty(t, c1.ID)
+	require.NotEqual(t, c1.ID, c2.ID)
+
+	require.NoError(t, c1.Put(db.Key(1), db.Value("a")))
+	require.NoError(t, c2.Put(db.Key(1), db.Value("b")))
+	require.Error(t, c1.Put(db.Key(2), db.Value("c")))
+}
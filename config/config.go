@@ -30,29 +30,49 @@ type Config struct {
 	ChanBufferSize int             `json:"chan_buffer_size"` // buffer size for channels
 	MultiVersion   bool            `json:"multiversion"`     // create multi-version database
 	Timeout        int             `json:"timeout"`
+	TimeoutPolicy  string          `json:"timeout_policy"` // {fixed, backoff, adaptive}
+	MaxTimeout     int             `json:"max_timeout"`    // upper bound of the timeout in ms
 	ByzNo          int             `json:"byzNo"`
 	BSize          int             `json:"bsize"`
 	Fixed          bool            `json:"fixed"`
-	Benchmark      Bconfig         `json:"benchmark"` // benchmark configuration
-	Delta          int             `json:"delta"`     // timeout, seconds
+	Benchmark      Bconfig         `json:"benchmark"`  // benchmark configuration
+	Simulation     SimConfig       `json:"simulation"` // deterministic simulation configuration
+	Delta          int             `json:"delta"`      // timeout, seconds
 	Pprof          bool            `json:"pprof"`
 	MaxRound       int             `json:"maxRound"`
-	Strategy       string          `json:"strategy"`
+	Strategy       string          `json:"strategy"` // strategy of the first byzNo nodes, see also byzantine
 	PayloadSize    int             `json:"payload_size"`
 	Master         identity.NodeID `json:"master"`
-	Delay          int             `json:"delay"` // transmission delay in ms
-	DErr           int             `json:"derr"`  // the err taken into delays
+	Election       string          `json:"election"` // leader election when there is no master {rotation, roundrobin, reputation, vrf}
+	Delay          int             `json:"delay"`    // transmission delay in ms
+	DErr           int             `json:"derr"`     // the err taken into delays
 	MemSize        int             `json:"memsize"`
+	MempoolPolicy  string          `json:"mempool_policy"` // admission and ordering of transactions {fifo, priority, fair, ttl}
+	ClientQuota    int             `json:"client_quota"`   // queued transactions per client of the fair policy
+	TxnTTL         int             `json:"txn_ttl"`        // lifetime in ms of a queued transaction of the ttl policy
 	Slow           int             `json:"slow"`
 	Crash          int             `json:"crash"`
-
-	hasher string
-	signer string
+	Hasher         string          `json:"hasher"`      // hashing scheme, e.g., sha3_256
+	Signer         string          `json:"signer"`      // signature scheme, e.g., ECDSA_P256 or BLS_BLS12381
+	Codec          string          `json:"codec"`       // codec for message serialization between nodes {gob, json, binary}
+	Security       string          `json:"security"`    // security of the connections between nodes {none, tls}
+	KeyDir         string          `json:"key_dir"`     // directory of the tls keys of the nodes, required by tls
+	SendPolicy     string          `json:"send_policy"` // policy when the send queue to a peer is full {block, drop}
+	Store          string          `json:"store"`       // block store {memory, log}
+	StoreDir       string          `json:"store_dir"`   // directory of the block store, one sub-directory per node
+	ReadMode       string          `json:"read_mode"`   // how reads are served {consensus, lease}
+	Lease          int             `json:"lease"`       // leader lease in ms, must be shorter than the timeout
+	Mempool        string          `json:"mempool"`     // mempool subsystem {default, narwhal}
+	BatchSize      int             `json:"batch_size"`  // number of transactions per batch of the narwhal mempool
+	BatchDelay     int             `json:"batch_delay"` // interval in ms to seal a batch and to create a header
+
+	Byzantine map[identity.NodeID][]string `json:"byzantine"` // strategies of individual Byzantine nodes, e.g., {"1": ["equivocate", "double_vote"]}
+	Faults    []Fault                      `json:"faults"`    // timeline of the faults injected by the replicas
+	WAN       WANConfig                    `json:"wan"`       // emulated wide area network between the nodes
 
 	// for future implementation
 	// Batching bool `json:"batching"`
 	// Consistency string `json:"consistency"`
-	// Codec string `json:"codec"` // codec for message serialization between nodes
 
 	n int // total number of nodes
 	//z   int         // total number of zones
@@ -64,14 +84,17 @@ type Config struct {
 
 // Bconfig holds all benchmark configuration
 type Bconfig struct {
-	T            int    // total number of running time in seconds
-	N            int    // total number of requests
-	K            int    // key sapce
-	Throttle     int    // requests per second throttle, unused if 0
-	Concurrency  int    // number of simulated clients
-	Distribution string // distribution
+	T            int     // total number of running time in seconds
+	N            int     // total number of requests
+	K            int     // key sapce
+	Throttle     int     // requests per second throttle, unused if 0
+	Concurrency  int     // number of simulated clients
+	Distribution string  // distribution
+	W            float64 // write ratio
 	// rounds       int    // repeat in many rounds sequentially
 
+	LinearizabilityCheck bool // check the linearizability of the recorded operations
+
 	// conflict distribution
 	Conflicts int // percentage of conflicting keys
 	Min       int // min key
@@ -83,6 +106,33 @@ type Bconfig struct {
 	Speed int     // moving speed in milliseconds intervals per key
 }
 
+// SimConfig holds the configuration of the deterministic simulator
+type SimConfig struct {
+	Seed     int64                    `json:"seed"`     // seed of the scheduler, the same seed replays the same execution
+	Duration int                      `json:"duration"` // virtual running time in ms
+	Rate     int                      `json:"rate"`     // transactions submitted per virtual second
+	Latency  LatencyConfig            `json:"latency"`  // latency of every link
+	Links    map[string]LatencyConfig `json:"links"`    // latency of the links given as "from-to", e.g., "1-2"
+	MaxGap   int                      `json:"max_gap"`  // longest time in ms an honest replica may go without commits, unchecked if 0
+}
+
+// LatencyConfig describes the distribution of the one-way delay of a link, in ms
+type LatencyConfig struct {
+	Distribution string  `json:"distribution"` // {constant, uniform, normal, exponential}
+	Mean         float64 `json:"mean"`         // mean of constant, normal and exponential delays
+	Std          float64 `json:"std"`          // standard deviation of normal delays
+	Min          float64 `json:"min"`          // lower bound of uniform delays
+	Max          float64 `json:"max"`          // upper bound of uniform delays
+}
+
+// WANConfig emulates a wide area network on the links of the sockets, the links are given as "from-to" between regions,
+// e.g., "us-eu", or "*" for every other link, and the reverse link is the same unless it is given
+type WANConfig struct {
+	Regions   map[identity.NodeID]string `json:"regions"`   // region of the nodes, a node without one is a region named by its id
+	Latency   map[string]LatencyConfig   `json:"latency"`   // one-way latency of the links, in ms, delay and derr apply to the other links
+	Bandwidth map[string]float64         `json:"bandwidth"` // bandwidth of the links in Mbit/s, unlimited if not given
+}
+
 // Config is global configuration singleton generated by init() func below
 var Configuration Config
 
@@ -113,8 +163,28 @@ func MakeDefaultConfig() Config {
 		BufferSize:     1024,
 		ChanBufferSize: 1024,
 		MultiVersion:   false,
-		hasher:         "sha3_256",
-		signer:         "ECDSA_P256",
+		Hasher:         "sha3_256",
+		Signer:         "ECDSA_P256",
+		Store:          "memory",
+		StoreDir:       "data",
+		Codec:          "gob",
+		Security:       "none",
+		SendPolicy:     "block",
+		ReadMode:       "consensus",
+		Election:       "rotation",
+		TimeoutPolicy:  "fixed",
+		Mempool:        "default",
+		MempoolPolicy:  "fifo",
+		ClientQuota:    10,
+		TxnTTL:         5000,
+		BatchSize:      100,
+		BatchDelay:     50,
+		Simulation: SimConfig{
+			Seed:     1,
+			Duration: 10000,
+			Rate:     100,
+			Latency:  LatencyConfig{Distribution: "constant", Mean: 5},
+		},
 		//Benchmark:      DefaultBConfig(),
 	}
 }
@@ -153,11 +223,11 @@ func (c Config) N() int {
 
 // GetHash returns the hashing scheme of the configuration
 func (c Config) GetHashScheme() string {
-	return c.hasher
+	return c.Hasher
 }
 
 func (c Config) GetSignatureScheme() string {
-	return c.signer
+	return c.Signer
 }
 
 // GetSignatureScheme returns the signing scheme of the configuration
@@ -188,6 +258,19 @@ func (c *Config) Load() {
 	if err != nil {
 		log.Fatal(err)
 	}
+	set := setFlags()
+	if c.Codec != "" && !set["codec"] {
+		*transport.CodecName = c.Codec
+	}
+	if c.Security != "" && !set["security"] {
+		*transport.Security = c.Security
+	}
+	if c.KeyDir != "" && !set["keys"] {
+		*transport.KeyDir = c.KeyDir
+	}
+	if c.SendPolicy != "" && !set["send_policy"] {
+		*transport.SendPolicy = c.SendPolicy
+	}
 
 	// load ips
 	ip_file, err := os.Open("ips.txt")
@@ -216,6 +299,35 @@ func (c *Config) Load() {
 	c.n = len(c.Addrs)
 }
 
+// setFlags returns the names of the flags set on the command line, which take precedence over the configuration file
+func setFlags() map[string]bool {
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	return set
+}
+
+// Fault is a fault injected by the replicas at a time or when they enter a view
+type Fault struct {
+	At       int               `json:"at"`       // seconds since the start of the replica, unused if view is set
+	View     int               `json:"view"`     // view entered by the replica
+	Action   string            `json:"action"`   // {crash, drop, slow, flaky, partition}
+	Nodes    []identity.NodeID `json:"nodes"`    // nodes injecting the fault, or one side of the partition, every node if empty
+	Peers    []identity.NodeID `json:"peers"`    // links of the nodes affected by the fault, or the other side of the partition, every other node if empty
+	Duration int               `json:"duration"` // seconds, for ever if it is not positive
+	Delay    int               `json:"delay"`    // delay of slow links in ms
+	P        float64           `json:"p"`        // drop probability of flaky links
+}
+
+func (f Fault) String() string {
+	when := fmt.Sprintf("at %vs", f.At)
+	if f.View > 0 {
+		when = fmt.Sprintf("at view %v", f.View)
+	}
+	return fmt.Sprintf("%v %v nodes %v peers %v for %vs", when, f.Action, f.Nodes, f.Peers, f.Duration)
+}
+
 // Save saves configuration to file in JSON format
 func (c Config) Save() error {
 	file, err := os.Create(*configFile)
@@ -226,6 +338,29 @@ func (c Config) Save() error {
 	return encoder.Encode(c)
 }
 
+// IsByzantine returns true if the node is one of the first byzNo nodes or has its own strategies
 func (c Config) IsByzantine(id identity.NodeID) bool {
-	return c.ByzNo >= id.Node()
+	_, ok := c.Byzantine[id]
+	return ok || c.ByzNo >= id.Node()
+}
+
+// Strategies returns the Byzantine strategies of the node, the strategies given for the node take precedence over strategy
+func (c Config) Strategies(id identity.NodeID) []string {
+	if s, ok := c.Byzantine[id]; ok {
+		return s
+	}
+	if c.ByzNo >= id.Node() && c.Strategy != "" {
+		return []string{c.Strategy}
+	}
+	return nil
+}
+
+// HasStrategy returns true if the node follows the Byzantine strategy
+func (c Config) HasStrategy(id identity.NodeID, strategy string) bool {
+	for _, s := range c.Strategies(id) {
+		if s == strategy {
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,57 @@
+package config
+
+import (
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/gitferry/bamboo/transport"
+)
+
+func load(t *testing.T, c Config) {
+	dir, err := ioutil.TempDir("", "config")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	data, err := json.Marshal(c)
+	require.NoError(t, err)
+	file := filepath.Join(dir, "config.json")
+	require.NoError(t, ioutil.WriteFile(file, data, 0644))
+	require.NoError(t, flag.Set("config", file))
+	Configuration = Config{}
+	Configuration.Load()
+}
+
+// the transport flags set on the command line take precedence over the configuration file
+func TestLoad_Flags(t *testing.T) {
+	defer func() {
+		*transport.CodecName = transport.GOB
+		*transport.Security = transport.NONE
+		*transport.KeyDir = ""
+		*transport.SendPolicy = transport.BLOCK
+	}()
+	c := MakeDefaultConfig()
+	c.Codec = transport.JSON
+	c.Security = transport.TLS
+	c.KeyDir = "keys"
+	c.SendPolicy = transport.DROP
+	load(t, c)
+	require.Equal(t, transport.JSON, *transport.CodecName)
+	require.Equal(t, transport.TLS, *transport.Security)
+	require.Equal(t, "keys", *transport.KeyDir)
+	require.Equal(t, transport.DROP, *transport.SendPolicy)
+
+	require.NoError(t, flag.Set("codec", transport.BINARY))
+	require.NoError(t, flag.Set("security", transport.NONE))
+	require.NoError(t, flag.Set("keys", "node-keys"))
+	require.NoError(t, flag.Set("send_policy", transport.BLOCK))
+	load(t, c)
+	require.Equal(t, transport.BINARY, *transport.CodecName)
+	require.Equal(t, transport.NONE, *transport.Security)
+	require.Equal(t, "node-keys", *transport.KeyDir)
+	require.Equal(t, transport.BLOCK, *transport.SendPolicy)
+}
@@ -0,0 +1,54 @@
+package crypto
+
+import (
+	"github.com/gitferry/bamboo/identity"
+)
+
+// Bitmap records which nodes contributed to an aggregated signature.
+// Bit i stands for node i+1.
+type Bitmap []byte
+
+// NewBitmap creates an empty bitmap for n nodes
+func NewBitmap(n int) Bitmap {
+	return make(Bitmap, (n+7)/8)
+}
+
+// Set marks the node as a signer
+func (b Bitmap) Set(id identity.NodeID) {
+	i := id.Node() - 1
+	if i < 0 || i/8 >= len(b) {
+		return
+	}
+	b[i/8] |= 1 << uint(i%8)
+}
+
+// Has checks if the node is a signer
+func (b Bitmap) Has(id identity.NodeID) bool {
+	i := id.Node() - 1
+	if i < 0 || i/8 >= len(b) {
+		return false
+	}
+	return b[i/8]&(1<<uint(i%8)) != 0
+}
+
+// Count returns the number of signers
+func (b Bitmap) Count() int {
+	count := 0
+	for _, byt := range b {
+		for ; byt != 0; byt &= byt - 1 {
+			count++
+		}
+	}
+	return count
+}
+
+// Signers returns the signers in ascending order of node id
+func (b Bitmap) Signers() []identity.NodeID {
+	var signers []identity.NodeID
+	for i := 0; i < len(b)*8; i++ {
+		if b[i/8]&(1<<uint(i%8)) != 0 {
+			signers = append(signers, identity.NewNodeID(i+1))
+		}
+	}
+	return signers
+}
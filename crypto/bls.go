@@ -0,0 +1,194 @@
+package crypto
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/crypto/bls12381"
+
+	"github.com/gitferry/bamboo/identity"
+)
+
+// BLS signatures live in G1 and public keys in G2 (the "minimal-signature-size" variant),
+// so that the aggregated signature carried by a QC stays as small as possible.
+
+// blsDST is the domain separation tag used when hashing messages to G1
+var blsDST = []byte("BAMBOO_BLS_SIG_BLS12381G1_XMD:SHA3-512_SSWU_RO_")
+
+// field modulus p of BLS12-381
+var blsFieldModulus, _ = new(big.Int).SetString("1a0111ea397fe69a4b1ba7b6434bacd764774b84f38512bf6730d2a0f6b0f6241eabfffeb153ffffb9feffffffffaaab", 16)
+
+// group order r of BLS12-381
+var blsGroupOrder, _ = new(big.Int).SetString("73eda753299d7d483339d80809a1d80553bda402fffe5bfeffffffff00000001", 16)
+
+const blsSignatureLen = 96
+
+type bls12381PrivateKey struct {
+	SignAlg    string
+	PrivateKey *big.Int
+	pub        *bls12381PublicKey
+}
+
+type bls12381PublicKey struct {
+	SignAlg   string
+	PublicKey *bls12381.PointG2
+}
+
+// generateBLSKey derives a key pair from the node id so that every replica
+// computes the same set of keys, as is done for ECDSA with StaticRand
+func generateBLSKey(id identity.NodeID) (*bls12381PrivateKey, error) {
+	seed := NewSHA3_256().ComputeHash([]byte("bamboo-bls-key-" + string(id)))
+	sk := new(big.Int).SetBytes(seed)
+	sk.Mod(sk, blsGroupOrder)
+	if sk.Sign() == 0 {
+		return nil, errors.New("invalid BLS private key")
+	}
+	g2 := bls12381.NewG2()
+	pk := g2.New()
+	g2.MulScalar(pk, g2.One(), sk)
+	priv := &bls12381PrivateKey{
+		SignAlg:    BLS_BLS12381,
+		PrivateKey: sk,
+		pub:        &bls12381PublicKey{SignAlg: BLS_BLS12381, PublicKey: pk},
+	}
+	return priv, nil
+}
+
+func (priv *bls12381PrivateKey) Algorithm() string {
+	return priv.SignAlg
+}
+
+func (priv *bls12381PrivateKey) PublicKey() PublicKey {
+	return priv.pub
+}
+
+// Sign hashes the message onto G1 and multiplies the point by the private key.
+// The signature is a single uncompressed G1 point.
+func (priv *bls12381PrivateKey) Sign(msg []byte, hasher Hasher) (Signature, error) {
+	if hasher != nil {
+		msg = hasher.ComputeHash(msg)
+	}
+	h, err := hashToG1(msg)
+	if err != nil {
+		return nil, err
+	}
+	g1 := bls12381.NewG1()
+	sig := g1.New()
+	g1.MulScalar(sig, h, priv.PrivateKey)
+	return Signature{g1.ToBytes(sig)}, nil
+}
+
+func (pub *bls12381PublicKey) Algorithm() string {
+	return pub.SignAlg
+}
+
+// Verify checks e(sig, g2) == e(H(m), pk)
+func (pub *bls12381PublicKey) Verify(sig Signature, hash Hash) (bool, error) {
+	return verifyBLS(sig, hash, pub.PublicKey)
+}
+
+func verifyBLS(sig Signature, msg []byte, pk *bls12381.PointG2) (bool, error) {
+	return verifyBLSPairs(sig, [][]byte{msg}, []*bls12381.PointG2{pk})
+}
+
+// verifyBLSPairs checks e(sig, g2) == e(H(m_1), pk_1) * ... * e(H(m_k), pk_k)
+func verifyBLSPairs(sig Signature, msgs [][]byte, pks []*bls12381.PointG2) (bool, error) {
+	if len(sig) != 1 || len(sig[0]) != blsSignatureLen {
+		return false, errors.New("malformed BLS signature")
+	}
+	g1 := bls12381.NewG1()
+	s, err := g1.FromBytes(sig[0])
+	if err != nil {
+		return false, err
+	}
+	if !g1.InCorrectSubgroup(s) {
+		return false, errors.New("BLS signature is not in the correct subgroup")
+	}
+	engine := bls12381.NewPairingEngine()
+	engine.AddPair(s, engine.G2.One())
+	for i, msg := range msgs {
+		h, err := hashToG1(msg)
+		if err != nil {
+			return false, err
+		}
+		engine.AddPairInv(h, pks[i])
+	}
+	return engine.Check(), nil
+}
+
+// aggregateBLS adds up the given G1 signatures into a single one
+func aggregateBLS(sigs []Signature) (Signature, error) {
+	if len(sigs) == 0 {
+		return nil, errors.New("no signature to aggregate")
+	}
+	g1 := bls12381.NewG1()
+	agg := g1.Zero()
+	for _, sig := range sigs {
+		if len(sig) != 1 {
+			return nil, errors.New("malformed BLS signature")
+		}
+		p, err := g1.FromBytes(sig[0])
+		if err != nil {
+			return nil, err
+		}
+		g1.Add(agg, agg, p)
+	}
+	return Signature{g1.ToBytes(agg)}, nil
+}
+
+// verifyAggregateBLS verifies an aggregated signature over the same message
+// against the sum of the signers' public keys
+func verifyAggregateBLS(sig Signature, msg []byte, signers []identity.NodeID) (bool, error) {
+	if len(signers) == 0 {
+		return false, errors.New("no signer for the aggregated signature")
+	}
+	g2 := bls12381.NewG2()
+	aggPub := g2.Zero()
+	for _, signer := range signers {
+		i := signer.Node() - 1
+		if i < 0 || i >= len(pubKeys) {
+			return false, fmt.Errorf("no public key for node %v", signer)
+		}
+		pub, ok := pubKeys[i].(*bls12381PublicKey)
+		if !ok {
+			return false, errors.New("public key is not a BLS key")
+		}
+		g2.Add(aggPub, aggPub, pub.PublicKey)
+	}
+	return verifyBLS(sig, msg, aggPub)
+}
+
+// verifyAggregateBLSMulti verifies an aggregated signature in which each signer
+// signed a different message
+func verifyAggregateBLSMulti(sig Signature, msgs [][]byte, signers []identity.NodeID) (bool, error) {
+	if len(signers) == 0 {
+		return false, errors.New("no signer for the aggregated signature")
+	}
+	pks := make([]*bls12381.PointG2, 0, len(signers))
+	for _, signer := range signers {
+		i := signer.Node() - 1
+		if i < 0 || i >= len(pubKeys) {
+			return false, fmt.Errorf("no public key for node %v", signer)
+		}
+		pub, ok := pubKeys[i].(*bls12381PublicKey)
+		if !ok {
+			return false, errors.New("public key is not a BLS key")
+		}
+		pks = append(pks, pub.PublicKey)
+	}
+	return verifyBLSPairs(sig, msgs, pks)
+}
+
+// hashToG1 hashes the message into a field element and maps it onto the curve
+func hashToG1(msg []byte) (*bls12381.PointG1, error) {
+	data := make([]byte, 0, len(blsDST)+len(msg))
+	data = append(data, blsDST...)
+	data = append(data, msg...)
+	e := new(big.Int).SetBytes(NewSHA3_512().ComputeHash(data))
+	e.Mod(e, blsFieldModulus)
+	b := e.Bytes()
+	fe := make([]byte, 48)
+	copy(fe[48-len(b):], b)
+	return bls12381.NewG1().MapToCurve(fe)
+}
@@ -0,0 +1,132 @@
+package crypto
+
+import (
+	"testing"
+
+	"github.com/gitferry/bamboo/config"
+	"github.com/gitferry/bamboo/identity"
+	"github.com/stretchr/testify/require"
+)
+
+func setBLSKeys(t *testing.T, n int) {
+	require.NoError(t, SetKeysWith(n, BLS_BLS12381))
+}
+
+func TestBLS_SignVerify(t *testing.T) {
+	setBLSKeys(t, 1)
+	msg := []byte("block id")
+	sig, err := PrivSign(msg, "1", nil)
+	require.NoError(t, err)
+	ok, err := PubVerify(sig, msg, "1")
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	ok, err = PubVerify(sig, []byte("another block id"), "1")
+	require.NoError(t, err)
+	require.False(t, ok)
+}
+
+func TestBLS_AggregateVerify(t *testing.T) {
+	setBLSKeys(t, 4)
+	msg := []byte("block id")
+	signers := NewBitmap(4)
+	var sigs []Signature
+	for _, id := range []identity.NodeID{"1", "2", "4"} {
+		sig, err := PrivSign(msg, id, nil)
+		require.NoError(t, err)
+		sigs = append(sigs, sig)
+		signers.Set(id)
+	}
+	aggSig, err := aggregateBLS(sigs)
+	require.NoError(t, err)
+	ok, err := verifyAggregateBLS(aggSig, msg, signers.Signers())
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	// the bitmap does not match the signers
+	wrong := NewBitmap(4)
+	wrong.Set("1")
+	wrong.Set("2")
+	wrong.Set("3")
+	ok, err = verifyAggregateBLS(aggSig, msg, wrong.Signers())
+	require.NoError(t, err)
+	require.False(t, ok)
+}
+
+func TestBLS_AggregateVerifyMulti(t *testing.T) {
+	setBLSKeys(t, 4)
+	signers := NewBitmap(4)
+	var sigs []Signature
+	var msgs [][]byte
+	for _, id := range []identity.NodeID{"1", "3", "4"} {
+		msg := []byte("timeout from " + string(id))
+		sig, err := PrivSign(msg, id, nil)
+		require.NoError(t, err)
+		sigs = append(sigs, sig)
+		msgs = append(msgs, msg)
+		signers.Set(id)
+	}
+	aggSig, err := aggregateBLS(sigs)
+	require.NoError(t, err)
+	ok, err := verifyAggregateBLSMulti(aggSig, msgs, signers.Signers())
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	msgs[0], msgs[1] = msgs[1], msgs[0]
+	ok, err = verifyAggregateBLSMulti(aggSig, msgs, signers.Signers())
+	require.NoError(t, err)
+	require.False(t, ok)
+}
+
+func TestVerifyQuorumSignature(t *testing.T) {
+	config.Configuration.Signer = BLS_BLS12381
+	setBLSKeys(t, 4)
+	id := MakeID("block")
+	sign := func(ids ...identity.NodeID) (AggSig, Bitmap) {
+		signers := NewBitmap(4)
+		var sigs []Signature
+		for _, i := range ids {
+			sig, err := PrivSign(IDToByte(id), i, nil)
+			require.NoError(t, err)
+			sigs = append(sigs, sig)
+			signers.Set(i)
+		}
+		aggSig, err := AggregateSignatures(sigs)
+		require.NoError(t, err)
+		return aggSig, signers
+	}
+
+	aggSig, signers := sign("1", "2", "4")
+	ok, err := VerifyQuorumSignature(aggSig, id, signers)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	// two valid signatures out of four nodes are not a quorum
+	aggSig, signers = sign("1", "2")
+	ok, err = VerifyQuorumSignature(aggSig, id, signers)
+	require.Error(t, err)
+	require.False(t, ok)
+
+	// a bitmap longer than the nodes or naming an unknown node is rejected without indexing the keys
+	aggSig, _ = sign("1", "2", "3")
+	long := Bitmap{0x07, 0x01}
+	ok, err = VerifyQuorumSignature(aggSig, id, long)
+	require.Error(t, err)
+	require.False(t, ok)
+	unknown := Bitmap{0x83}
+	ok, err = VerifyQuorumSignature(aggSig, id, unknown)
+	require.Error(t, err)
+	require.False(t, ok)
+}
+
+func TestBitmap(t *testing.T) {
+	b := NewBitmap(10)
+	b.Set("1")
+	b.Set("9")
+	b.Set("17")
+	require.True(t, b.Has("1"))
+	require.True(t, b.Has("9"))
+	require.False(t, b.Has("2"))
+	require.Equal(t, 2, b.Count())
+	require.Equal(t, []identity.NodeID{"1", "9"}, b.Signers())
+}
@@ -4,6 +4,8 @@ import (
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"errors"
+	"fmt"
+
 	"github.com/gitferry/bamboo/config"
 	"github.com/gitferry/bamboo/identity"
 )
@@ -63,11 +65,16 @@ func (sr *StaticRand) Read(x []byte) (int, error) {
 }
 
 func SetKeys() error {
-	keys = make([]PrivateKey, config.GetConfig().N())
-	pubKeys = make([]PublicKey, config.GetConfig().N())
+	return SetKeysWith(config.GetConfig().N(), config.GetConfig().GetSignatureScheme())
+}
+
+// SetKeysWith generates the keys of n nodes under the given signature scheme
+func SetKeysWith(n int, signer string) error {
+	keys = make([]PrivateKey, n)
+	pubKeys = make([]PublicKey, n)
 	var err error
-	for i := 0; i < config.GetConfig().N(); i++ {
-		keys[i], err = GenerateKey(config.GetConfig().GetSignatureScheme(), identity.NewNodeID(i+1))
+	for i := 0; i < n; i++ {
+		keys[i], err = GenerateKey(signer, identity.NewNodeID(i+1))
 		if err != nil {
 			return err
 		}
@@ -89,7 +96,7 @@ func GenerateKey(signer string, id identity.NodeID) (PrivateKey, error) {
 	} else if signer == ECDSA_SECp256k1 {
 		return nil, nil
 	} else if signer == BLS_BLS12381 {
-		return nil, nil
+		return generateBLSKey(id)
 	} else {
 		return nil, errors.New("Invalid signature scheme!")
 	}
@@ -102,14 +109,66 @@ func PrivSign(data []byte, nodeID identity.NodeID, hasher Hasher) (Signature, er
 }
 
 func PubVerify(sig Signature, data []byte, nodeID identity.NodeID) (bool, error) {
-	return pubKeys[nodeID.Node()-1].Verify(sig, data)
+	i := nodeID.Node() - 1
+	if i < 0 || i >= len(pubKeys) {
+		return false, fmt.Errorf("no public key for node %v", nodeID)
+	}
+	return pubKeys[i].Verify(sig, data)
+}
+
+// quorumSigners returns the signers recorded in the bitmap after checking that
+// every one of them is a known node and that they form a quorum of more than 2/3 of the nodes
+func quorumSigners(aggSigners Bitmap) ([]identity.NodeID, error) {
+	n := len(pubKeys)
+	if len(aggSigners) > (n+7)/8 {
+		return nil, fmt.Errorf("the bitmap of %v bytes is too long for %v nodes", len(aggSigners), n)
+	}
+	signers := aggSigners.Signers()
+	for _, signer := range signers {
+		if signer.Node() < 1 || signer.Node() > n {
+			return nil, fmt.Errorf("unknown signer %v", signer)
+		}
+	}
+	if len(signers)*3 <= n*2 {
+		return nil, fmt.Errorf("%v signers do not form a quorum of %v nodes", len(signers), n)
+	}
+	return signers, nil
+}
+
+// AggregateSignatures combines the signatures of a quorum into an AggSig.
+// BLS signatures are collapsed into a single one, while signatures of other
+// schemes are kept one per signer, in the same order as the signers.
+func AggregateSignatures(sigs []Signature) (AggSig, error) {
+	if config.GetConfig().GetSignatureScheme() != BLS_BLS12381 {
+		return sigs, nil
+	}
+	aggSig, err := aggregateBLS(sigs)
+	if err != nil {
+		return nil, err
+	}
+	return AggSig{aggSig}, nil
 }
 
-func VerifyQuorumSignature(aggregatedSigs AggSig, blockID Identifier, aggSigners []identity.NodeID) (bool, error) {
+// VerifyQuorumSignature verifies the aggregated signature over the message
+// against the signers recorded in the bitmap, which must form a quorum
+func VerifyQuorumSignature(aggregatedSigs AggSig, msg Identifier, aggSigners Bitmap) (bool, error) {
+	signers, err := quorumSigners(aggSigners)
+	if err != nil {
+		return false, err
+	}
+	if config.GetConfig().GetSignatureScheme() == BLS_BLS12381 {
+		if len(aggregatedSigs) != 1 {
+			return false, errors.New("a BLS quorum should carry exactly one signature")
+		}
+		return verifyAggregateBLS(aggregatedSigs[0], IDToByte(msg), signers)
+	}
+	if len(aggregatedSigs) != len(signers) {
+		return false, errors.New("the number of signatures does not match the number of signers")
+	}
 	var sigIsCorrect bool
 	var errAgg error
-	for i, signer := range aggSigners {
-		sigIsCorrect, errAgg = PubVerify(aggregatedSigs[i], IDToByte(blockID), signer)
+	for i, signer := range signers {
+		sigIsCorrect, errAgg = PubVerify(aggregatedSigs[i], IDToByte(msg), signer)
 		if errAgg != nil {
 			return false, errAgg
 		}
@@ -119,3 +178,34 @@ func VerifyQuorumSignature(aggregatedSigs AggSig, blockID Identifier, aggSigners
 	}
 	return true, nil
 }
+
+// VerifyAggregateSignature verifies an aggregated signature in which every signer
+// signed its own message, msgs are ordered as the signers in the bitmap
+func VerifyAggregateSignature(aggregatedSigs AggSig, msgs [][]byte, aggSigners Bitmap) (bool, error) {
+	signers, err := quorumSigners(aggSigners)
+	if err != nil {
+		return false, err
+	}
+	if len(msgs) != len(signers) {
+		return false, errors.New("the number of messages does not match the number of signers")
+	}
+	if config.GetConfig().GetSignatureScheme() == BLS_BLS12381 {
+		if len(aggregatedSigs) != 1 {
+			return false, errors.New("a BLS quorum should carry exactly one signature")
+		}
+		return verifyAggregateBLSMulti(aggregatedSigs[0], msgs, signers)
+	}
+	if len(aggregatedSigs) != len(signers) {
+		return false, errors.New("the number of signatures does not match the number of signers")
+	}
+	for i, signer := range signers {
+		sigIsCorrect, err := PubVerify(aggregatedSigs[i], msgs[i], signer)
+		if err != nil {
+			return false, err
+		}
+		if !sigIsCorrect {
+			return false, nil
+		}
+	}
+	return true, nil
+}
@@ -0,0 +1,40 @@
+package db
+
+import (
+	"sync"
+)
+
+// StateMachine executes the commands of committed blocks on top of a Database
+type StateMachine interface {
+	Database
+	// Apply executes the commands in order and returns the result of each command
+	Apply(cmds []Command) []Value
+}
+
+type stateMachine struct {
+	Database
+	mu sync.Mutex
+}
+
+// NewStateMachine creates a state machine backed by a new database
+func NewStateMachine() StateMachine {
+	return &stateMachine{
+		Database: NewDatabase(),
+	}
+}
+
+// Apply executes a batch of commands. A read returns the current value of its key,
+// a write returns the previous value.
+func (s *stateMachine) Apply(cmds []Command) []Value {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	results := make([]Value, len(cmds))
+	for i, cmd := range cmds {
+		if cmd.IsRead() {
+			results[i] = s.Get(cmd.Key)
+			continue
+		}
+		results[i] = s.Execute(cmd)
+	}
+	return results
+}
@@ -0,0 +1,20 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStateMachine_Apply(t *testing.T) {
+	sm := NewStateMachine()
+	results := sm.Apply([]Command{
+		{Key: 1, Value: []byte("a")},
+		{Key: 1, Value: []byte("b")},
+		{Key: 1},
+	})
+	require.Nil(t, results[0])
+	require.Equal(t, Value("a"), results[1])
+	require.Equal(t, Value("b"), results[2])
+	require.Equal(t, Value("b"), sm.Get(1))
+}
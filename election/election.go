@@ -1,11 +1,41 @@
 package election
 
 import (
+	"github.com/gitferry/bamboo/blockchain"
 	"github.com/gitferry/bamboo/identity"
 	"github.com/gitferry/bamboo/types"
 )
 
+// election schemes
+const (
+	ROTATION   = "rotation"
+	ROUNDROBIN = "roundrobin"
+	REPUTATION = "reputation"
+	VRF        = "vrf"
+)
+
 type Election interface {
 	IsLeader(id identity.NodeID, view types.View) bool
 	FindLeaderFor(view types.View) identity.NodeID
 }
+
+// Adaptive is implemented by the elections that learn from the committed chain,
+// the replica reports every committed block in the commit order
+type Adaptive interface {
+	Election
+	Commit(block *blockchain.Block)
+}
+
+// NewElection creates the election scheme among peerNo nodes
+func NewElection(scheme string, peerNo int) Election {
+	switch scheme {
+	case ROUNDROBIN:
+		return NewRoundRobin(peerNo)
+	case REPUTATION:
+		return NewReputation(peerNo)
+	case VRF:
+		return NewVrf(peerNo)
+	default:
+		return NewRotation(peerNo)
+	}
+}
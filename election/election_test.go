@@ -3,7 +3,11 @@ package election
 import (
 	"fmt"
 	"testing"
+	"time"
 
+	"github.com/gitferry/bamboo/blockchain"
+	"github.com/gitferry/bamboo/crypto"
+	"github.com/gitferry/bamboo/identity"
 	"github.com/gitferry/bamboo/types"
 	"github.com/stretchr/testify/require"
 )
@@ -28,3 +32,93 @@ func TestRotation_LeaderList(t *testing.T) {
 		fmt.Printf("view: %v, node id: %v\n", i, leaderID.Node())
 	}
 }
+
+func TestRoundRobin_FindLeaderFor(t *testing.T) {
+	elect := NewRoundRobin(4)
+	for i := 1; i <= 8; i++ {
+		leaderID := elect.FindLeaderFor(types.View(i))
+		require.Equal(t, i%4+1, leaderID.Node())
+		require.True(t, elect.IsLeader(leaderID, types.View(i)))
+	}
+}
+
+func makeCommitted(view types.View, proposer identity.NodeID, signers ...identity.NodeID) *blockchain.Block {
+	bm := crypto.NewBitmap(4)
+	for _, id := range signers {
+		bm.Set(id)
+	}
+	return &blockchain.Block{
+		View:     view,
+		Proposer: proposer,
+		QC:       &blockchain.QC{View: view - 1, Signers: bm},
+	}
+}
+
+// a crashed node which neither proposes nor votes is never elected
+func TestReputation_SkipFailedNode(t *testing.T) {
+	elect := NewReputation(4)
+	for i := 1; i <= 30; i++ {
+		view := types.View(i)
+		leader := elect.FindLeaderFor(view)
+		if leader == "4" {
+			// the crashed leader fails to produce a block
+			if i > lag+window {
+				t.Fatalf("the crashed node is elected for view %v", view)
+			}
+			continue
+		}
+		elect.Commit(makeCommitted(view, leader, "1", "2", "3"))
+	}
+}
+
+// replicas with the same committed chain elect the same leaders
+func TestVrf_Deterministic(t *testing.T) {
+	elect1 := NewVrf(4)
+	elect2 := NewVrf(4)
+	leaders := make(map[identity.NodeID]int)
+	for i := 1; i <= 100; i++ {
+		view := types.View(i)
+		leader := elect1.FindLeaderFor(view)
+		require.Equal(t, leader, elect2.FindLeaderFor(view))
+		leaders[leader]++
+		block := makeCommitted(view, leader, "1", "2", "3")
+		block.QC.BlockID = crypto.MakeID(i)
+		elect1.Commit(block)
+		elect2.Commit(block)
+	}
+	require.Len(t, leaders, 4)
+}
+
+// a replica which has not yet committed the blocks a view depends on
+// waits for them instead of electing a leader from its partial history
+func TestVrf_LaggingReplica(t *testing.T) {
+	elect := NewVrf(4)
+	lagging := NewVrf(4)
+	var blocks []*blockchain.Block
+	for i := 1; i <= 50; i++ {
+		view := types.View(i)
+		block := makeCommitted(view, elect.FindLeaderFor(view), "1", "2", "3")
+		block.QC.BlockID = crypto.MakeID(i)
+		elect.Commit(block)
+		blocks = append(blocks, block)
+		if i <= 20 {
+			lagging.Commit(block)
+		}
+	}
+	for view := types.View(1); view <= 20+lag; view++ {
+		require.Equal(t, elect.FindLeaderFor(view), lagging.FindLeaderFor(view))
+	}
+	elected := make(chan identity.NodeID)
+	go func() {
+		elected <- lagging.FindLeaderFor(40)
+	}()
+	select {
+	case <-elected:
+		t.Fatal("the leader is elected from a partial history")
+	case <-time.After(100 * time.Millisecond):
+	}
+	for _, block := range blocks[20:] {
+		lagging.Commit(block)
+	}
+	require.Equal(t, elect.FindLeaderFor(40), <-elected)
+}
@@ -0,0 +1,111 @@
+package election
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/gitferry/bamboo/blockchain"
+	"github.com/gitferry/bamboo/crypto"
+	"github.com/gitferry/bamboo/identity"
+	"github.com/gitferry/bamboo/types"
+)
+
+const (
+	// lag is the number of views between a view and the committed blocks that its leader depends on,
+	// it must be longer than the commit latency since the replicas wait for these blocks to be committed
+	// before they know the leader
+	lag = 10
+	// retained is the number of views of committed blocks that are kept
+	retained = 1000
+)
+
+// record is what an election learns from a committed block
+type record struct {
+	view     types.View
+	proposer identity.NodeID
+	signers  crypto.Bitmap
+	seed     crypto.Identifier
+}
+
+// history keeps the recently committed blocks
+type history struct {
+	records   map[types.View]*record
+	lowest    types.View
+	committed types.View // view of the last committed block
+	mu        sync.RWMutex
+	updated   *sync.Cond // signalled when a block is committed
+}
+
+func newHistory() *history {
+	h := &history{
+		records: make(map[types.View]*record),
+	}
+	h.updated = sync.NewCond(&h.mu)
+	return h
+}
+
+func (h *history) add(block *blockchain.Block) {
+	rec := &record{
+		view:     block.View,
+		proposer: block.Proposer,
+	}
+	if block.QC != nil {
+		rec.signers = block.QC.Signers
+		rec.seed = crypto.MakeID(struct {
+			BlockID crypto.Identifier
+			AggSig  crypto.AggSig
+		}{block.QC.BlockID, block.QC.AggSig})
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.records[block.View] = rec
+	if block.View > h.committed {
+		h.committed = block.View
+	}
+	for block.View > retained && h.lowest < block.View-retained {
+		delete(h.records, h.lowest)
+		h.lowest++
+	}
+	h.updated.Broadcast()
+}
+
+// wait waits until the committed blocks with views up to the given view are known,
+// i.e., a block with a view not lower is committed, so that the blocks are the same at every replica
+// since the blocks committed later have higher views
+func (h *history) wait(view types.View) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for h.committed < view {
+		h.updated.Wait()
+	}
+}
+
+// between returns the committed blocks with views in [from, to) in ascending order of view
+func (h *history) between(from types.View, to types.View) []*record {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	var records []*record
+	for view, rec := range h.records {
+		if view >= from && view < to {
+			records = append(records, rec)
+		}
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].view < records[j].view })
+	return records
+}
+
+// last returns the committed block with the highest view that is not higher than the given view
+func (h *history) last(view types.View) *record {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for v := view; v >= h.lowest; v-- {
+		rec, ok := h.records[v]
+		if ok {
+			return rec
+		}
+		if v == 0 {
+			break
+		}
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package election
+
+import (
+	"sort"
+
+	"github.com/gitferry/bamboo/blockchain"
+	"github.com/gitferry/bamboo/identity"
+	"github.com/gitferry/bamboo/types"
+)
+
+// window is the number of views of committed blocks from which the reputation is computed
+const window = 10
+
+// Reputation elects leaders among the active nodes as in Carousel.
+// A node is active if it proposed or voted for a block committed within a recent window of views,
+// so that nodes which failed to help produce committed blocks are skipped.
+// The proposers of the most recent committed blocks are excluded to rotate the leadership.
+// The leader of a view depends only on the committed chain up to lag views behind it, which replicas agree on,
+// and the election waits until that part of the chain is committed locally. The leadership of the first lag views
+// rotates in round robin.
+type Reputation struct {
+	peerNo  int
+	exclude int
+	history *history
+}
+
+func NewReputation(peerNo int) *Reputation {
+	return &Reputation{
+		peerNo:  peerNo,
+		exclude: (peerNo - 1) / 3,
+		history: newHistory(),
+	}
+}
+
+func (r *Reputation) IsLeader(id identity.NodeID, view types.View) bool {
+	return r.FindLeaderFor(view) == id
+}
+
+func (r *Reputation) FindLeaderFor(view types.View) identity.NodeID {
+	if view <= lag {
+		return roundRobin(view, r.peerNo)
+	}
+	r.history.wait(view - lag)
+	var from types.View
+	if view > lag+window {
+		from = view - lag - window
+	}
+	records := r.history.between(from, view-lag)
+	if len(records) == 0 {
+		return roundRobin(view, r.peerNo)
+	}
+	active := make(map[identity.NodeID]struct{})
+	for _, rec := range records {
+		active[rec.proposer] = struct{}{}
+		for _, signer := range rec.signers.Signers() {
+			active[signer] = struct{}{}
+		}
+	}
+	for i := 0; i < r.exclude && i < len(records); i++ {
+		delete(active, records[len(records)-1-i].proposer)
+	}
+	if len(active) == 0 {
+		return roundRobin(view, r.peerNo)
+	}
+	candidates := make([]identity.NodeID, 0, len(active))
+	for id := range active {
+		candidates = append(candidates, id)
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].Node() < candidates[j].Node() })
+	return candidates[uint64(view)%uint64(len(candidates))]
+}
+
+func (r *Reputation) Commit(block *blockchain.Block) {
+	r.history.add(block)
+}
@@ -0,0 +1,29 @@
+package election
+
+import (
+	"github.com/gitferry/bamboo/identity"
+	"github.com/gitferry/bamboo/types"
+)
+
+// RoundRobin rotates the leadership over the nodes in the order of their ids
+type RoundRobin struct {
+	peerNo int
+}
+
+func NewRoundRobin(peerNo int) *RoundRobin {
+	return &RoundRobin{
+		peerNo: peerNo,
+	}
+}
+
+func (rr *RoundRobin) IsLeader(id identity.NodeID, view types.View) bool {
+	return rr.FindLeaderFor(view) == id
+}
+
+func (rr *RoundRobin) FindLeaderFor(view types.View) identity.NodeID {
+	return roundRobin(view, rr.peerNo)
+}
+
+func roundRobin(view types.View, peerNo int) identity.NodeID {
+	return identity.NewNodeID(int(uint64(view)%uint64(peerNo)) + 1)
+}
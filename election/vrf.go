@@ -0,0 +1,52 @@
+package election
+
+import (
+	"encoding/binary"
+
+	"github.com/gitferry/bamboo/blockchain"
+	"github.com/gitferry/bamboo/crypto"
+	"github.com/gitferry/bamboo/identity"
+	"github.com/gitferry/bamboo/types"
+)
+
+// Vrf elects leaders pseudo-randomly from a seed taken from the QC of the last committed block.
+// The aggregated signature of a QC cannot be predicted before the QC is formed,
+// and every replica can verify the election by recomputing it from the committed chain.
+// The seed of a view is taken from the committed chain up to lag views behind it, which replicas agree on,
+// and the election waits until that part of the chain is committed locally. The seed of the first lag views is empty.
+type Vrf struct {
+	peerNo  int
+	history *history
+}
+
+func NewVrf(peerNo int) *Vrf {
+	return &Vrf{
+		peerNo:  peerNo,
+		history: newHistory(),
+	}
+}
+
+func (v *Vrf) IsLeader(id identity.NodeID, view types.View) bool {
+	return v.FindLeaderFor(view) == id
+}
+
+func (v *Vrf) FindLeaderFor(view types.View) identity.NodeID {
+	var seed crypto.Identifier
+	if view > lag {
+		v.history.wait(view - lag)
+		rec := v.history.last(view - lag)
+		if rec != nil {
+			seed = rec.seed
+		}
+	}
+	h := crypto.MakeID(struct {
+		Seed crypto.Identifier
+		View types.View
+	}{seed, view})
+	id := binary.BigEndian.Uint64(h[:8])%uint64(v.peerNo) + 1
+	return identity.NewNodeID(int(id))
+}
+
+func (v *Vrf) Commit(block *blockchain.Block) {
+	v.history.add(block)
+}
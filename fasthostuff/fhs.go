@@ -2,16 +2,19 @@ package fhs
 
 import (
 	"fmt"
+	"sort"
 	"sync"
 
 	"github.com/gitferry/bamboo/blockchain"
 	"github.com/gitferry/bamboo/config"
 	"github.com/gitferry/bamboo/crypto"
 	"github.com/gitferry/bamboo/election"
+	"github.com/gitferry/bamboo/identity"
 	"github.com/gitferry/bamboo/log"
 	"github.com/gitferry/bamboo/message"
 	"github.com/gitferry/bamboo/node"
 	"github.com/gitferry/bamboo/pacemaker"
+	"github.com/gitferry/bamboo/store"
 	"github.com/gitferry/bamboo/types"
 )
 
@@ -24,10 +27,12 @@ type Fhs struct {
 	lastVotedView   types.View
 	preferredView   types.View
 	bc              *blockchain.BlockChain
+	store           blockchain.Store
 	committedBlocks chan *blockchain.Block
 	forkedBlocks    chan *blockchain.Block
 	bufferedQCs     map[crypto.Identifier]*blockchain.QC
 	bufferedBlocks  map[types.View]*blockchain.Block
+	synchronizer    *blockchain.Synchronizer
 	highQC          *blockchain.QC
 	mu              sync.Mutex
 }
@@ -42,12 +47,19 @@ func NewFhs(
 	f.Node = node
 	f.Election = elec
 	f.pm = pm
-	f.bc = blockchain.NewBlockchain(config.GetConfig().N())
+	f.store = store.NewStore(node.ID())
+	bc, err := blockchain.NewBlockchainWithStore(config.GetConfig().N(), f.store)
+	if err != nil {
+		log.Fatalf("[%v] cannot create the blockchain: %v", node.ID(), err)
+	}
+	f.bc = bc
 	f.bufferedBlocks = make(map[types.View]*blockchain.Block)
 	f.bufferedQCs = make(map[crypto.Identifier]*blockchain.QC)
+	f.synchronizer = blockchain.NewSynchronizer(config.GetTimer())
 	f.highQC = &blockchain.QC{View: 0}
 	f.committedBlocks = committedBlocks
 	f.forkedBlocks = forkedBlocks
+	f.recoverState()
 	return f
 }
 
@@ -64,6 +76,9 @@ func (f *Fhs) ProcessBlock(block *blockchain.Block) error {
 		//	buffer the block
 		f.bufferedBlocks[block.View-1] = block
 		log.Debugf("[%v] the block is buffered, view: %v, current view is: %v, id: %x", f.ID(), block.View, curView, block.ID)
+		if !f.bc.Exists(block.PrevID) {
+			f.requestBlocks(block.PrevID, int(block.View-curView), block.Proposer)
+		}
 		return nil
 	}
 	if block.QC != nil {
@@ -119,6 +134,13 @@ func (f *Fhs) ProcessBlock(block *blockchain.Block) error {
 		log.Debugf("[%v] is not going to vote for block, id: %x", f.ID(), block.ID)
 		return nil
 	}
+	err = f.updateLastVotedView(block.View)
+	if err != nil {
+		log.Warningf("[%v] cannot vote for block, id: %x: %v", f.ID(), block.ID, err)
+		return nil
+	}
+	// the vote must be remembered before it is sent out
+	f.persistState()
 	vote := blockchain.MakeVote(block.View, f.ID(), block.ID)
 	// vote to the next leader
 	voteAggregator := f.FindLeaderFor(block.View + 1)
@@ -160,6 +182,7 @@ func (f *Fhs) ProcessVote(vote *blockchain.Vote) {
 	_, err := f.bc.GetBlockByID(qc.BlockID)
 	if err != nil {
 		f.bufferedQCs[qc.BlockID] = qc
+		f.requestBlocks(qc.BlockID, 1, f.FindLeaderFor(qc.View))
 		return
 	}
 	f.processCertificate(qc)
@@ -177,15 +200,16 @@ func (f *Fhs) ProcessRemoteTmo(tmo *pacemaker.TMO) {
 	}
 	log.Debugf("[%v] a tc is built for view %v", f.ID(), tc.View)
 	f.processTC(tc)
+	// the leader of the next view may have missed some of the timeouts
+	nextLeader := f.FindLeaderFor(tc.View + 1)
+	if nextLeader != f.ID() {
+		f.Send(nextLeader, tc)
+	}
 }
 
 func (f *Fhs) ProcessLocalTmo(view types.View) {
 	f.pm.AdvanceView(view + 1)
-	tmo := &pacemaker.TMO{
-		View:   view + 1,
-		NodeID: f.ID(),
-		HighQC: f.GetHighQC(),
-	}
+	tmo := pacemaker.MakeTMO(view+1, f.ID(), f.GetHighQC())
 	f.Broadcast(tmo)
 	f.ProcessRemoteTmo(tmo)
 	log.Debugf("[%v] broadcast is done for sending tmo", f.ID())
@@ -198,10 +222,20 @@ func (f *Fhs) MakeProposal(view types.View, payload []*message.Transaction) *blo
 }
 
 func (f *Fhs) forkChoice() *blockchain.QC {
-	choice := f.GetHighQC()
+	// a copy, the high qc is shared with the timeouts signed over its view
+	choice := *f.GetHighQC()
 	// to simulate TC under forking attack
 	choice.View = f.pm.GetCurView() - 1
-	return choice
+	return &choice
+}
+
+// ProcessTC processes a tc received from another replica, the tc has been validated
+func (f *Fhs) ProcessTC(tc *pacemaker.TC) {
+	log.Debugf("[%v] is processing a tc for view %v", f.ID(), tc.View)
+	if tc.HighQC != nil {
+		f.processCertificate(tc.HighQC)
+	}
+	f.processTC(tc)
 }
 
 func (f *Fhs) processTC(tc *pacemaker.TC) {
@@ -217,6 +251,69 @@ func (f *Fhs) GetChainStatus() string {
 	return fmt.Sprintf("[%v] The current view is: %v, chain growth rate is: %v, ave block interval is: %v", f.ID(), f.pm.GetCurView(), chainGrowthRate, blockIntervals)
 }
 
+// ProcessSyncRequest replies the blocks asked by a lagging replica
+func (f *Fhs) ProcessSyncRequest(req *blockchain.SyncRequest) {
+	blocks := f.bc.GetBlocks(req)
+	if len(blocks) == 0 {
+		log.Debugf("[%v] has no block requested by %v", f.ID(), req.Requester)
+		return
+	}
+	log.Debugf("[%v] is sending %v blocks to %v", f.ID(), len(blocks), req.Requester)
+	f.Send(req.Requester, &blockchain.SyncResponse{Responder: f.ID(), Blocks: blocks})
+}
+
+// ProcessSyncResponse adds the fetched blocks to the chain
+// and then unblocks the buffered qcs and blocks waiting for them
+func (f *Fhs) ProcessSyncResponse(resp *blockchain.SyncResponse) {
+	for _, block := range resp.Blocks {
+		f.synchronizer.Done(block.ID)
+		if f.bc.Exists(block.ID) {
+			continue
+		}
+		err := f.bc.AddSyncedBlock(block)
+		if err != nil {
+			log.Warningf("[%v] received an invalid block from %v: %v", f.ID(), resp.Responder, err)
+			return
+		}
+		log.Debugf("[%v] synced a block from %v, view: %v, id: %x", f.ID(), resp.Responder, block.View, block.ID)
+		if block.QC != nil {
+			f.processCertificate(block.QC)
+		}
+		qc, ok := f.bufferedQCs[block.ID]
+		if ok {
+			delete(f.bufferedQCs, block.ID)
+			f.processCertificate(qc)
+		}
+	}
+	f.processBufferedBlocks()
+}
+
+// processBufferedBlocks processes the buffered blocks that are no longer ahead of the current view
+func (f *Fhs) processBufferedBlocks() {
+	views := make([]types.View, 0, len(f.bufferedBlocks))
+	for view := range f.bufferedBlocks {
+		views = append(views, view)
+	}
+	sort.Slice(views, func(i, j int) bool { return views[i] < views[j] })
+	for _, view := range views {
+		b, ok := f.bufferedBlocks[view]
+		if !ok || b.View > f.pm.GetCurView()+1 {
+			continue
+		}
+		delete(f.bufferedBlocks, view)
+		_ = f.ProcessBlock(b)
+	}
+}
+
+// requestBlocks asks the peer for the block and its ancestors unless they are being fetched
+func (f *Fhs) requestBlocks(id crypto.Identifier, count int, peer identity.NodeID) {
+	if peer == f.ID() || !f.synchronizer.ShouldRequest(id) {
+		return
+	}
+	log.Debugf("[%v] requests %v blocks from %v, id: %x", f.ID(), count, peer, id)
+	f.Send(peer, blockchain.MakeSyncRequest(f.ID(), id, count))
+}
+
 func (f *Fhs) GetHighQC() *blockchain.QC {
 	f.mu.Lock()
 	defer f.mu.Unlock()
@@ -228,6 +325,7 @@ func (f *Fhs) updateHighQC(qc *blockchain.QC) {
 	defer f.mu.Unlock()
 	if qc.View > f.highQC.View {
 		f.highQC = qc
+		f.persistStateLocked()
 	}
 }
 
@@ -236,14 +334,15 @@ func (f *Fhs) processCertificate(qc *blockchain.QC) {
 	if qc.View < f.pm.GetCurView() {
 		return
 	}
-	if qc.Leader != f.ID() {
+	// the qc of view 0 certifies the genesis and carries no signature
+	if qc.Leader != f.ID() && !(qc.View == 0 && qc.BlockID == crypto.Identifier{}) {
 		quorumIsVerified, _ := crypto.VerifyQuorumSignature(qc.AggSig, qc.BlockID, qc.Signers)
 		if quorumIsVerified == false {
 			log.Warningf("[%v] received a quorum with invalid signatures", f.ID())
 			return
 		}
 	}
-	if f.IsByz() && config.GetConfig().Strategy == FORK && f.IsLeader(f.ID(), qc.View+1) {
+	if f.IsByz() && config.GetConfig().HasStrategy(f.ID(), FORK) && f.IsLeader(f.ID(), qc.View+1) {
 		f.pm.AdvanceView(qc.View)
 		return
 	}
@@ -251,6 +350,7 @@ func (f *Fhs) processCertificate(qc *blockchain.QC) {
 	if err != nil {
 		f.bufferedQCs[qc.BlockID] = qc
 		log.Debugf("[%v] a qc is buffered, view: %v, id: %x", f.ID(), qc.View, qc.BlockID)
+		f.requestBlocks(qc.BlockID, 3, f.FindLeaderFor(qc.View))
 		return
 	}
 	f.updateHighQC(qc)
@@ -305,6 +405,45 @@ func (f *Fhs) updatePreferredView(qc *blockchain.QC) error {
 	if qc.View > f.preferredView {
 		log.Debugf("[%v] preferred view has been updated to %v", f.ID(), qc.View)
 		f.preferredView = qc.View
+		f.persistState()
 	}
 	return nil
 }
+
+// recoverState restores the safety state saved before a restart
+func (f *Fhs) recoverState() {
+	state, err := f.store.LoadState()
+	if err != nil {
+		log.Fatalf("[%v] cannot load the safety state: %v", f.ID(), err)
+	}
+	if state == nil {
+		return
+	}
+	f.lastVotedView = state.LastVotedView
+	f.preferredView = state.PreferredView
+	if state.HighQC != nil {
+		f.highQC = state.HighQC
+	}
+	log.Infof("[%v] recovered the safety state, last voted view: %v, preferred view: %v, high qc view: %v", f.ID(), f.lastVotedView, f.preferredView, f.highQC.View)
+	if f.highQC.View > 0 {
+		f.pm.AdvanceView(f.highQC.View)
+	}
+}
+
+func (f *Fhs) persistState() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.persistStateLocked()
+}
+
+// persistStateLocked saves the safety state, the caller must hold the lock
+func (f *Fhs) persistStateLocked() {
+	err := f.store.SaveState(&blockchain.SafetyState{
+		LastVotedView: f.lastVotedView,
+		PreferredView: f.preferredView,
+		HighQC:        f.highQC,
+	})
+	if err != nil {
+		log.Errorf("[%v] cannot persist the safety state: %v", f.ID(), err)
+	}
+}
@@ -2,16 +2,19 @@ package hotstuff
 
 import (
 	"fmt"
+	"sort"
 	"sync"
 
 	"github.com/gitferry/bamboo/blockchain"
 	"github.com/gitferry/bamboo/config"
 	"github.com/gitferry/bamboo/crypto"
 	"github.com/gitferry/bamboo/election"
+	"github.com/gitferry/bamboo/identity"
 	"github.com/gitferry/bamboo/log"
 	"github.com/gitferry/bamboo/message"
 	"github.com/gitferry/bamboo/node"
 	"github.com/gitferry/bamboo/pacemaker"
+	"github.com/gitferry/bamboo/store"
 	"github.com/gitferry/bamboo/types"
 )
 
@@ -25,10 +28,13 @@ type HotStuff struct {
 	preferredView   types.View
 	highQC          *blockchain.QC
 	bc              *blockchain.BlockChain
+	store           blockchain.Store
 	committedBlocks chan *blockchain.Block
 	forkedBlocks    chan *blockchain.Block
 	bufferedQCs     map[crypto.Identifier]*blockchain.QC
 	bufferedBlocks  map[types.View]*blockchain.Block
+	synchronizer    *blockchain.Synchronizer
+	voteListener    func(block *blockchain.Block)
 	mu              sync.Mutex
 }
 
@@ -42,12 +48,19 @@ func NewHotStuff(
 	hs.Node = node
 	hs.Election = elec
 	hs.pm = pm
-	hs.bc = blockchain.NewBlockchain(config.GetConfig().N())
+	hs.store = store.NewStore(node.ID())
+	bc, err := blockchain.NewBlockchainWithStore(config.GetConfig().N(), hs.store)
+	if err != nil {
+		log.Fatalf("[%v] cannot create the blockchain: %v", node.ID(), err)
+	}
+	hs.bc = bc
 	hs.bufferedBlocks = make(map[types.View]*blockchain.Block)
 	hs.bufferedQCs = make(map[crypto.Identifier]*blockchain.QC)
+	hs.synchronizer = blockchain.NewSynchronizer(config.GetTimer())
 	hs.highQC = &blockchain.QC{View: 0}
 	hs.committedBlocks = committedBlocks
 	hs.forkedBlocks = forkedBlocks
+	hs.recoverState()
 	return hs
 }
 
@@ -64,6 +77,9 @@ func (hs *HotStuff) ProcessBlock(block *blockchain.Block) error {
 		//	buffer the block
 		hs.bufferedBlocks[block.View-1] = block
 		log.Debugf("[%v] the block is buffered, id: %x", hs.ID(), block.ID)
+		if !hs.bc.Exists(block.PrevID) {
+			hs.requestBlocks(block.PrevID, int(block.View-curView), block.Proposer)
+		}
 		return nil
 	}
 	if block.QC != nil {
@@ -100,6 +116,16 @@ func (hs *HotStuff) ProcessBlock(block *blockchain.Block) error {
 		log.Debugf("[%v] is not going to vote for block, id: %x", hs.ID(), block.ID)
 		return nil
 	}
+	err = hs.updateLastVotedView(block.View)
+	if err != nil {
+		log.Warningf("[%v] cannot vote for block, id: %x: %v", hs.ID(), block.ID, err)
+		return nil
+	}
+	// the vote must be remembered before it is sent out
+	hs.persistState()
+	if hs.voteListener != nil {
+		hs.voteListener(block)
+	}
 	vote := blockchain.MakeVote(block.View, hs.ID(), block.ID)
 	// vote is sent to the next leader
 	voteAggregator := hs.FindLeaderFor(block.View + 1)
@@ -141,6 +167,7 @@ func (hs *HotStuff) ProcessVote(vote *blockchain.Vote) {
 	_, err := hs.bc.GetBlockByID(qc.BlockID)
 	if err != nil {
 		hs.bufferedQCs[qc.BlockID] = qc
+		hs.requestBlocks(qc.BlockID, 1, hs.FindLeaderFor(qc.View))
 		return
 	}
 	hs.processCertificate(qc)
@@ -155,15 +182,16 @@ func (hs *HotStuff) ProcessRemoteTmo(tmo *pacemaker.TMO) {
 	}
 	log.Debugf("[%v] a tc is built for view %v", hs.ID(), tc.View)
 	hs.processTC(tc)
+	// the leader of the next view may have missed some of the timeouts
+	nextLeader := hs.FindLeaderFor(tc.View + 1)
+	if nextLeader != hs.ID() {
+		hs.Send(nextLeader, tc)
+	}
 }
 
 func (hs *HotStuff) ProcessLocalTmo(view types.View) {
 	hs.pm.AdvanceView(view)
-	tmo := &pacemaker.TMO{
-		View:   view + 1,
-		NodeID: hs.ID(),
-		HighQC: hs.GetHighQC(),
-	}
+	tmo := pacemaker.MakeTMO(view+1, hs.ID(), hs.GetHighQC())
 	hs.Broadcast(tmo)
 	hs.ProcessRemoteTmo(tmo)
 }
@@ -176,7 +204,7 @@ func (hs *HotStuff) MakeProposal(view types.View, payload []*message.Transaction
 
 func (hs *HotStuff) forkChoice() *blockchain.QC {
 	var choice *blockchain.QC
-	if !hs.IsByz() || config.GetConfig().Strategy != FORK {
+	if !hs.IsByz() || !config.GetConfig().HasStrategy(hs.ID(), FORK) {
 		return hs.GetHighQC()
 	}
 	//	create a fork by returning highQC's parent's QC
@@ -184,15 +212,26 @@ func (hs *HotStuff) forkChoice() *blockchain.QC {
 	parBlock, err := hs.bc.GetBlockByID(parBlockID)
 	if err != nil {
 		log.Warningf("cannot get parent block of block id: %x: %w", parBlockID, err)
+		return hs.GetHighQC()
 	}
 	if parBlock.QC.View < hs.preferredView {
 		choice = hs.GetHighQC()
 	} else {
 		choice = parBlock.QC
 	}
-	// to simulate TC's view
-	choice.View = hs.pm.GetCurView() - 1
-	return choice
+	// to simulate TC's view, the stored qc is left intact
+	forked := *choice
+	forked.View = hs.pm.GetCurView() - 1
+	return &forked
+}
+
+// ProcessTC processes a tc received from another replica, the tc has been validated
+func (hs *HotStuff) ProcessTC(tc *pacemaker.TC) {
+	log.Debugf("[%v] is processing a tc for view %v", hs.ID(), tc.View)
+	if tc.HighQC != nil {
+		hs.processCertificate(tc.HighQC)
+	}
+	hs.processTC(tc)
 }
 
 func (hs *HotStuff) processTC(tc *pacemaker.TC) {
@@ -202,12 +241,86 @@ func (hs *HotStuff) processTC(tc *pacemaker.TC) {
 	hs.pm.AdvanceView(tc.View)
 }
 
+// ProcessSyncRequest replies the blocks asked by a lagging replica
+func (hs *HotStuff) ProcessSyncRequest(req *blockchain.SyncRequest) {
+	blocks := hs.bc.GetBlocks(req)
+	if len(blocks) == 0 {
+		log.Debugf("[%v] has no block requested by %v", hs.ID(), req.Requester)
+		return
+	}
+	log.Debugf("[%v] is sending %v blocks to %v", hs.ID(), len(blocks), req.Requester)
+	hs.Send(req.Requester, &blockchain.SyncResponse{Responder: hs.ID(), Blocks: blocks})
+}
+
+// ProcessSyncResponse adds the fetched blocks to the chain
+// and then unblocks the buffered qcs and blocks waiting for them
+func (hs *HotStuff) ProcessSyncResponse(resp *blockchain.SyncResponse) {
+	for _, block := range resp.Blocks {
+		hs.synchronizer.Done(block.ID)
+		if hs.bc.Exists(block.ID) {
+			continue
+		}
+		err := hs.bc.AddSyncedBlock(block)
+		if err != nil {
+			log.Warningf("[%v] received an invalid block from %v: %v", hs.ID(), resp.Responder, err)
+			return
+		}
+		log.Debugf("[%v] synced a block from %v, view: %v, id: %x", hs.ID(), resp.Responder, block.View, block.ID)
+		if block.QC != nil {
+			hs.processCertificate(block.QC)
+		}
+		qc, ok := hs.bufferedQCs[block.ID]
+		if ok {
+			delete(hs.bufferedQCs, block.ID)
+			hs.processCertificate(qc)
+		}
+	}
+	hs.processBufferedBlocks()
+}
+
+// processBufferedBlocks processes the buffered blocks that are no longer ahead of the current view
+func (hs *HotStuff) processBufferedBlocks() {
+	views := make([]types.View, 0, len(hs.bufferedBlocks))
+	for view := range hs.bufferedBlocks {
+		views = append(views, view)
+	}
+	sort.Slice(views, func(i, j int) bool { return views[i] < views[j] })
+	for _, view := range views {
+		b, ok := hs.bufferedBlocks[view]
+		if !ok || b.View > hs.pm.GetCurView()+1 {
+			continue
+		}
+		delete(hs.bufferedBlocks, view)
+		_ = hs.ProcessBlock(b)
+	}
+}
+
+// requestBlocks asks the peer for the block and its ancestors unless they are being fetched
+func (hs *HotStuff) requestBlocks(id crypto.Identifier, count int, peer identity.NodeID) {
+	if peer == hs.ID() || !hs.synchronizer.ShouldRequest(id) {
+		return
+	}
+	log.Debugf("[%v] requests %v blocks from %v, id: %x", hs.ID(), count, peer, id)
+	hs.Send(peer, blockchain.MakeSyncRequest(hs.ID(), id, count))
+}
+
 func (hs *HotStuff) GetHighQC() *blockchain.QC {
 	hs.mu.Lock()
 	defer hs.mu.Unlock()
 	return hs.highQC
 }
 
+// GetBlockChain returns the chain of blocks maintained by the replica
+func (hs *HotStuff) GetBlockChain() *blockchain.BlockChain {
+	return hs.bc
+}
+
+// SetVoteListener registers a function called with every block the replica votes for,
+// the listener runs in the event loop before the vote is sent
+func (hs *HotStuff) SetVoteListener(listener func(block *blockchain.Block)) {
+	hs.voteListener = listener
+}
+
 func (hs *HotStuff) GetChainStatus() string {
 	chainGrowthRate := hs.bc.GetChainGrowth()
 	blockIntervals := hs.bc.GetBlockIntervals()
@@ -219,6 +332,7 @@ func (hs *HotStuff) updateHighQC(qc *blockchain.QC) {
 	defer hs.mu.Unlock()
 	if qc.View > hs.highQC.View {
 		hs.highQC = qc
+		hs.persistStateLocked()
 	}
 }
 
@@ -234,7 +348,7 @@ func (hs *HotStuff) processCertificate(qc *blockchain.QC) {
 			return
 		}
 	}
-	if hs.IsByz() && config.GetConfig().Strategy == FORK && hs.IsLeader(hs.ID(), qc.View+1) {
+	if hs.IsByz() && config.GetConfig().HasStrategy(hs.ID(), FORK) && hs.IsLeader(hs.ID(), qc.View+1) {
 		hs.pm.AdvanceView(qc.View)
 		return
 	}
@@ -242,6 +356,7 @@ func (hs *HotStuff) processCertificate(qc *blockchain.QC) {
 	if err != nil {
 		hs.bufferedQCs[qc.BlockID] = qc
 		log.Debugf("[%v] a qc is buffered, view: %v, id: %x", hs.ID(), qc.View, qc.BlockID)
+		hs.requestBlocks(qc.BlockID, 3, hs.FindLeaderFor(qc.View))
 		return
 	}
 	hs.pm.AdvanceView(qc.View)
@@ -318,6 +433,45 @@ func (hs *HotStuff) updatePreferredView(qc *blockchain.QC) error {
 	}
 	if grandParentBlock.View > hs.preferredView {
 		hs.preferredView = grandParentBlock.View
+		hs.persistState()
 	}
 	return nil
 }
+
+// recoverState restores the safety state saved before a restart
+func (hs *HotStuff) recoverState() {
+	state, err := hs.store.LoadState()
+	if err != nil {
+		log.Fatalf("[%v] cannot load the safety state: %v", hs.ID(), err)
+	}
+	if state == nil {
+		return
+	}
+	hs.lastVotedView = state.LastVotedView
+	hs.preferredView = state.PreferredView
+	if state.HighQC != nil {
+		hs.highQC = state.HighQC
+	}
+	log.Infof("[%v] recovered the safety state, last voted view: %v, preferred view: %v, high qc view: %v", hs.ID(), hs.lastVotedView, hs.preferredView, hs.highQC.View)
+	if hs.highQC.View > 0 {
+		hs.pm.AdvanceView(hs.highQC.View)
+	}
+}
+
+func (hs *HotStuff) persistState() {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	hs.persistStateLocked()
+}
+
+// persistStateLocked saves the safety state, the caller must hold the lock
+func (hs *HotStuff) persistStateLocked() {
+	err := hs.store.SaveState(&blockchain.SafetyState{
+		LastVotedView: hs.lastVotedView,
+		PreferredView: hs.preferredView,
+		HighQC:        hs.highQC,
+	})
+	if err != nil {
+		log.Errorf("[%v] cannot persist the safety state: %v", hs.ID(), err)
+	}
+}
@@ -0,0 +1,448 @@
+package jolteon
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/gitferry/bamboo/blockchain"
+	"github.com/gitferry/bamboo/config"
+	"github.com/gitferry/bamboo/crypto"
+	"github.com/gitferry/bamboo/election"
+	"github.com/gitferry/bamboo/identity"
+	"github.com/gitferry/bamboo/log"
+	"github.com/gitferry/bamboo/message"
+	"github.com/gitferry/bamboo/node"
+	"github.com/gitferry/bamboo/pacemaker"
+	"github.com/gitferry/bamboo/store"
+	"github.com/gitferry/bamboo/types"
+)
+
+// Jolteon (DiemBFT-v4) commits with two chains in the normal case and changes views quadratically.
+// Votes are sent to the next leader. On a timeout a replica stops voting in the view
+// and broadcasts a timeout carrying its high QC, every replica builds the TC out of 2f+1 timeouts.
+// A block of view v is justified either by a QC of view v-1
+// or by a TC of view v-1 together with a QC not lower than the highest QC in the TC.
+type Jolteon struct {
+	node.Node
+	election.Election
+	pm              *pacemaker.Pacemaker
+	lastVotedView   types.View
+	committedView   types.View
+	highQC          *blockchain.QC
+	bc              *blockchain.BlockChain
+	store           blockchain.Store
+	committedBlocks chan *blockchain.Block
+	forkedBlocks    chan *blockchain.Block
+	tcs             map[types.View]*pacemaker.TC
+	bufferedQCs     map[crypto.Identifier]*blockchain.QC
+	bufferedBlocks  map[types.View]*blockchain.Block
+	synchronizer    *blockchain.Synchronizer
+	mu              sync.Mutex
+}
+
+func NewJolteon(
+	node node.Node,
+	pm *pacemaker.Pacemaker,
+	elec election.Election,
+	committedBlocks chan *blockchain.Block,
+	forkedBlocks chan *blockchain.Block) *Jolteon {
+	jt := new(Jolteon)
+	jt.Node = node
+	jt.Election = elec
+	jt.pm = pm
+	jt.store = store.NewStore(node.ID())
+	bc, err := blockchain.NewBlockchainWithStore(config.GetConfig().N(), jt.store)
+	if err != nil {
+		log.Fatalf("[%v] cannot create the blockchain: %v", node.ID(), err)
+	}
+	jt.bc = bc
+	jt.tcs = make(map[types.View]*pacemaker.TC)
+	jt.bufferedBlocks = make(map[types.View]*blockchain.Block)
+	jt.bufferedQCs = make(map[crypto.Identifier]*blockchain.QC)
+	jt.synchronizer = blockchain.NewSynchronizer(config.GetTimer())
+	jt.highQC = &blockchain.QC{View: 0}
+	jt.committedBlocks = committedBlocks
+	jt.forkedBlocks = forkedBlocks
+	jt.recoverState()
+	return jt
+}
+
+func (jt *Jolteon) ProcessBlock(block *blockchain.Block) error {
+	log.Debugf("[%v] is processing block from %v, view: %v, id: %x", jt.ID(), block.Proposer.Node(), block.View, block.ID)
+	if block.QC == nil {
+		return fmt.Errorf("the block should contain a QC")
+	}
+	if block.Proposer != jt.ID() {
+		blockIsVerified, _ := crypto.PubVerify(block.Sig, crypto.IDToByte(block.ID), block.Proposer)
+		if !blockIsVerified {
+			log.Warningf("[%v] received a block with an invalid signature", jt.ID())
+			return nil
+		}
+	}
+	// the qc is verified whatever its view, the block is justified by it
+	err := block.QC.VerifyFor(block)
+	if err != nil {
+		return fmt.Errorf("received a block with an invalid qc, view: %v, id: %x: %w", block.View, block.ID, err)
+	}
+	if block.Proposer != jt.ID() {
+		jt.processCertificate(block.QC)
+	}
+	curView := jt.pm.GetCurView()
+	if block.View < curView {
+		log.Warningf("[%v] received a stale proposal from %v, block view: %v, current view: %v", jt.ID(), block.Proposer, block.View, curView)
+		return nil
+	}
+	if !jt.Election.IsLeader(block.Proposer, block.View) {
+		return fmt.Errorf("received a proposal (%v) from an invalid leader (%v)", block.View, block.Proposer)
+	}
+	justified, err := jt.isJustified(block)
+	if err != nil {
+		return err
+	}
+	// the tc may still be on its way
+	if !justified || block.View > jt.pm.GetCurView() {
+		jt.bufferedBlocks[block.View] = block
+		log.Debugf("[%v] the block is buffered, view: %v, current view: %v, id: %x", jt.ID(), block.View, curView, block.ID)
+		return nil
+	}
+	if block.View > 1 && !jt.bc.Exists(block.PrevID) {
+		jt.bufferedBlocks[block.View] = block
+		log.Debugf("[%v] the parent is missing, the block is buffered, view: %v, id: %x", jt.ID(), block.View, block.ID)
+		jt.requestBlocks(block.PrevID, int(block.View-block.QC.View), block.Proposer)
+		return nil
+	}
+	if block.QC.View > 0 {
+		parent, err := jt.bc.GetBlockByID(block.PrevID)
+		if err == nil {
+			err = block.QC.Certifies(parent)
+		}
+		if err != nil {
+			return fmt.Errorf("received a block with an invalid qc, view: %v, id: %x: %w", block.View, block.ID, err)
+		}
+	}
+	jt.bc.AddBlock(block)
+	qc, ok := jt.bufferedQCs[block.ID]
+	if ok {
+		delete(jt.bufferedQCs, block.ID)
+		jt.processCertificate(qc)
+	}
+	if block.View <= jt.lastVotedView {
+		log.Debugf("[%v] is not going to vote for block, view: %v, last voted view: %v", jt.ID(), block.View, jt.lastVotedView)
+		return nil
+	}
+	jt.lastVotedView = block.View
+	// the vote must be remembered before it is sent out
+	jt.persistState()
+	vote := blockchain.MakeVote(block.View, jt.ID(), block.ID)
+	voteAggregator := jt.FindLeaderFor(block.View + 1)
+	if voteAggregator == jt.ID() {
+		log.Debugf("[%v] vote is sent to itself, id: %x", jt.ID(), vote.BlockID)
+		jt.ProcessVote(vote)
+	} else {
+		log.Debugf("[%v] vote is sent to %v, id: %x", jt.ID(), voteAggregator, vote.BlockID)
+		jt.Send(voteAggregator, vote)
+	}
+	return nil
+}
+
+func (jt *Jolteon) ProcessVote(vote *blockchain.Vote) {
+	log.Debugf("[%v] is processing the vote from %v, block id: %x", jt.ID(), vote.Voter, vote.BlockID)
+	if vote.Voter != jt.ID() {
+		voteIsVerified, err := crypto.PubVerify(vote.Signature, crypto.IDToByte(vote.BlockID), vote.Voter)
+		if err != nil {
+			log.Warningf("[%v] Error in verifying the signature in vote id: %x", jt.ID(), vote.BlockID)
+			return
+		}
+		if !voteIsVerified {
+			log.Warningf("[%v] received a vote with invalid signature. vote id: %x", jt.ID(), vote.BlockID)
+			return
+		}
+	}
+	isBuilt, qc := jt.bc.AddVote(vote)
+	if !isBuilt {
+		log.Debugf("[%v] not sufficient votes to build a QC, block id: %x", jt.ID(), vote.BlockID)
+		return
+	}
+	qc.Leader = jt.ID()
+	jt.processCertificate(qc)
+}
+
+// ProcessRemoteTmo collects the timeouts of a view into a TC
+func (jt *Jolteon) ProcessRemoteTmo(tmo *pacemaker.TMO) {
+	log.Debugf("[%v] is processing tmo from %v, view: %v", jt.ID(), tmo.NodeID, tmo.View)
+	if tmo.HighQC != nil {
+		jt.processCertificate(tmo.HighQC)
+	}
+	isBuilt, tc := jt.pm.ProcessRemoteTmo(tmo)
+	if !isBuilt {
+		return
+	}
+	log.Debugf("[%v] a tc is built for view %v", jt.ID(), tc.View)
+	jt.processTC(tc)
+}
+
+// ProcessLocalTmo stops voting in the view and broadcasts a timeout with the high QC
+func (jt *Jolteon) ProcessLocalTmo(view types.View) {
+	if view > jt.lastVotedView {
+		jt.lastVotedView = view
+		jt.persistState()
+	}
+	tmo := pacemaker.MakeTMO(view, jt.ID(), jt.GetHighQC())
+	jt.Broadcast(tmo)
+	jt.ProcessRemoteTmo(tmo)
+}
+
+// MakeProposal extends the high QC. After a timeout the TC of the previous view
+// is sent ahead of the block to justify it.
+func (jt *Jolteon) MakeProposal(view types.View, payload []*message.Transaction) *blockchain.Block {
+	qc := jt.GetHighQC()
+	if qc.View+1 != view {
+		tc, ok := jt.tcs[view-1]
+		if ok {
+			jt.Broadcast(tc)
+		}
+	}
+	block := blockchain.MakeBlock(view, qc, qc.BlockID, payload, jt.ID())
+	return block
+}
+
+// ProcessTC processes a tc received from another replica, the tc has been validated
+func (jt *Jolteon) ProcessTC(tc *pacemaker.TC) {
+	log.Debugf("[%v] is processing a tc for view %v", jt.ID(), tc.View)
+	jt.processTC(tc)
+}
+
+func (jt *Jolteon) processTC(tc *pacemaker.TC) {
+	if _, ok := jt.tcs[tc.View]; !ok {
+		jt.tcs[tc.View] = tc
+	}
+	if tc.HighQC != nil {
+		jt.processCertificate(tc.HighQC)
+	}
+	if tc.View >= jt.pm.GetCurView() {
+		jt.pm.AdvanceView(tc.View)
+	}
+	jt.processBufferedBlocks()
+}
+
+// isJustified checks that the QC of the block certifies the block of the previous view,
+// or that the previous view timed out and the QC is not lower than the highest QC in the TC.
+// It returns false if the TC has not been received yet.
+func (jt *Jolteon) isJustified(block *blockchain.Block) (bool, error) {
+	if block.QC.BlockID != block.PrevID {
+		return false, fmt.Errorf("the qc of the block does not certify its parent, view: %v", block.View)
+	}
+	if block.QC.View >= block.View {
+		return false, fmt.Errorf("the qc view %v is not lower than the block view %v", block.QC.View, block.View)
+	}
+	if block.QC.View+1 == block.View {
+		return true, nil
+	}
+	tc, ok := jt.tcs[block.View-1]
+	if !ok {
+		return false, nil
+	}
+	if tc.HighQC != nil && block.QC.View < tc.HighQC.View {
+		return false, fmt.Errorf("the block does not extend the highest qc of the tc, qc view: %v, tc high qc view: %v", block.QC.View, tc.HighQC.View)
+	}
+	return true, nil
+}
+
+func (jt *Jolteon) processCertificate(qc *blockchain.QC) {
+	if qc.View == 0 || qc.View <= jt.GetHighQC().View {
+		return
+	}
+	log.Debugf("[%v] is processing a QC, view: %v, block id: %x", jt.ID(), qc.View, qc.BlockID)
+	if qc.Leader != jt.ID() {
+		quorumIsVerified, _ := crypto.VerifyQuorumSignature(qc.AggSig, qc.BlockID, qc.Signers)
+		if !quorumIsVerified {
+			log.Warningf("[%v] received a quorum with invalid signatures", jt.ID())
+			return
+		}
+	}
+	if !jt.bc.Exists(qc.BlockID) {
+		jt.bufferedQCs[qc.BlockID] = qc
+		log.Debugf("[%v] a qc is buffered, view: %v, id: %x", jt.ID(), qc.View, qc.BlockID)
+		jt.requestBlocks(qc.BlockID, 2, jt.FindLeaderFor(qc.View))
+		return
+	}
+	certified, err := jt.bc.GetBlockByID(qc.BlockID)
+	if err == nil {
+		err = qc.Certifies(certified)
+	}
+	if err != nil {
+		log.Warningf("[%v] received an invalid qc: %v", jt.ID(), err)
+		return
+	}
+	jt.updateHighQC(qc)
+	jt.pm.AdvanceView(qc.View)
+	ok, block, err := jt.commitRule(qc)
+	if err != nil {
+		log.Debugf("[%v] cannot check the commit rule: %v", jt.ID(), err)
+	}
+	if ok {
+		jt.commit(block)
+	}
+	jt.processBufferedBlocks()
+}
+
+// commitRule commits the parent of the certified block if they are of consecutive views,
+// the views are those of the blocks since the view of the qc is not signed
+func (jt *Jolteon) commitRule(qc *blockchain.QC) (bool, *blockchain.Block, error) {
+	block, err := jt.bc.GetBlockByID(qc.BlockID)
+	if err != nil {
+		return false, nil, fmt.Errorf("cannot commit any block: %w", err)
+	}
+	parentBlock, err := jt.bc.GetParentBlock(block.ID)
+	if err != nil {
+		return false, nil, fmt.Errorf("cannot commit any block: %w", err)
+	}
+	if parentBlock.View+1 != block.View || parentBlock.View <= jt.committedView {
+		return false, nil, nil
+	}
+	return true, parentBlock, nil
+}
+
+func (jt *Jolteon) commit(block *blockchain.Block) {
+	committedBlocks, forkedBlocks, err := jt.bc.CommitBlock(block.ID, jt.pm.GetCurView())
+	if err != nil {
+		log.Errorf("[%v] cannot commit blocks: %v", jt.ID(), err)
+		return
+	}
+	jt.committedView = block.View
+	for view := range jt.tcs {
+		if view < block.View {
+			delete(jt.tcs, view)
+		}
+	}
+	for _, cBlock := range committedBlocks {
+		jt.committedBlocks <- cBlock
+	}
+	for _, fBlock := range forkedBlocks {
+		jt.forkedBlocks <- fBlock
+	}
+}
+
+// processBufferedBlocks processes the buffered blocks that are no longer ahead of the current view
+func (jt *Jolteon) processBufferedBlocks() {
+	views := make([]types.View, 0, len(jt.bufferedBlocks))
+	for view := range jt.bufferedBlocks {
+		views = append(views, view)
+	}
+	sort.Slice(views, func(i, j int) bool { return views[i] < views[j] })
+	for _, view := range views {
+		b, ok := jt.bufferedBlocks[view]
+		if !ok || b.View > jt.pm.GetCurView() {
+			continue
+		}
+		delete(jt.bufferedBlocks, view)
+		_ = jt.ProcessBlock(b)
+	}
+}
+
+// ProcessSyncRequest replies the blocks asked by a lagging replica
+func (jt *Jolteon) ProcessSyncRequest(req *blockchain.SyncRequest) {
+	blocks := jt.bc.GetBlocks(req)
+	if len(blocks) == 0 {
+		log.Debugf("[%v] has no block requested by %v", jt.ID(), req.Requester)
+		return
+	}
+	log.Debugf("[%v] is sending %v blocks to %v", jt.ID(), len(blocks), req.Requester)
+	jt.Send(req.Requester, &blockchain.SyncResponse{Responder: jt.ID(), Blocks: blocks})
+}
+
+// ProcessSyncResponse adds the fetched blocks to the chain
+// and then unblocks the buffered qcs and blocks waiting for them
+func (jt *Jolteon) ProcessSyncResponse(resp *blockchain.SyncResponse) {
+	for _, block := range resp.Blocks {
+		jt.synchronizer.Done(block.ID)
+		if jt.bc.Exists(block.ID) {
+			continue
+		}
+		err := block.QC.VerifyFor(block)
+		if err == nil {
+			err = jt.bc.AddSyncedBlock(block)
+		}
+		if err != nil {
+			log.Warningf("[%v] received an invalid block from %v: %v", jt.ID(), resp.Responder, err)
+			return
+		}
+		log.Debugf("[%v] synced a block from %v, view: %v, id: %x", jt.ID(), resp.Responder, block.View, block.ID)
+		if block.QC != nil {
+			jt.processCertificate(block.QC)
+		}
+		qc, ok := jt.bufferedQCs[block.ID]
+		if ok {
+			delete(jt.bufferedQCs, block.ID)
+			jt.processCertificate(qc)
+		}
+	}
+	jt.processBufferedBlocks()
+}
+
+// requestBlocks asks the peer for the block and its ancestors unless they are being fetched
+func (jt *Jolteon) requestBlocks(id crypto.Identifier, count int, peer identity.NodeID) {
+	if peer == jt.ID() || !jt.synchronizer.ShouldRequest(id) {
+		return
+	}
+	log.Debugf("[%v] requests %v blocks from %v, id: %x", jt.ID(), count, peer, id)
+	jt.Send(peer, blockchain.MakeSyncRequest(jt.ID(), id, count))
+}
+
+func (jt *Jolteon) GetHighQC() *blockchain.QC {
+	jt.mu.Lock()
+	defer jt.mu.Unlock()
+	return jt.highQC
+}
+
+func (jt *Jolteon) GetChainStatus() string {
+	chainGrowthRate := jt.bc.GetChainGrowth()
+	blockIntervals := jt.bc.GetBlockIntervals()
+	return fmt.Sprintf("[%v] The current view is: %v, chain growth rate is: %v, ave block interval is: %v", jt.ID(), jt.pm.GetCurView(), chainGrowthRate, blockIntervals)
+}
+
+func (jt *Jolteon) updateHighQC(qc *blockchain.QC) {
+	jt.mu.Lock()
+	defer jt.mu.Unlock()
+	if qc.View > jt.highQC.View {
+		jt.highQC = qc
+		jt.persistStateLocked()
+	}
+}
+
+// recoverState restores the safety state saved before a restart
+func (jt *Jolteon) recoverState() {
+	state, err := jt.store.LoadState()
+	if err != nil {
+		log.Fatalf("[%v] cannot load the safety state: %v", jt.ID(), err)
+	}
+	if state == nil {
+		return
+	}
+	jt.lastVotedView = state.LastVotedView
+	if state.HighQC != nil {
+		jt.highQC = state.HighQC
+	}
+	log.Infof("[%v] recovered the safety state, last voted view: %v, high qc view: %v", jt.ID(), jt.lastVotedView, jt.highQC.View)
+	if jt.highQC.View > 0 {
+		jt.pm.AdvanceView(jt.highQC.View)
+	}
+}
+
+func (jt *Jolteon) persistState() {
+	jt.mu.Lock()
+	defer jt.mu.Unlock()
+	jt.persistStateLocked()
+}
+
+// persistStateLocked saves the safety state, the caller must hold the lock
+func (jt *Jolteon) persistStateLocked() {
+	err := jt.store.SaveState(&blockchain.SafetyState{
+		LastVotedView: jt.lastVotedView,
+		HighQC:        jt.highQC,
+	})
+	if err != nil {
+		log.Errorf("[%v] cannot persist the safety state: %v", jt.ID(), err)
+	}
+}
@@ -0,0 +1,130 @@
+package jolteon
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/gitferry/bamboo/blockchain"
+	"github.com/gitferry/bamboo/config"
+	"github.com/gitferry/bamboo/crypto"
+	"github.com/gitferry/bamboo/identity"
+	"github.com/gitferry/bamboo/pacemaker"
+	"github.com/gitferry/bamboo/types"
+)
+
+func newTestJolteon() *Jolteon {
+	config.Configuration.Signer = crypto.BLS_BLS12381
+	crypto.SetKeysWith(4, crypto.BLS_BLS12381)
+	return &Jolteon{
+		bc:     blockchain.NewBlockchain(4),
+		pm:     pacemaker.NewPacemaker(4),
+		tcs:    make(map[types.View]*pacemaker.TC),
+		highQC: &blockchain.QC{View: 0},
+	}
+}
+
+// makeBlock creates a block of the view extending the parent, the block is added to the chain
+func (jt *Jolteon) makeBlock(view types.View, parent *blockchain.Block) *blockchain.Block {
+	qc := &blockchain.QC{View: 0}
+	if parent != nil {
+		qc = &blockchain.QC{View: parent.View, BlockID: parent.ID}
+	}
+	block := blockchain.MakeBlock(view, qc, qc.BlockID, nil, identity.NewNodeID(int(view)%4+1))
+	jt.bc.AddBlock(block)
+	return block
+}
+
+// the block is justified by the qc of the previous view
+func TestJolteon_Justify_QC(t *testing.T) {
+	jt := newTestJolteon()
+	b1 := jt.makeBlock(1, nil)
+	b2 := jt.makeBlock(2, b1)
+	ok, err := jt.isJustified(b2)
+	require.NoError(t, err)
+	require.True(t, ok)
+}
+
+// after the leader of view 3 failed, the block of view 4 needs the tc of view 3
+func TestJolteon_Justify_TC(t *testing.T) {
+	jt := newTestJolteon()
+	b1 := jt.makeBlock(1, nil)
+	b2 := jt.makeBlock(2, b1)
+	b4 := jt.makeBlock(4, b2)
+	ok, err := jt.isJustified(b4)
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	jt.tcs[3] = &pacemaker.TC{View: 3, HighQC: b4.QC}
+	ok, err = jt.isJustified(b4)
+	require.NoError(t, err)
+	require.True(t, ok)
+}
+
+// a block extending a qc lower than the highest qc in the tc is rejected
+func TestJolteon_Justify_LowQC(t *testing.T) {
+	jt := newTestJolteon()
+	b1 := jt.makeBlock(1, nil)
+	b2 := jt.makeBlock(2, b1)
+	b4 := jt.makeBlock(4, b1)
+	jt.tcs[3] = &pacemaker.TC{View: 3, HighQC: &blockchain.QC{View: 2, BlockID: b2.ID}}
+	ok, err := jt.isJustified(b4)
+	require.Error(t, err)
+	require.False(t, ok)
+}
+
+// two certified blocks of consecutive views commit the first one
+func TestJolteon_CommitRule(t *testing.T) {
+	jt := newTestJolteon()
+	b1 := jt.makeBlock(1, nil)
+	b2 := jt.makeBlock(2, b1)
+	b3 := jt.makeBlock(3, b2)
+	ok, block, err := jt.commitRule(&blockchain.QC{View: 3, BlockID: b3.ID})
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, b2.ID, block.ID)
+
+	// not consecutive
+	b5 := jt.makeBlock(5, b3)
+	ok, _, err = jt.commitRule(&blockchain.QC{View: 5, BlockID: b5.ID})
+	require.NoError(t, err)
+	require.False(t, ok)
+}
+
+// a block is rejected unless its qc carries the signatures of a quorum
+func TestJolteon_VerifyQC(t *testing.T) {
+	jt := newTestJolteon()
+	b1 := jt.makeBlock(1, nil)
+	require.NoError(t, b1.QC.VerifyFor(b1))
+
+	// a forged qc without signatures
+	b2 := jt.makeBlock(2, b1)
+	require.Error(t, b2.QC.VerifyFor(b2))
+
+	var qc *blockchain.QC
+	for _, id := range []identity.NodeID{"1", "2", "3"} {
+		_, qc = jt.bc.AddVote(blockchain.MakeVote(1, id, b1.ID))
+	}
+	require.NotNil(t, qc)
+	b2 = blockchain.MakeBlock(2, qc, b1.ID, nil, "3")
+	require.NoError(t, b2.QC.VerifyFor(b2))
+
+	// the qc must certify the parent
+	b3 := blockchain.MakeBlock(3, qc, b2.ID, nil, "4")
+	require.Error(t, b3.QC.VerifyFor(b3))
+}
+
+// the view of a qc is only trusted if it is the view of the certified block
+func TestJolteon_QCView(t *testing.T) {
+	jt := newTestJolteon()
+	b1 := jt.makeBlock(1, nil)
+	b3 := jt.makeBlock(3, b1)
+	require.NoError(t, b3.QC.Certifies(b1))
+
+	// the signatures do not cover the view, a forged view pretends that the views are consecutive
+	qc := &blockchain.QC{View: 2, BlockID: b3.ID}
+	require.Error(t, qc.Certifies(b3))
+	ok, _, err := jt.commitRule(qc)
+	require.NoError(t, err)
+	require.False(t, ok)
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"flag"
+
+	"github.com/gitferry/bamboo"
+	"github.com/gitferry/bamboo/config"
+	"github.com/gitferry/bamboo/log"
+	"github.com/gitferry/bamboo/transport"
+)
+
+var dir = flag.String("dir", "keys", "directory of the generated keys")
+
+// keygen generates the tls keys of the nodes of the config, the directory is then given as key_dir
+func main() {
+	bamboo.Init()
+	ids := config.GetConfig().IDs()
+	if err := transport.GenerateKeys(*dir, ids); err != nil {
+		log.Fatalf("cannot generate the keys: %v", err)
+	}
+	log.Infof("generated the keys of nodes %v in %v", ids, *dir)
+}
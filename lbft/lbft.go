@@ -2,30 +2,42 @@ package lbft
 
 import (
 	"fmt"
+	"sort"
+
 	"github.com/gitferry/bamboo/blockchain"
 	"github.com/gitferry/bamboo/config"
 	"github.com/gitferry/bamboo/crypto"
 	"github.com/gitferry/bamboo/election"
+	"github.com/gitferry/bamboo/identity"
 	"github.com/gitferry/bamboo/log"
 	"github.com/gitferry/bamboo/message"
 	"github.com/gitferry/bamboo/node"
 	"github.com/gitferry/bamboo/pacemaker"
+	"github.com/gitferry/bamboo/store"
 	"github.com/gitferry/bamboo/types"
 )
 
+// Lbft is a chained BFT protocol with rotating leaders.
+// Votes are broadcast, so every replica builds the QC of the current view and enters
+// the next view as soon as the leader's block is certified, without waiting for the next proposal.
+// A replica locks on the highest QC it has seen and votes for a block of the current view
+// only if the block carries a QC not lower than the lock.
+// A block is committed once its child proposed by the next leader in the rotation,
+// i.e., in the next view, is certified.
 type Lbft struct {
 	node.Node
 	election.Election
-	pm                     *pacemaker.Pacemaker
-	bc                     *blockchain.BlockChain
-	notarizedChain         [][]*blockchain.Block
-	bufferedBlocks         map[crypto.Identifier]*blockchain.Block
-	bufferedQCs            map[crypto.Identifier]*blockchain.QC
-	bufferedNotarizedBlock map[crypto.Identifier]*blockchain.QC
-	committedBlocks        chan *blockchain.Block
-	forkedBlocks           chan *blockchain.Block
-	echoedBlock            map[crypto.Identifier]struct{}
-	echoedVote             map[crypto.Identifier]struct{}
+	pm              *pacemaker.Pacemaker
+	bc              *blockchain.BlockChain
+	store           blockchain.Store
+	lastVotedView   types.View
+	highQC          *blockchain.QC // also the lock
+	committedView   types.View
+	bufferedBlocks  map[types.View]*blockchain.Block
+	bufferedQCs     map[crypto.Identifier]*blockchain.QC
+	committedBlocks chan *blockchain.Block
+	forkedBlocks    chan *blockchain.Block
+	synchronizer    *blockchain.Synchronizer
 }
 
 // NewLbft creates a new Lbft instance
@@ -41,86 +53,98 @@ func NewLbft(
 	lb.pm = pm
 	lb.committedBlocks = committedBlocks
 	lb.forkedBlocks = forkedBlocks
-	lb.bc = blockchain.NewBlockchain(config.GetConfig().N())
-	lb.bufferedBlocks = make(map[crypto.Identifier]*blockchain.Block)
+	lb.store = store.NewStore(node.ID())
+	bc, err := blockchain.NewBlockchainWithStore(config.GetConfig().N(), lb.store)
+	if err != nil {
+		log.Fatalf("[%v] cannot create the blockchain: %v", node.ID(), err)
+	}
+	lb.bc = bc
+	lb.highQC = &blockchain.QC{View: 0}
+	lb.bufferedBlocks = make(map[types.View]*blockchain.Block)
 	lb.bufferedQCs = make(map[crypto.Identifier]*blockchain.QC)
-	lb.bufferedNotarizedBlock = make(map[crypto.Identifier]*blockchain.QC)
-	lb.notarizedChain = make([][]*blockchain.Block, 0)
-	lb.echoedBlock = make(map[crypto.Identifier]struct{})
-	lb.echoedVote = make(map[crypto.Identifier]struct{})
+	lb.synchronizer = blockchain.NewSynchronizer(config.GetTimer())
 	lb.pm.AdvanceView(0)
+	lb.recoverState()
 	return lb
 }
 
 // ProcessBlock processes an incoming block as follows:
-// 1. check if the view of the block matches current view (ignore for now)
-// 2. check if the view of the block matches the proposer's view (ignore for now)
+// 1. verify the QC carried by the block and process it, which may advance the view
+// 2. buffer the block if its view is ahead or its parent is missing
 // 3. insert the block into the block tree
-// 4. if the view of the block is lower than the current view, don't vote
-// 5. if the block is extending the longest notarized chain, vote for the block
-// 6. if the view of the block is higher than the the current view, buffer the block
-// and process it when entering that view
+// 4. vote for the block if it satisfies the voting rule, the vote is broadcast
 func (lb *Lbft) ProcessBlock(block *blockchain.Block) error {
 	if lb.bc.Exists(block.ID) {
 		return nil
 	}
-	log.Debugf("[%v] is processing block, view: %v, id: %x", lb.ID(), block.View, block.ID)
+	log.Debugf("[%v] is processing block from %v, view: %v, id: %x", lb.ID(), block.Proposer.Node(), block.View, block.ID)
+	if block.QC == nil {
+		return fmt.Errorf("the block should contain a QC")
+	}
+	if block.Proposer != lb.ID() {
+		blockIsVerified, _ := crypto.PubVerify(block.Sig, crypto.IDToByte(block.ID), block.Proposer)
+		if !blockIsVerified {
+			log.Warningf("[%v] received a block with an invalid signature", lb.ID())
+			return nil
+		}
+	}
+	// the qc is verified whatever its view, the voting rule relies on it
+	err := block.QC.VerifyFor(block)
+	if err != nil {
+		return fmt.Errorf("received a block with an invalid qc, view: %v, id: %x: %w", block.View, block.ID, err)
+	}
+	if block.Proposer != lb.ID() {
+		lb.processCertificate(block.QC)
+	}
 	curView := lb.pm.GetCurView()
 	if block.View < curView {
-		return fmt.Errorf("received a stale block")
+		log.Warningf("[%v] received a stale proposal from %v", lb.ID(), block.Proposer)
+		return nil
 	}
-	_, err := lb.bc.GetBlockByID(block.PrevID)
-	if err != nil && block.View > 1 {
-		// buffer future blocks
-		lb.bufferedBlocks[block.PrevID] = block
-		log.Debugf("[%v] buffer the block for future processing, view: %v, id: %x", lb.ID(), block.View, block.ID)
+	if block.View > curView {
+		lb.bufferedBlocks[block.View] = block
+		log.Debugf("[%v] the block is buffered, view: %v, id: %x", lb.ID(), block.View, block.ID)
 		return nil
 	}
 	if !lb.Election.IsLeader(block.Proposer, block.View) {
 		return fmt.Errorf("received a proposal (%v) from an invalid leader (%v)", block.View, block.Proposer)
 	}
-	if block.Proposer != lb.ID() {
-		blockIsVerified, _ := crypto.PubVerify(block.Sig, crypto.IDToByte(block.ID), block.Proposer)
-		if !blockIsVerified {
-			log.Warningf("[%v] received a block with an invalid signature", lb.ID())
-		}
-	}
-	_, exists := lb.echoedBlock[block.ID]
-	if !exists {
-		lb.echoedBlock[block.ID] = struct{}{}
-		lb.Broadcast(block)
+	if block.View > 1 && !lb.bc.Exists(block.PrevID) {
+		lb.bufferedBlocks[block.View] = block
+		log.Debugf("[%v] the parent is missing, the block is buffered, view: %v, id: %x", lb.ID(), block.View, block.ID)
+		lb.requestBlocks(block.PrevID, 1, block.Proposer)
+		return nil
 	}
 	lb.bc.AddBlock(block)
-	shouldVote := lb.votingRule(block)
-	if !shouldVote {
+	shouldVote, err := lb.votingRule(block)
+	if err != nil {
+		log.Warningf("[%v] cannot vote for the block, id: %x: %v", lb.ID(), block.ID, err)
+	}
+	if shouldVote {
+		lb.lastVotedView = block.View
+		// the vote must be remembered before it is sent out
+		lb.persistState()
+		vote := blockchain.MakeVote(block.View, lb.ID(), block.ID)
+		lb.Broadcast(vote)
+		lb.ProcessVote(vote)
+	} else {
 		log.Debugf("[%v] is not going to vote for block, id: %x", lb.ID(), block.ID)
-		lb.bufferedBlocks[block.PrevID] = block
-		log.Debugf("[%v] buffer the block for future processing, view: %v, id: %x", lb.ID(), block.View, block.ID)
-		return nil
 	}
-	vote := blockchain.MakeVote(block.View, lb.ID(), block.ID)
-	// vote to the current leader
-	lb.ProcessVote(vote)
-	lb.Broadcast(vote)
-
-	// process buffers
+	// the votes may arrive before the block
 	qc, ok := lb.bufferedQCs[block.ID]
 	if ok {
+		delete(lb.bufferedQCs, block.ID)
 		lb.processCertificate(qc)
 	}
-	b, ok := lb.bufferedBlocks[block.ID]
-	if ok {
-		_ = lb.ProcessBlock(b)
-	}
 	return nil
 }
 
 func (lb *Lbft) ProcessVote(vote *blockchain.Vote) {
-	log.Debugf("[%v] is processing the vote, block id: %x", lb.ID(), vote.BlockID)
+	log.Debugf("[%v] is processing the vote from %v, block id: %x", lb.ID(), vote.Voter, vote.BlockID)
 	if vote.Voter != lb.ID() {
 		voteIsVerified, err := crypto.PubVerify(vote.Signature, crypto.IDToByte(vote.BlockID), vote.Voter)
 		if err != nil {
-			log.Fatalf("[%v] Error in verifying the signature in vote id: %x", lb.ID(), vote.BlockID)
+			log.Warningf("[%v] Error in verifying the signature in vote id: %x", lb.ID(), vote.BlockID)
 			return
 		}
 		if !voteIsVerified {
@@ -128,26 +152,22 @@ func (lb *Lbft) ProcessVote(vote *blockchain.Vote) {
 			return
 		}
 	}
-	// echo the message
-	_, exists := lb.echoedBlock[vote.BlockID]
-	if !exists {
-		lb.echoedBlock[vote.BlockID] = struct{}{}
-		lb.Broadcast(vote)
-	}
 	isBuilt, qc := lb.bc.AddVote(vote)
 	if !isBuilt {
 		log.Debugf("[%v] votes are not sufficient to build a qc, view: %v, block id: %x", lb.ID(), vote.View, vote.BlockID)
 		return
 	}
-	// send the QC to the next leader
 	log.Debugf("[%v] a qc is built, view: %v, block id: %x", lb.ID(), qc.View, qc.BlockID)
+	// the qc is built from verified votes
+	qc.Leader = lb.ID()
 	lb.processCertificate(qc)
-
-	return
 }
 
 func (lb *Lbft) ProcessRemoteTmo(tmo *pacemaker.TMO) {
 	log.Debugf("[%v] is processing tmo from %v", lb.ID(), tmo.NodeID)
+	if tmo.HighQC != nil {
+		lb.processCertificate(tmo.HighQC)
+	}
 	isBuilt, tc := lb.pm.ProcessRemoteTmo(tmo)
 	if !isBuilt {
 		log.Debugf("[%v] not enough tc for %v", lb.ID(), tmo.View)
@@ -155,142 +175,175 @@ func (lb *Lbft) ProcessRemoteTmo(tmo *pacemaker.TMO) {
 	}
 	log.Debugf("[%v] a tc is built for view %v", lb.ID(), tc.View)
 	lb.processTC(tc)
+	// the leader of the next view may have missed some of the timeouts
+	nextLeader := lb.FindLeaderFor(tc.View + 1)
+	if nextLeader != lb.ID() {
+		lb.Send(nextLeader, tc)
+	}
 }
 
 func (lb *Lbft) ProcessLocalTmo(view types.View) {
-	tmo := &pacemaker.TMO{
-		View:   view,
-		NodeID: lb.ID(),
-	}
+	lb.pm.AdvanceView(view)
+	tmo := pacemaker.MakeTMO(view+1, lb.ID(), lb.highQC)
 	lb.Broadcast(tmo)
 	lb.ProcessRemoteTmo(tmo)
 }
 
+// MakeProposal extends the block of the highest QC,
+// which is the block of the previous view unless its leader failed
 func (lb *Lbft) MakeProposal(view types.View, payload []*message.Transaction) *blockchain.Block {
-	prevID := lb.forkChoice()
-	block := blockchain.MakeBlock(view, &blockchain.QC{
-		View:      0,
-		BlockID:   prevID,
-		AggSig:    nil,
-		Signature: nil,
-	}, prevID, payload, lb.ID())
+	qc := lb.highQC
+	block := blockchain.MakeBlock(view, qc, qc.BlockID, payload, lb.ID())
 	return block
 }
 
-func (lb *Lbft) forkChoice() crypto.Identifier {
-	var prevID crypto.Identifier
-	if lb.GetNotarizedHeight() == 0 {
-		prevID = crypto.MakeID("Genesis block")
-	} else {
-		tailNotarizedBlock := lb.notarizedChain[lb.GetNotarizedHeight()-1][0]
-		prevID = tailNotarizedBlock.ID
+// ProcessTC processes a tc received from another replica, the tc has been validated
+func (lb *Lbft) ProcessTC(tc *pacemaker.TC) {
+	log.Debugf("[%v] is processing a tc for view %v", lb.ID(), tc.View)
+	if tc.HighQC != nil {
+		lb.processCertificate(tc.HighQC)
 	}
-	return prevID
+	lb.processTC(tc)
 }
 
 func (lb *Lbft) processTC(tc *pacemaker.TC) {
 	if tc.View < lb.pm.GetCurView() {
 		return
 	}
-	go lb.pm.AdvanceView(tc.View)
+	lb.pm.AdvanceView(tc.View)
+	lb.processBufferedBlocks()
 }
 
-// 1. advance view
-// 2. update notarized chain
-// 3. check commit rule
-// 4. commit blocks
+// processCertificate processes a qc as follows:
+// 1. update the highest QC, which is also the lock
+// 2. advance the view
+// 3. commit the parent block if the commit rule is satisfied
+// 4. process the buffered blocks of the new view
 func (lb *Lbft) processCertificate(qc *blockchain.QC) {
-	log.Debugf("[%v] is processing a qc, view: %v, block id: %x", lb.ID(), qc.View, qc.BlockID)
-	if qc.View < lb.pm.GetCurView() {
-		return
-	}
-	_, err := lb.bc.GetBlockByID(qc.BlockID)
-	if err != nil && qc.View > 1 {
-		log.Debugf("[%v] buffered the QC, view: %v, id: %x", lb.ID(), qc.View, qc.BlockID)
-		lb.bufferedQCs[qc.BlockID] = qc
+	if qc.View == 0 || qc.View <= lb.committedView {
 		return
 	}
+	log.Debugf("[%v] is processing a qc, view: %v, block id: %x", lb.ID(), qc.View, qc.BlockID)
 	if qc.Leader != lb.ID() {
 		quorumIsVerified, _ := crypto.VerifyQuorumSignature(qc.AggSig, qc.BlockID, qc.Signers)
-		if quorumIsVerified == false {
+		if !quorumIsVerified {
 			log.Warningf("[%v] received a quorum with invalid signatures", lb.ID())
 			return
 		}
 	}
-	err = lb.updateNotarizedChain(qc)
+	if !lb.bc.Exists(qc.BlockID) {
+		log.Debugf("[%v] buffered the QC, view: %v, id: %x", lb.ID(), qc.View, qc.BlockID)
+		lb.bufferedQCs[qc.BlockID] = qc
+		lb.requestBlocks(qc.BlockID, 2, lb.FindLeaderFor(qc.View))
+		return
+	}
+	certified, err := lb.bc.GetBlockByID(qc.BlockID)
+	if err == nil {
+		err = qc.Certifies(certified)
+	}
 	if err != nil {
-		// the corresponding block does not exist
-		log.Debugf("[%v] cannot notarize the block, %x: %w", lb.ID(), qc.BlockID, err)
+		log.Warningf("[%v] received an invalid qc: %v", lb.ID(), err)
 		return
 	}
+	if qc.View > lb.highQC.View {
+		lb.highQC = qc
+		lb.persistState()
+	}
 	lb.pm.AdvanceView(qc.View)
-	if qc.View < 3 {
-		return
+	ok, block, err := lb.commitRule(qc)
+	if err != nil {
+		log.Debugf("[%v] cannot check the commit rule: %v", lb.ID(), err)
 	}
-	ok, block := lb.commitRule()
-	if !ok {
-		return
+	if ok {
+		lb.commit(block)
 	}
+	lb.processBufferedBlocks()
+}
+
+func (lb *Lbft) commit(block *blockchain.Block) {
 	committedBlocks, forkedBlocks, err := lb.bc.CommitBlock(block.ID, lb.pm.GetCurView())
 	if err != nil {
-		log.Errorf("[%v] cannot commit blocks", lb.ID())
+		log.Errorf("[%v] cannot commit blocks: %v", lb.ID(), err)
 		return
 	}
+	lb.committedView = block.View
 	for _, cBlock := range committedBlocks {
 		lb.committedBlocks <- cBlock
-		delete(lb.echoedBlock, cBlock.ID)
-		delete(lb.echoedVote, cBlock.ID)
 		log.Debugf("[%v] is going to commit block, view: %v, id: %x", lb.ID(), cBlock.View, cBlock.ID)
 	}
 	for _, fBlock := range forkedBlocks {
 		lb.forkedBlocks <- fBlock
 		log.Debugf("[%v] is going to collect forked block, view: %v, id: %x", lb.ID(), fBlock.View, fBlock.ID)
 	}
-	b, ok := lb.bufferedBlocks[qc.BlockID]
-	if ok {
-		log.Debugf("[%v] found a buffered block by qc, qc.BlockID: %x", lb.ID(), qc.BlockID)
+}
+
+// processBufferedBlocks processes the buffered blocks that are no longer ahead of the current view
+func (lb *Lbft) processBufferedBlocks() {
+	views := make([]types.View, 0, len(lb.bufferedBlocks))
+	for view := range lb.bufferedBlocks {
+		views = append(views, view)
+	}
+	sort.Slice(views, func(i, j int) bool { return views[i] < views[j] })
+	for _, view := range views {
+		b, ok := lb.bufferedBlocks[view]
+		if !ok || b.View > lb.pm.GetCurView() {
+			continue
+		}
+		delete(lb.bufferedBlocks, view)
+		if b.View < lb.pm.GetCurView() {
+			continue
+		}
 		_ = lb.ProcessBlock(b)
-		delete(lb.bufferedBlocks, qc.BlockID)
-	}
-	qc, ok = lb.bufferedNotarizedBlock[qc.BlockID]
-	if ok {
-		log.Debugf("[%v] found a bufferred qc, view: %v, block id: %x", lb.ID(), qc.View, qc.BlockID)
-		lb.processCertificate(qc)
-		delete(lb.bufferedQCs, qc.BlockID)
 	}
 }
 
-func (lb *Lbft) updateNotarizedChain(qc *blockchain.QC) error {
-	block, err := lb.bc.GetBlockByID(qc.BlockID)
-	if err != nil {
-		return fmt.Errorf("cannot find the block")
-	}
-	// check the last block in the notarized chain
-	// could be improved by checking view
-	if lb.GetNotarizedHeight() == 0 {
-		log.Debugf("[%v] is processing the first notarized block, view: %v, id: %x", lb.ID(), qc.View, qc.BlockID)
-		newArray := make([]*blockchain.Block, 0)
-		newArray = append(newArray, block)
-		lb.notarizedChain = append(lb.notarizedChain, newArray)
-		return nil
+// ProcessSyncRequest replies the blocks asked by a lagging replica
+func (lb *Lbft) ProcessSyncRequest(req *blockchain.SyncRequest) {
+	blocks := lb.bc.GetBlocks(req)
+	if len(blocks) == 0 {
+		log.Debugf("[%v] has no block requested by %v", lb.ID(), req.Requester)
+		return
 	}
-	for i := lb.GetNotarizedHeight() - 1; i >= 0 || i >= lb.GetNotarizedHeight()-3; i-- {
-		lastBlocks := lb.notarizedChain[i]
-		for _, b := range lastBlocks {
-			if b.ID == block.PrevID {
-				var blocks []*blockchain.Block
-				if i < lb.GetNotarizedHeight()-1 {
-					blocks = make([]*blockchain.Block, 0)
-				}
-				blocks = append(blocks, block)
-				lb.notarizedChain = append(lb.notarizedChain, blocks)
-				return nil
-			}
+	log.Debugf("[%v] is sending %v blocks to %v", lb.ID(), len(blocks), req.Requester)
+	lb.Send(req.Requester, &blockchain.SyncResponse{Responder: lb.ID(), Blocks: blocks})
+}
+
+// ProcessSyncResponse adds the fetched blocks to the chain
+// and then unblocks the buffered qcs and blocks waiting for them
+func (lb *Lbft) ProcessSyncResponse(resp *blockchain.SyncResponse) {
+	for _, block := range resp.Blocks {
+		lb.synchronizer.Done(block.ID)
+		if lb.bc.Exists(block.ID) {
+			continue
+		}
+		err := block.QC.VerifyFor(block)
+		if err == nil {
+			err = lb.bc.AddSyncedBlock(block)
 		}
+		if err != nil {
+			log.Warningf("[%v] received an invalid block from %v: %v", lb.ID(), resp.Responder, err)
+			return
+		}
+		log.Debugf("[%v] synced a block from %v, view: %v, id: %x", lb.ID(), resp.Responder, block.View, block.ID)
+		if block.QC != nil {
+			lb.processCertificate(block.QC)
+		}
+		qc, ok := lb.bufferedQCs[block.ID]
+		if ok {
+			delete(lb.bufferedQCs, block.ID)
+			lb.processCertificate(qc)
+		}
+	}
+	lb.processBufferedBlocks()
+}
+
+// requestBlocks asks the peer for the block and its ancestors unless they are being fetched
+func (lb *Lbft) requestBlocks(id crypto.Identifier, count int, peer identity.NodeID) {
+	if peer == lb.ID() || !lb.synchronizer.ShouldRequest(id) {
+		return
 	}
-	lb.bufferedNotarizedBlock[block.PrevID] = qc
-	log.Debugf("[%v] the parent block is not notarized, buffered for now, view: %v, block id: %x", lb.ID(), qc.View, qc.BlockID)
-	return fmt.Errorf("the block is not extending the notarized chain")
+	log.Debugf("[%v] requests %v blocks from %v, id: %x", lb.ID(), count, peer, id)
+	lb.Send(peer, blockchain.MakeSyncRequest(lb.ID(), id, count))
 }
 
 func (lb *Lbft) GetChainStatus() string {
@@ -299,52 +352,76 @@ func (lb *Lbft) GetChainStatus() string {
 	return fmt.Sprintf("[%v] The current view is: %v, chain growth rate is: %v, ave block interval is: %v", lb.ID(), lb.pm.GetCurView(), chainGrowthRate, blockIntervals)
 }
 
-func (lb *Lbft) GetNotarizedHeight() int {
-	return len(lb.notarizedChain)
+// votingRule is aware of the leader rotation:
+// 1. only the block of the current view is voted, at most once per view
+// 2. the block must extend the block certified by its QC, and the QC must be of the view of that block
+// 3. the QC must not be lower than the lock; in the normal case it certifies
+// the block of the previous leader, after a leader failed it is the highest QC known to a quorum
+func (lb *Lbft) votingRule(block *blockchain.Block) (bool, error) {
+	if block.View != lb.pm.GetCurView() || block.View <= lb.lastVotedView {
+		return false, nil
+	}
+	if block.View == 1 {
+		return true, nil
+	}
+	parent, err := lb.bc.GetBlockByID(block.PrevID)
+	if err != nil {
+		return false, fmt.Errorf("the qc does not certify the parent, view: %v: %w", block.View, err)
+	}
+	// the view of the qc is not signed, it is compared with the lock only once bound to the parent
+	if err := block.QC.Certifies(parent); err != nil {
+		return false, err
+	}
+	if block.QC.View >= block.View {
+		return false, fmt.Errorf("the qc view %v is not lower than the block view %v", block.QC.View, block.View)
+	}
+	return block.QC.View >= lb.highQC.View, nil
 }
 
-// 1. get the tail of the longest notarized chain (could be more than one)
-// 2. check if the block is extending one of them
-func (lb *Lbft) votingRule(block *blockchain.Block) bool {
-	if block.View <= 2 {
-		return true
-	}
-	lastBlocks := lb.notarizedChain[lb.GetNotarizedHeight()-1]
-	for _, b := range lastBlocks {
-		if block.PrevID == b.ID {
-			return true
-		}
+// commitRule checks if the certified block and its parent are proposed in consecutive views.
+// If so, the parent is committed: a quorum has voted for the child and thus locked on the parent,
+// and no conflicting block can be certified in any later view.
+func (lb *Lbft) commitRule(qc *blockchain.QC) (bool, *blockchain.Block, error) {
+	block, err := lb.bc.GetBlockByID(qc.BlockID)
+	if err != nil {
+		return false, nil, fmt.Errorf("cannot commit any block: %w", err)
 	}
-
-	return false
+	parent, err := lb.bc.GetParentBlock(block.ID)
+	if err != nil {
+		return false, nil, fmt.Errorf("cannot commit any block: %w", err)
+	}
+	if parent.View+1 != block.View || parent.View <= lb.committedView {
+		return false, nil, nil
+	}
+	return true, parent, nil
 }
 
-// 1. get the last three blocks in the notarized chain
-// 2. check if they are consecutive
-// 3. if so, return the second block to commit
-func (lb *Lbft) commitRule() (bool, *blockchain.Block) {
-	height := lb.GetNotarizedHeight()
-	if height < 3 {
-		return false, nil
+// recoverState restores the safety state saved before a restart
+func (lb *Lbft) recoverState() {
+	state, err := lb.store.LoadState()
+	if err != nil {
+		log.Fatalf("[%v] cannot load the safety state: %v", lb.ID(), err)
 	}
-	lastBlocks := lb.notarizedChain[height-1]
-	if len(lastBlocks) != 1 {
-		return false, nil
+	if state == nil {
+		return
 	}
-	lastBlock := lastBlocks[0]
-	secondBlocks := lb.notarizedChain[height-2]
-	if len(secondBlocks) != 1 {
-		return false, nil
+	lb.lastVotedView = state.LastVotedView
+	if state.HighQC != nil {
+		lb.highQC = state.HighQC
 	}
-	secondBlock := secondBlocks[0]
-	firstBlocks := lb.notarizedChain[height-3]
-	if len(firstBlocks) != 1 {
-		return false, nil
+	log.Infof("[%v] recovered the safety state, last voted view: %v, high qc view: %v", lb.ID(), lb.lastVotedView, lb.highQC.View)
+	if lb.highQC.View > 0 {
+		lb.pm.AdvanceView(lb.highQC.View)
 	}
-	firstBlock := firstBlocks[0]
-	// check three-chain
-	if ((firstBlock.View + 1) == secondBlock.View) && ((secondBlock.View + 1) == lastBlock.View) {
-		return true, secondBlock
+}
+
+// persistState saves the safety state
+func (lb *Lbft) persistState() {
+	err := lb.store.SaveState(&blockchain.SafetyState{
+		LastVotedView: lb.lastVotedView,
+		HighQC:        lb.highQC,
+	})
+	if err != nil {
+		log.Errorf("[%v] cannot persist the safety state: %v", lb.ID(), err)
 	}
-	return false, nil
 }
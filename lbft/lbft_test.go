@@ -1 +1,215 @@
 package lbft
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/gitferry/bamboo/blockchain"
+	"github.com/gitferry/bamboo/config"
+	"github.com/gitferry/bamboo/crypto"
+	"github.com/gitferry/bamboo/identity"
+	"github.com/gitferry/bamboo/pacemaker"
+	"github.com/gitferry/bamboo/types"
+)
+
+func newTestLbft() *Lbft {
+	config.Configuration.Signer = crypto.BLS_BLS12381
+	crypto.SetKeysWith(4, crypto.BLS_BLS12381)
+	return &Lbft{
+		bc:     blockchain.NewBlockchain(4),
+		pm:     pacemaker.NewPacemaker(4),
+		highQC: &blockchain.QC{View: 0},
+	}
+}
+
+// makeBlock creates a block of the view extending the parent certified in its view,
+// the block is added to the chain
+func (lb *Lbft) makeBlock(view types.View, parent *blockchain.Block) *blockchain.Block {
+	qc := &blockchain.QC{View: 0}
+	if parent != nil {
+		qc = &blockchain.QC{View: parent.View, BlockID: parent.ID}
+	}
+	block := blockchain.MakeBlock(view, qc, qc.BlockID, nil, identity.NewNodeID(int(view)%4+1))
+	lb.bc.AddBlock(block)
+	return block
+}
+
+// enter moves the replica to the view
+func (lb *Lbft) enter(view types.View) {
+	lb.pm.AdvanceView(view - 1)
+}
+
+// the leaders rotate without failures, every block extends the block of the previous view
+func TestLbft_VotingRule_Normal(t *testing.T) {
+	lb := newTestLbft()
+	b1 := lb.makeBlock(1, nil)
+	lb.enter(1)
+	ok, err := lb.votingRule(b1)
+	require.NoError(t, err)
+	require.True(t, ok)
+	lb.lastVotedView = 1
+
+	b2 := lb.makeBlock(2, b1)
+	lb.highQC = b2.QC
+	lb.enter(2)
+	ok, err = lb.votingRule(b2)
+	require.NoError(t, err)
+	require.True(t, ok)
+}
+
+// only the block of the current view is voted and only once
+func TestLbft_VotingRule_View(t *testing.T) {
+	lb := newTestLbft()
+	b1 := lb.makeBlock(1, nil)
+	b2 := lb.makeBlock(2, b1)
+	lb.highQC = b2.QC
+	// a future block
+	lb.enter(1)
+	ok, _ := lb.votingRule(b2)
+	require.False(t, ok)
+	// an equivocating block of a voted view
+	lb.enter(2)
+	lb.lastVotedView = 2
+	ok, _ = lb.votingRule(b2)
+	require.False(t, ok)
+	// a stale block
+	lb.lastVotedView = 1
+	lb.enter(3)
+	ok, _ = lb.votingRule(b2)
+	require.False(t, ok)
+}
+
+// after the leader of view 4 failed, the leader of view 5 must extend the locked block
+func TestLbft_VotingRule_Lock(t *testing.T) {
+	lb := newTestLbft()
+	b1 := lb.makeBlock(1, nil)
+	b2 := lb.makeBlock(2, b1)
+	b3 := lb.makeBlock(3, b2)
+	// the replica voted for b3 and then learnt its qc
+	lb.lastVotedView = 3
+	lb.highQC = &blockchain.QC{View: 3, BlockID: b3.ID}
+	lb.enter(5)
+
+	// a fork from b2 conflicts with the lock
+	fork := lb.makeBlock(5, b2)
+	ok, err := lb.votingRule(fork)
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	// the block extending the locked one is voted although the view is skipped
+	b5 := lb.makeBlock(5, b3)
+	ok, err = lb.votingRule(b5)
+	require.NoError(t, err)
+	require.True(t, ok)
+}
+
+// a block whose qc does not certify its parent is rejected
+func TestLbft_VotingRule_InvalidQC(t *testing.T) {
+	lb := newTestLbft()
+	b1 := lb.makeBlock(1, nil)
+	b2 := lb.makeBlock(2, b1)
+	block := blockchain.MakeBlock(3, &blockchain.QC{View: 2, BlockID: crypto.MakeID("other")}, b2.ID, nil, identity.NewNodeID(1))
+	lb.enter(3)
+	ok, err := lb.votingRule(block)
+	require.Error(t, err)
+	require.False(t, ok)
+}
+
+// a qc pretending to be higher than the lock is not voted for
+func TestLbft_VotingRule_QCView(t *testing.T) {
+	lb := newTestLbft()
+	b1 := lb.makeBlock(1, nil)
+	b2 := lb.makeBlock(2, b1)
+	lb.highQC = &blockchain.QC{View: 2, BlockID: b2.ID}
+	block := blockchain.MakeBlock(3, &blockchain.QC{View: 2, BlockID: b1.ID}, b1.ID, nil, identity.NewNodeID(1))
+	lb.enter(3)
+	ok, err := lb.votingRule(block)
+	require.Error(t, err)
+	require.False(t, ok)
+}
+
+// a certified block commits its parent of the previous view
+func TestLbft_CommitRule_Consecutive(t *testing.T) {
+	lb := newTestLbft()
+	b1 := lb.makeBlock(1, nil)
+	b2 := lb.makeBlock(2, b1)
+	b3 := lb.makeBlock(3, b2)
+	ok, block, err := lb.commitRule(&blockchain.QC{View: 3, BlockID: b3.ID})
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, b2.ID, block.ID)
+}
+
+// nothing is committed when the leader of the view in between failed,
+// the blocks are committed along with the next consecutive pair
+func TestLbft_CommitRule_LeaderFailure(t *testing.T) {
+	lb := newTestLbft()
+	b1 := lb.makeBlock(1, nil)
+	b2 := lb.makeBlock(2, b1)
+	b4 := lb.makeBlock(4, b2)
+	ok, _, err := lb.commitRule(&blockchain.QC{View: 4, BlockID: b4.ID})
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	b5 := lb.makeBlock(5, b4)
+	ok, block, err := lb.commitRule(&blockchain.QC{View: 5, BlockID: b5.ID})
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, b4.ID, block.ID)
+	committed, _, err := lb.bc.CommitBlock(block.ID, 5)
+	require.NoError(t, err)
+	require.Len(t, committed, 3)
+	require.Equal(t, b1.ID, committed[0].ID)
+	require.Equal(t, b2.ID, committed[1].ID)
+	require.Equal(t, b4.ID, committed[2].ID)
+}
+
+// a block is not committed twice
+func TestLbft_CommitRule_Committed(t *testing.T) {
+	lb := newTestLbft()
+	b1 := lb.makeBlock(1, nil)
+	b2 := lb.makeBlock(2, b1)
+	lb.committedView = 1
+	ok, _, err := lb.commitRule(&blockchain.QC{View: 2, BlockID: b2.ID})
+	require.NoError(t, err)
+	require.False(t, ok)
+}
+
+// a block is rejected unless its qc carries the signatures of a quorum
+func TestLbft_VerifyQC(t *testing.T) {
+	lb := newTestLbft()
+	b1 := lb.makeBlock(1, nil)
+	require.NoError(t, b1.QC.VerifyFor(b1))
+
+	// a forged qc without signatures
+	b2 := lb.makeBlock(2, b1)
+	require.Error(t, b2.QC.VerifyFor(b2))
+
+	var qc *blockchain.QC
+	for _, id := range []identity.NodeID{"1", "2", "3"} {
+		_, qc = lb.bc.AddVote(blockchain.MakeVote(1, id, b1.ID))
+	}
+	require.NotNil(t, qc)
+	b2 = blockchain.MakeBlock(2, qc, b1.ID, nil, "3")
+	require.NoError(t, b2.QC.VerifyFor(b2))
+
+	// the qc must certify the parent
+	b3 := blockchain.MakeBlock(3, qc, b2.ID, nil, "4")
+	require.Error(t, b3.QC.VerifyFor(b3))
+}
+
+// the view of a qc is only trusted if it is the view of the certified block
+func TestLbft_QCView(t *testing.T) {
+	lb := newTestLbft()
+	b1 := lb.makeBlock(1, nil)
+	b3 := lb.makeBlock(3, b1)
+	require.NoError(t, b3.QC.Certifies(b1))
+
+	// the signatures do not cover the view, a forged view pretends that the views are consecutive
+	qc := &blockchain.QC{View: 2, BlockID: b3.ID}
+	require.Error(t, qc.Certifies(b3))
+	ok, _, err := lb.commitRule(qc)
+	require.NoError(t, err)
+	require.False(t, ok)
+}
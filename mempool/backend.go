@@ -4,52 +4,147 @@ import (
 	"container/list"
 	"github.com/gitferry/bamboo/message"
 	"sync"
+	"time"
 )
 
+// recentCommits is the number of committed ids kept exactly,
+// a false positive of the filter can only reject a new transaction once they are exceeded
+const recentCommits = 1 << 16
+
 type Backend struct {
 	txns          *list.List
 	limit         int
 	totalReceived int64
-	*BloomFilter
-	mu *sync.Mutex
+	pending       map[string]*message.Transaction // received but not yet committed
+	executed      map[string]struct{}             // the latest committed transactions
+	order         *list.List                      // ids of executed in commit order
+	pruned        bool                            // some committed ids are only left in the filter
+	policy        Policy
+	*BloomFilter  // all the committed transactions, the only record of the pruned ones
+	mu            *sync.Mutex
 }
 
-func NewBackend(limit int) *Backend {
+// NewBackend creates a backend holding at most limit queued transactions, no limit if it is not positive
+func NewBackend(limit int, policy Policy) *Backend {
 	var mu sync.Mutex
 	return &Backend{
 		txns:        list.New(),
+		pending:     make(map[string]*message.Transaction),
+		executed:    make(map[string]struct{}),
+		order:       list.New(),
+		policy:      policy,
 		BloomFilter: NewBloomFilter(),
 		mu:          &mu,
 		limit:       limit,
 	}
 }
 
-func (b *Backend) insertBack(txn *message.Transaction) {
+// insertBack queues a new transaction in the order of the policy,
+// it returns the reason if the transaction is not admitted
+func (b *Backend) insertBack(txn *message.Transaction) error {
 	if txn == nil {
-		return
+		return nil
 	}
 	b.mu.Lock()
 	defer b.mu.Unlock()
-	if b.size() > b.limit {
-		return
+	if b.seen(txn.ID) {
+		return ErrDuplicate
+	}
+	b.evictExpired(time.Now())
+	if err := b.policy.Admit(txn); err != nil {
+		return err
+	}
+	if b.full() {
+		last := b.txns.Back()
+		if last == nil || !b.policy.Before(txn, last.Value.(*message.Transaction)) {
+			return ErrFull
+		}
+		b.evict(last, ErrFull)
 	}
 	b.totalReceived++
-	b.txns.PushBack(txn)
+	b.pending[txn.ID] = txn
+	e := b.txns.Back()
+	for e != nil && b.policy.Before(txn, e.Value.(*message.Transaction)) {
+		e = e.Prev()
+	}
+	if e == nil {
+		b.txns.PushFront(txn)
+	} else {
+		b.txns.InsertAfter(txn, e)
+	}
+	b.policy.Added(txn)
+	return nil
 }
 
+// insertFront queues a transaction collected back from a forked block ahead of the new ones,
+// the transactions that no longer fit are evicted
 func (b *Backend) insertFront(txn *message.Transaction) {
 	if txn == nil {
 		return
 	}
 	b.mu.Lock()
 	defer b.mu.Unlock()
-	b.txns.PushFront(txn)
+	if b.isCommitted(txn.ID) {
+		return
+	}
+	e := b.txns.Front()
+	for e != nil && b.policy.Before(e.Value.(*message.Transaction), txn) {
+		e = e.Next()
+	}
+	if e == nil {
+		b.txns.PushBack(txn)
+	} else {
+		b.txns.InsertBefore(txn, e)
+	}
+	b.policy.Added(txn)
+	for b.limit > 0 && b.size() > b.limit {
+		b.evict(b.txns.Back(), ErrFull)
+	}
 }
 
 func (b *Backend) size() int {
 	return b.txns.Len()
 }
 
+func (b *Backend) full() bool {
+	return b.limit > 0 && b.size() >= b.limit
+}
+
+func (b *Backend) seen(id string) bool {
+	_, exists := b.pending[id]
+	return exists || b.isCommitted(id)
+}
+
+// isCommitted tells whether the transaction has been committed,
+// the latest commits are exact and older ones are trusted to the bloom filter
+func (b *Backend) isCommitted(id string) bool {
+	if !b.Contains(id) {
+		return false
+	}
+	_, exists := b.executed[id]
+	return exists || b.pruned
+}
+
+// evict removes the queued transaction and tells its client the reason
+func (b *Backend) evict(e *list.Element, err error) {
+	txn := b.txns.Remove(e).(*message.Transaction)
+	delete(b.pending, txn.ID)
+	b.policy.Removed(txn)
+	if txn.C != nil {
+		txn.Reply(message.TransactionReply{Command: txn.Command, Err: err})
+	}
+}
+
+func (b *Backend) evictExpired(now time.Time) {
+	for e := b.txns.Front(); e != nil; {
+		next := e.Next()
+		if b.policy.Expired(e.Value.(*message.Transaction), now) {
+			b.evict(e, ErrExpired)
+		}
+		e = next
+	}
+}
+
 func (b *Backend) front() *message.Transaction {
 	if b.size() == 0 {
 		return nil
@@ -60,25 +155,46 @@ func (b *Backend) front() *message.Transaction {
 		return nil
 	}
 	b.txns.Remove(ele)
+	b.policy.Removed(val)
 	return val
 }
 
+// some pops at most n transactions, the ones committed in the meantime are dropped
 func (b *Backend) some(n int) []*message.Transaction {
-	var batchSize int
 	b.mu.Lock()
 	defer b.mu.Unlock()
-	batchSize = b.size()
-	if batchSize >= n {
-		batchSize = n
-	}
-	batch := make([]*message.Transaction, 0, batchSize)
-	for i := 0; i < batchSize; i++ {
+	b.evictExpired(time.Now())
+	batch := make([]*message.Transaction, 0, n)
+	for len(batch) < n && b.size() > 0 {
 		tx := b.front()
+		if tx == nil || b.isCommitted(tx.ID) {
+			continue
+		}
 		batch = append(batch, tx)
 	}
 	return batch
 }
 
-func (b *Backend) addToBloom(id string) {
+// commit records the transaction as committed and returns the pending one of the same id, if any
+func (b *Backend) commit(id string) *message.Transaction {
+	b.mu.Lock()
+	defer b.mu.Unlock()
 	b.Add(id)
+	if _, exists := b.executed[id]; !exists {
+		b.executed[id] = struct{}{}
+		b.order.PushBack(id)
+	}
+	for b.order.Len() > recentCommits {
+		delete(b.executed, b.order.Remove(b.order.Front()).(string))
+		b.pruned = true
+	}
+	txn := b.pending[id]
+	delete(b.pending, id)
+	return txn
+}
+
+func (b *Backend) committed(id string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.isCommitted(id)
 }
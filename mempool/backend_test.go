@@ -0,0 +1,122 @@
+package mempool
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/gitferry/bamboo/identity"
+	"github.com/gitferry/bamboo/message"
+)
+
+func txnWith(id string, client string, fee string) *message.Transaction {
+	txn := &message.Transaction{ID: id, Timestamp: time.Now()}
+	txn.Command.ClientID = identity.NodeID(client)
+	if fee != "" {
+		txn.Properties = map[string]string{FeeProperty: fee}
+	}
+	return txn
+}
+
+func ids(txns []*message.Transaction) []string {
+	var ids []string
+	for _, txn := range txns {
+		ids = append(ids, txn.ID)
+	}
+	return ids
+}
+
+// a transaction is inserted once and never proposed again after it is committed
+func TestBackend_Dedup(t *testing.T) {
+	b := NewBackend(100, NewPolicy(FIFO, 0, 0))
+	tx1 := &message.Transaction{ID: "c1-1"}
+	tx2 := &message.Transaction{ID: "c1-2"}
+	require.NoError(t, b.insertBack(tx1))
+	require.Equal(t, ErrDuplicate, b.insertBack(&message.Transaction{ID: "c1-1"}))
+	require.NoError(t, b.insertBack(tx2))
+
+	require.Equal(t, tx1, b.commit("c1-1"))
+	require.Equal(t, ErrDuplicate, b.insertBack(&message.Transaction{ID: "c1-1"}))
+	require.Equal(t, []*message.Transaction{tx2}, b.some(10))
+
+	// a forked transaction committed by another block is not collected back
+	b.insertFront(tx1)
+	require.Empty(t, b.some(10))
+}
+
+// before any commit is pruned, a false positive of the bloom filter neither rejects nor skips a transaction
+func TestBackend_BloomFalsePositive(t *testing.T) {
+	b := NewBackend(100, NewPolicy(FIFO, 0, 0))
+	b.Add("c2-1")
+	require.True(t, b.Contains("c2-1"))
+	require.False(t, b.committed("c2-1"))
+
+	tx := &message.Transaction{ID: "c2-1"}
+	require.NoError(t, b.insertBack(tx))
+	require.Equal(t, []*message.Transaction{tx}, b.some(10))
+	require.Equal(t, tx, b.commit("c2-1"))
+	require.True(t, b.committed("c2-1"))
+}
+
+// only the latest commits are kept exactly, older ones are still committed through the filter
+func TestBackend_PruneCommitted(t *testing.T) {
+	b := NewBackend(100, NewPolicy(FIFO, 0, 0))
+	for i := 0; i <= recentCommits; i++ {
+		b.commit(fmt.Sprintf("c1-%d", i))
+	}
+	require.Len(t, b.executed, recentCommits)
+	require.NotContains(t, b.executed, "c1-0")
+	require.True(t, b.committed("c1-0"))
+	require.Equal(t, ErrDuplicate, b.insertBack(&message.Transaction{ID: "c1-0"}))
+	require.False(t, b.committed("c2-1"))
+}
+
+// a full fifo pool rejects new transactions and recollected ones push out the newest
+func TestBackend_FIFO(t *testing.T) {
+	b := NewBackend(2, NewPolicy(FIFO, 0, 0))
+	require.NoError(t, b.insertBack(txnWith("1", "c1", "")))
+	require.NoError(t, b.insertBack(txnWith("2", "c1", "")))
+	require.Equal(t, ErrFull, b.insertBack(txnWith("3", "c1", "")))
+
+	b.insertFront(txnWith("0", "c1", ""))
+	require.Equal(t, []string{"0", "1"}, ids(b.some(10)))
+}
+
+// higher fees are proposed first and replace the lowest ones when the pool is full
+func TestBackend_Priority(t *testing.T) {
+	reply := make(chan message.TransactionReply, 1)
+	b := NewBackend(2, NewPolicy(PRIORITY, 0, 0))
+	low := txnWith("low", "c1", "1")
+	low.C = reply
+	require.NoError(t, b.insertBack(low))
+	require.NoError(t, b.insertBack(txnWith("high", "c1", "5")))
+	require.Equal(t, ErrFull, b.insertBack(txnWith("none", "c1", "")))
+	require.NoError(t, b.insertBack(txnWith("mid", "c1", "3")))
+	require.Equal(t, ErrFull, (<-reply).Err)
+	require.Equal(t, []string{"high", "mid"}, ids(b.some(10)))
+}
+
+// a client cannot queue more than its quota
+func TestBackend_Fair(t *testing.T) {
+	b := NewBackend(10, NewPolicy(FAIR, 2, 0))
+	require.NoError(t, b.insertBack(txnWith("1", "c1", "")))
+	require.NoError(t, b.insertBack(txnWith("2", "c1", "")))
+	require.Equal(t, ErrQuota, b.insertBack(txnWith("3", "c1", "")))
+	require.NoError(t, b.insertBack(txnWith("4", "c2", "")))
+
+	b.some(1)
+	require.NoError(t, b.insertBack(txnWith("5", "c1", "")))
+}
+
+// expired transactions are evicted and can be submitted again
+func TestBackend_TTL(t *testing.T) {
+	b := NewBackend(10, NewPolicy(TTL, 0, time.Minute))
+	old := txnWith("old", "c1", "")
+	require.NoError(t, b.insertBack(old))
+	old.Timestamp = time.Now().Add(-time.Hour)
+	require.NoError(t, b.insertBack(txnWith("new", "c1", "")))
+	require.Equal(t, []string{"new"}, ids(b.some(10)))
+	require.NoError(t, b.insertBack(txnWith("old", "c1", "")))
+}
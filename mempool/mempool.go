@@ -13,16 +13,16 @@ type MemPool struct {
 // NewTransactions creates a new memory pool for transactions.
 func NewMemPool() *MemPool {
 	mp := &MemPool{
-		Backend: NewBackend(config.GetConfig().MemSize),
+		Backend: NewBackend(config.GetConfig().MemSize, NewPolicy(config.GetConfig().MempoolPolicy,
+			config.GetConfig().ClientQuota, time.Duration(config.GetConfig().TxnTTL)*time.Millisecond)),
 	}
 
 	return mp
 }
 
-func (mp *MemPool) addNew(tx *message.Transaction) {
+func (mp *MemPool) addNew(tx *message.Transaction) error {
 	tx.Timestamp = time.Now()
-	mp.Backend.insertBack(tx)
-	mp.Backend.addToBloom(tx.ID)
+	return mp.Backend.insertBack(tx)
 }
 
 func (mp *MemPool) addOld(tx *message.Transaction) {
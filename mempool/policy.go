@@ -0,0 +1,132 @@
+package mempool
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/gitferry/bamboo/identity"
+	"github.com/gitferry/bamboo/message"
+)
+
+// admission policies
+const (
+	FIFO     = "fifo"
+	PRIORITY = "priority"
+	FAIR     = "fair"
+	TTL      = "ttl"
+)
+
+// properties of a transaction read by the priority policy, the fee takes precedence
+const (
+	FeeProperty      = "fee"
+	PriorityProperty = "priority"
+)
+
+// errors of the transactions that are rejected or evicted by the memory pool
+var (
+	ErrDuplicate = errors.New("duplicate transaction")
+	ErrFull      = errors.New("the memory pool is full")
+	ErrQuota     = errors.New("the client exceeds its quota")
+	ErrExpired   = errors.New("the transaction expired")
+)
+
+// Policy decides which transactions enter the memory pool,
+// in which order they are proposed and when they are evicted
+type Policy interface {
+	// Admit returns an error if the transaction is rejected regardless of the capacity
+	Admit(txn *message.Transaction) error
+	// Added is called when the transaction enters the queue
+	Added(txn *message.Transaction)
+	// Removed is called when the transaction leaves the queue
+	Removed(txn *message.Transaction)
+	// Before tells whether a is proposed before b, a full pool evicts its last transaction for a preceding one
+	Before(a, b *message.Transaction) bool
+	// Expired tells whether the transaction is evicted at the given time
+	Expired(txn *message.Transaction, now time.Time) bool
+}
+
+// NewPolicy creates a policy, quota is the number of queued transactions per client
+// of the fair policy and ttl is how long a transaction may wait with the ttl policy
+func NewPolicy(policy string, quota int, ttl time.Duration) Policy {
+	switch policy {
+	case PRIORITY:
+		return new(priority)
+	case FAIR:
+		return &fair{quota: quota, queued: make(map[identity.NodeID]int)}
+	case TTL:
+		return &expiring{ttl: ttl}
+	default:
+		return new(fifo)
+	}
+}
+
+// fifo proposes transactions in the order of arrival and rejects them once the pool is full
+type fifo struct{}
+
+func (f *fifo) Admit(txn *message.Transaction) error { return nil }
+
+func (f *fifo) Added(txn *message.Transaction) {}
+
+func (f *fifo) Removed(txn *message.Transaction) {}
+
+func (f *fifo) Before(a, b *message.Transaction) bool { return false }
+
+func (f *fifo) Expired(txn *message.Transaction, now time.Time) bool { return false }
+
+// priority proposes transactions with higher fees first and lets them replace the lowest ones when the pool is full
+type priority struct {
+	fifo
+}
+
+func (p *priority) Before(a, b *message.Transaction) bool {
+	return priorityOf(a) > priorityOf(b)
+}
+
+// priorityOf returns the fee or the priority of the transaction, 0 if it has none
+func priorityOf(txn *message.Transaction) int64 {
+	for _, key := range []string{FeeProperty, PriorityProperty} {
+		if v, ok := txn.Properties[key]; ok {
+			p, err := strconv.ParseInt(v, 10, 64)
+			if err == nil {
+				return p
+			}
+		}
+	}
+	return 0
+}
+
+// fair bounds the number of queued transactions of every client so that no client can fill the pool
+type fair struct {
+	fifo
+	quota  int
+	queued map[identity.NodeID]int
+}
+
+func (f *fair) Admit(txn *message.Transaction) error {
+	if f.quota > 0 && f.queued[txn.Command.ClientID] >= f.quota {
+		return ErrQuota
+	}
+	return nil
+}
+
+func (f *fair) Added(txn *message.Transaction) {
+	f.queued[txn.Command.ClientID]++
+}
+
+func (f *fair) Removed(txn *message.Transaction) {
+	f.queued[txn.Command.ClientID]--
+	if f.queued[txn.Command.ClientID] <= 0 {
+		delete(f.queued, txn.Command.ClientID)
+	}
+}
+
+// expiring evicts the transactions that wait longer than the ttl
+type expiring struct {
+	fifo
+	ttl time.Duration
+}
+
+func (e *expiring) Expired(txn *message.Transaction, now time.Time) bool {
+	return e.ttl > 0 && now.Sub(txn.Timestamp) > e.ttl
+}
@@ -19,14 +19,25 @@ func (pd *Producer) GeneratePayload() []*message.Transaction {
 	return pd.mempool.some(config.Configuration.BSize)
 }
 
-func (pd *Producer) AddTxn(txn *message.Transaction) {
-	pd.mempool.addNew(txn)
+// AddTxn adds a new transaction, it returns the reason if the transaction is rejected
+func (pd *Producer) AddTxn(txn *message.Transaction) error {
+	return pd.mempool.addNew(txn)
 }
 
 func (pd *Producer) CollectTxn(txn *message.Transaction) {
 	pd.mempool.addOld(txn)
 }
 
+// Commit marks the transaction as committed and returns the local copy of it, if any
+func (pd *Producer) Commit(txn *message.Transaction) *message.Transaction {
+	return pd.mempool.commit(txn.ID)
+}
+
+// IsCommitted tells whether the transaction has been committed
+func (pd *Producer) IsCommitted(txn *message.Transaction) bool {
+	return pd.mempool.committed(txn.ID)
+}
+
 func (pd *Producer) TotalReceivedTxNo() int64 {
 	return pd.mempool.totalReceived
 }
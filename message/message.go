@@ -1,24 +1,24 @@
 package message
 
 import (
-	"encoding/gob"
 	"fmt"
 	"time"
 
 	"github.com/gitferry/bamboo/config"
 	"github.com/gitferry/bamboo/db"
 	"github.com/gitferry/bamboo/identity"
+	"github.com/gitferry/bamboo/transport"
 )
 
 func init() {
-	gob.Register(Transaction{})
-	gob.Register(TransactionReply{})
-	gob.Register(Query{})
-	gob.Register(QueryReply{})
-	gob.Register(Read{})
-	gob.Register(ReadReply{})
-	gob.Register(Register{})
-	gob.Register(config.Config{})
+	transport.Register(Transaction{})
+	transport.Register(TransactionReply{})
+	transport.Register(Query{})
+	transport.Register(QueryReply{})
+	transport.Register(Read{})
+	transport.Register(ReadReply{})
+	transport.Register(Register{})
+	transport.Register(config.Config{})
 }
 
 /***************************
@@ -32,7 +32,7 @@ type Transaction struct {
 	Timestamp  time.Time
 	NodeID     identity.NodeID // forward by node
 	ID         string
-	C          chan TransactionReply // reply channel created by request receiver
+	C          chan TransactionReply `json:"-"` // reply channel created by request receiver
 }
 
 // TransactionReply replies to current client session
@@ -67,21 +67,29 @@ func (r TransactionReply) String() string {
 type Read struct {
 	CommandID int
 	Key       db.Key
+	ClientID  identity.NodeID
+	C         chan ReadReply `json:"-"` // reply channel created by request receiver
+}
+
+// Reply replies the value of the key to the client
+func (r *Read) Reply(reply ReadReply) {
+	r.C <- reply
 }
 
 func (r Read) String() string {
 	return fmt.Sprintf("Read {cid=%d, key=%d}", r.CommandID, r.Key)
 }
 
-// ReadReply cid and value of reading key
+// ReadReply cid and value of reading key, or the error of a read that is rejected or not committed
 type ReadReply struct {
 	CommandID int
 	Value     db.Value
+	Err       error
 }
 
 // Query can be used as a special request that directly read the value of key without go through replication protocol in Replica
 type Query struct {
-	C chan QueryReply
+	C chan QueryReply `json:"-"`
 }
 
 func (r *Query) Reply(reply QueryReply) {
@@ -0,0 +1,155 @@
+package narwhal
+
+import (
+	"fmt"
+
+	"github.com/gitferry/bamboo/blockchain"
+	"github.com/gitferry/bamboo/crypto"
+	"github.com/gitferry/bamboo/identity"
+	"github.com/gitferry/bamboo/log"
+	"github.com/gitferry/bamboo/message"
+	"github.com/gitferry/bamboo/types"
+)
+
+// Batch is a batch of transactions disseminated by its author
+type Batch struct {
+	ID     crypto.Identifier
+	Author identity.NodeID
+	Txns   []*message.Transaction
+}
+
+// Header is a vertex of the DAG proposed by its author in a round,
+// it refers to the batches of the author and to 2f+1 certificates of the previous round
+type Header struct {
+	Round   types.View
+	Author  identity.NodeID
+	Batches []crypto.Identifier
+	Parents []crypto.Identifier
+	ID      crypto.Identifier
+	Sig     crypto.Signature
+}
+
+// Vote tells the author of a header that the voter stores its batches
+type Vote struct {
+	Round    types.View
+	Voter    identity.NodeID
+	HeaderID crypto.Identifier
+	crypto.Signature
+}
+
+// Certificate is a header together with a quorum of votes,
+// it proves that the batches of the header are available
+type Certificate struct {
+	Header *Header
+	QC     *blockchain.QC
+}
+
+// Request asks a peer for the missing certificates and batches
+type Request struct {
+	Requester    identity.NodeID
+	Certificates []crypto.Identifier
+	Batches      []crypto.Identifier
+}
+
+// Response carries the certificates and batches asked by a request
+type Response struct {
+	Responder    identity.NodeID
+	Certificates []*Certificate
+	Batches      []*Batch
+}
+
+// MakeBatch creates a batch of the transactions
+func MakeBatch(author identity.NodeID, txns []*message.Transaction) *Batch {
+	b := &Batch{
+		Author: author,
+		Txns:   txns,
+	}
+	b.ID = b.computeID()
+	return b
+}
+
+func (b *Batch) computeID() crypto.Identifier {
+	ids := make([]string, 0, len(b.Txns))
+	for _, txn := range b.Txns {
+		ids = append(ids, txn.ID)
+	}
+	return crypto.MakeID(struct {
+		Author identity.NodeID
+		Txns   []string
+	}{b.Author, ids})
+}
+
+// MakeHeader creates a header signed by the author
+func MakeHeader(round types.View, author identity.NodeID, batches []crypto.Identifier, parents []crypto.Identifier) *Header {
+	h := &Header{
+		Round:   round,
+		Author:  author,
+		Batches: batches,
+		Parents: parents,
+	}
+	h.ID = h.computeID()
+	sig, err := crypto.PrivSign(crypto.IDToByte(h.ID), author, nil)
+	if err != nil {
+		log.Fatalf("[%v] has an error when signing a header", author)
+		return nil
+	}
+	h.Sig = sig
+	return h
+}
+
+func (h *Header) computeID() crypto.Identifier {
+	return crypto.MakeID(struct {
+		Round   types.View
+		Author  identity.NodeID
+		Batches []crypto.Identifier
+		Parents []crypto.Identifier
+	}{h.Round, h.Author, h.Batches, h.Parents})
+}
+
+// Verify checks the id and the signature of the header
+func (h *Header) Verify() bool {
+	if h.computeID() != h.ID {
+		return false
+	}
+	ok, err := crypto.PubVerify(h.Sig, crypto.IDToByte(h.ID), h.Author)
+	return err == nil && ok
+}
+
+// MakeVote creates a vote for the header signed by the voter
+func MakeVote(round types.View, voter identity.NodeID, id crypto.Identifier) *Vote {
+	sig, err := crypto.PrivSign(crypto.IDToByte(id), voter, nil)
+	if err != nil {
+		log.Fatalf("[%v] has an error when signing a header vote", voter)
+		return nil
+	}
+	return &Vote{
+		Round:     round,
+		Voter:     voter,
+		HeaderID:  id,
+		Signature: sig,
+	}
+}
+
+// Verify checks that the certificate is signed by a super majority of n nodes
+func (c *Certificate) Verify(n int) error {
+	if c.Header == nil || c.QC == nil {
+		return fmt.Errorf("the certificate is incomplete")
+	}
+	if !c.Header.Verify() {
+		return fmt.Errorf("the header of the certificate is invalid, id: %x", c.Header.ID)
+	}
+	if c.QC.BlockID != c.Header.ID {
+		return fmt.Errorf("the quorum does not certify the header, id: %x", c.Header.ID)
+	}
+	if c.QC.Signers.Count()*3 <= n*2 {
+		return fmt.Errorf("the certificate has %v signers out of %v nodes", c.QC.Signers.Count(), n)
+	}
+	ok, err := crypto.VerifyQuorumSignature(c.QC.AggSig, c.QC.BlockID, c.QC.Signers)
+	if err != nil {
+		return fmt.Errorf("cannot verify the certificate: %w", err)
+	}
+	if !ok {
+		return fmt.Errorf("the certificate has invalid signatures, id: %x", c.Header.ID)
+	}
+	return nil
+}
@@ -0,0 +1,547 @@
+package narwhal
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gitferry/bamboo/blockchain"
+	"github.com/gitferry/bamboo/config"
+	"github.com/gitferry/bamboo/crypto"
+	"github.com/gitferry/bamboo/identity"
+	"github.com/gitferry/bamboo/log"
+	"github.com/gitferry/bamboo/message"
+	"github.com/gitferry/bamboo/node"
+	"github.com/gitferry/bamboo/transport"
+	"github.com/gitferry/bamboo/types"
+)
+
+// NARWHAL is the name of the DAG mempool in the configuration
+const NARWHAL = "narwhal"
+
+// gcDepth is the number of rounds below the last committed round a delivered certificate is kept
+// so that the headers of slow authors can still refer to it
+const gcDepth = 50
+
+// Mempool is a Narwhal-style DAG mempool decoupled from consensus.
+// Replicas disseminate batches of transactions to each other and propose one header per round
+// referring to their batches and to 2f+1 certificates of the previous round.
+// A header is certified once 2f+1 replicas vote that they store its batches.
+// Blocks only carry digests of certificates, committing a certificate delivers
+// the batches of its causal history in a deterministic order.
+type Mempool struct {
+	node.Node
+	n            int
+	pending      []*message.Transaction
+	ready        []crypto.Identifier // own batches which are not in a header yet
+	batches      map[crypto.Identifier]*Batch
+	round        types.View // the round of the next own header
+	committed    types.View // the highest round of the certificates committed by consensus
+	header       *Header    // own header waiting for votes
+	quorum       *blockchain.Quorum
+	voted        map[types.View]map[identity.NodeID]crypto.Identifier
+	certs        map[crypto.Identifier]*Certificate
+	rounds       map[types.View]map[identity.NodeID]crypto.Identifier // the DAG
+	buffered     map[crypto.Identifier]*Header                        // headers waiting for their batches or parents
+	delivered    map[crypto.Identifier]types.View
+	collected    types.View // the rounds below are delivered or dropped, the parents in them count as delivered
+	synchronizer *blockchain.Synchronizer
+	updated      *sync.Cond // signalled when a certificate or a batch arrives
+	mu           sync.Mutex
+}
+
+// NewMempool creates a DAG mempool on top of the node and starts sealing batches and headers
+func NewMempool(n node.Node) *Mempool {
+	mp := new(Mempool)
+	mp.Node = n
+	mp.n = config.GetConfig().N()
+	mp.batches = make(map[crypto.Identifier]*Batch)
+	mp.round = 1
+	mp.quorum = blockchain.NewQuorum(mp.n)
+	mp.voted = make(map[types.View]map[identity.NodeID]crypto.Identifier)
+	mp.certs = make(map[crypto.Identifier]*Certificate)
+	mp.rounds = make(map[types.View]map[identity.NodeID]crypto.Identifier)
+	mp.buffered = make(map[crypto.Identifier]*Header)
+	mp.delivered = make(map[crypto.Identifier]types.View)
+	mp.synchronizer = blockchain.NewSynchronizer(config.GetTimer())
+	mp.updated = sync.NewCond(&mp.mu)
+	mp.Register(Batch{}, mp.handleBatch)
+	mp.Register(Header{}, mp.handleHeader)
+	mp.Register(Vote{}, mp.handleVote)
+	mp.Register(Certificate{}, mp.handleCertificate)
+	mp.Register(Request{}, mp.handleRequest)
+	mp.Register(Response{}, mp.handleResponse)
+	transport.RegisterClass(Batch{}, "batch")
+	transport.Register(Header{})
+	transport.Register(Vote{})
+	transport.Register(Certificate{})
+	transport.RegisterClass(Request{}, "batch")
+	transport.RegisterClass(Response{}, "batch")
+	go mp.run()
+	return mp
+}
+
+// AddTxn adds a transaction to the batch being filled
+func (mp *Mempool) AddTxn(txn *message.Transaction) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	txn.Timestamp = time.Now()
+	mp.pending = append(mp.pending, txn)
+	if len(mp.pending) >= config.GetConfig().BatchSize {
+		mp.sealBatch()
+	}
+}
+
+// Digests returns the certificates which are not delivered and not in the causal history
+// of another such certificate, committing them delivers every certified batch
+func (mp *Mempool) Digests() []crypto.Identifier {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	referred := make(map[crypto.Identifier]struct{})
+	for _, c := range mp.certs {
+		for _, parent := range c.Header.Parents {
+			referred[parent] = struct{}{}
+		}
+	}
+	var tips []*Certificate
+	for id, c := range mp.certs {
+		if _, ok := mp.delivered[id]; ok {
+			continue
+		}
+		if _, ok := referred[id]; ok {
+			continue
+		}
+		tips = append(tips, c)
+	}
+	sortCertificates(tips)
+	digests := make([]crypto.Identifier, 0, len(tips))
+	for _, c := range tips {
+		digests = append(digests, c.Header.ID)
+	}
+	return digests
+}
+
+// Resolve delivers the transactions of the causal histories of the certificates in a committed block.
+// Missing certificates and batches are fetched from the peers, Resolve waits for them up to the view timeout
+// and returns an error without delivering anything if they do not arrive in time.
+func (mp *Mempool) Resolve(digests []crypto.Identifier) ([]*message.Transaction, error) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	if !mp.waitAvailable(digests, config.GetTimer()) {
+		return nil, fmt.Errorf("the causal history of %v certificates is not available", len(digests))
+	}
+	var txns []*message.Transaction
+	for _, digest := range digests {
+		for _, c := range mp.history(digest) {
+			for _, id := range c.Header.Batches {
+				txns = append(txns, mp.batches[id].Txns...)
+			}
+			if c.Header.Round > mp.committed {
+				mp.committed = c.Header.Round
+			}
+		}
+	}
+	mp.collectGarbage()
+	return txns, nil
+}
+
+// WaitAvailable waits until the certificates of the digests are stored along with their causal histories
+// and batches, the missing ones are fetched from the peers. It returns false if they do not arrive in time.
+func (mp *Mempool) WaitAvailable(digests []crypto.Identifier, timeout time.Duration) bool {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	return mp.waitAvailable(digests, timeout)
+}
+
+// waitAvailable waits until the digests are available or the timeout expires,
+// the waiting is woken up by the arrivals and at the deadline. The caller must hold the lock.
+func (mp *Mempool) waitAvailable(digests []crypto.Identifier, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	timer := time.AfterFunc(timeout, func() {
+		mp.mu.Lock()
+		defer mp.mu.Unlock()
+		mp.updated.Broadcast()
+	})
+	defer timer.Stop()
+	for !mp.available(digests) {
+		if time.Now().After(deadline) {
+			return false
+		}
+		mp.updated.Wait()
+	}
+	return true
+}
+
+// available checks that the certificates in the causal histories of the digests that are not delivered
+// are stored with their batches and requests the missing ones, the caller must hold the lock
+func (mp *Mempool) available(digests []crypto.Identifier) bool {
+	complete := true
+	var req Request
+	visited := make(map[crypto.Identifier]struct{})
+	stack := append([]crypto.Identifier(nil), digests...)
+	for len(stack) > 0 {
+		id := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if _, ok := visited[id]; ok {
+			continue
+		}
+		visited[id] = struct{}{}
+		if _, ok := mp.delivered[id]; ok {
+			continue
+		}
+		c, ok := mp.certs[id]
+		if !ok {
+			complete = false
+			if mp.synchronizer.ShouldRequest(id) {
+				req.Certificates = append(req.Certificates, id)
+			}
+			continue
+		}
+		for _, b := range c.Header.Batches {
+			if _, ok := mp.batches[b]; !ok {
+				complete = false
+				if mp.synchronizer.ShouldRequest(b) {
+					req.Batches = append(req.Batches, b)
+				}
+			}
+		}
+		if c.Header.Round > mp.collected {
+			stack = append(stack, c.Header.Parents...)
+		}
+	}
+	if len(req.Certificates) > 0 || len(req.Batches) > 0 {
+		req.Requester = mp.ID()
+		log.Debugf("[%v] requests the missing certificates %v and batches %v", mp.ID(), len(req.Certificates), len(req.Batches))
+		mp.Broadcast(req)
+	}
+	return complete
+}
+
+// history returns the certificates in the causal history of the digest that are not delivered,
+// ordered by round and author, and marks them delivered. The history must be available
+// and the caller must hold the lock.
+func (mp *Mempool) history(digest crypto.Identifier) []*Certificate {
+	var history []*Certificate
+	stack := []crypto.Identifier{digest}
+	for len(stack) > 0 {
+		id := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if _, ok := mp.delivered[id]; ok {
+			continue
+		}
+		c := mp.certs[id]
+		mp.delivered[id] = c.Header.Round
+		history = append(history, c)
+		if c.Header.Round > mp.collected {
+			stack = append(stack, c.Header.Parents...)
+		}
+	}
+	sortCertificates(history)
+	return history
+}
+
+// collectGarbage drops the delivered certificates and their batches gcDepth rounds below the last committed round,
+// the rounds above may still be needed by the certificates that are not committed yet and by the lagging replicas.
+// The collected rounds are remembered so that the certificates above still find their parents delivered.
+// The caller must hold the lock.
+func (mp *Mempool) collectGarbage() {
+	if mp.committed <= gcDepth {
+		return
+	}
+	bound := mp.committed - gcDepth
+	mp.collected = bound
+	for id, round := range mp.delivered {
+		if round < bound {
+			for _, b := range mp.certs[id].Header.Batches {
+				delete(mp.batches, b)
+			}
+			delete(mp.delivered, id)
+			delete(mp.certs, id)
+		}
+	}
+	for round := range mp.rounds {
+		if round < bound {
+			delete(mp.rounds, round)
+			delete(mp.voted, round)
+		}
+	}
+}
+
+// run seals the pending transactions and proposes a header of the round periodically
+func (mp *Mempool) run() {
+	ticker := time.NewTicker(time.Duration(config.GetConfig().BatchDelay) * time.Millisecond)
+	defer ticker.Stop()
+	for range ticker.C {
+		mp.mu.Lock()
+		mp.sealBatch()
+		mp.proposeHeader()
+		// wake up the deliveries waiting for missing data to retry the requests
+		mp.updated.Broadcast()
+		mp.mu.Unlock()
+	}
+}
+
+// sealBatch disseminates the pending transactions as a batch, the caller must hold the lock
+func (mp *Mempool) sealBatch() {
+	if len(mp.pending) == 0 {
+		return
+	}
+	batch := MakeBatch(mp.ID(), mp.pending)
+	mp.pending = nil
+	mp.batches[batch.ID] = batch
+	mp.ready = append(mp.ready, batch.ID)
+	mp.Broadcast(batch)
+}
+
+// proposeHeader creates the header of the round once 2f+1 certificates of the previous round are received,
+// the caller must hold the lock
+func (mp *Mempool) proposeHeader() {
+	if mp.header != nil && mp.header.Round == mp.round {
+		return
+	}
+	var parents []crypto.Identifier
+	if mp.round > 1 {
+		if !mp.superMajority(len(mp.rounds[mp.round-1])) {
+			return
+		}
+		for _, id := range mp.rounds[mp.round-1] {
+			parents = append(parents, id)
+		}
+		sort.Slice(parents, func(i, j int) bool {
+			return mp.certs[parents[i]].Header.Author.Node() < mp.certs[parents[j]].Header.Author.Node()
+		})
+	}
+	mp.header = MakeHeader(mp.round, mp.ID(), mp.ready, parents)
+	mp.ready = nil
+	mp.quorum = blockchain.NewQuorum(mp.n)
+	log.Debugf("[%v] proposes a header, round: %v, batches: %v, id: %x", mp.ID(), mp.round, len(mp.header.Batches), mp.header.ID)
+	mp.Broadcast(mp.header)
+	mp.processHeader(mp.header)
+}
+
+// processHeader votes for the header once its batches and parents are available,
+// an author gets at most one vote per round. The caller must hold the lock.
+func (mp *Mempool) processHeader(h *Header) {
+	// the parents may have been collected
+	if h.Round <= mp.collected {
+		return
+	}
+	if _, ok := mp.voted[h.Round][h.Author]; ok {
+		return
+	}
+	var missingCerts, missingBatches []crypto.Identifier
+	for _, id := range h.Batches {
+		if _, ok := mp.batches[id]; !ok {
+			missingBatches = append(missingBatches, id)
+		}
+	}
+	for _, id := range h.Parents {
+		if _, ok := mp.certs[id]; !ok {
+			missingCerts = append(missingCerts, id)
+		}
+	}
+	if len(missingCerts) > 0 || len(missingBatches) > 0 {
+		mp.buffered[h.ID] = h
+		if mp.synchronizer.ShouldRequest(h.ID) {
+			mp.Send(h.Author, Request{Requester: mp.ID(), Certificates: missingCerts, Batches: missingBatches})
+		}
+		return
+	}
+	delete(mp.buffered, h.ID)
+	if h.Round > 1 && !mp.validParents(h) {
+		log.Warningf("[%v] received a header with invalid parents from %v, round: %v", mp.ID(), h.Author, h.Round)
+		return
+	}
+	if mp.voted[h.Round] == nil {
+		mp.voted[h.Round] = make(map[identity.NodeID]crypto.Identifier)
+	}
+	mp.voted[h.Round][h.Author] = h.ID
+	vote := MakeVote(h.Round, mp.ID(), h.ID)
+	if h.Author == mp.ID() {
+		mp.processVote(vote)
+	} else {
+		mp.Send(h.Author, vote)
+	}
+}
+
+// validParents checks that the header refers to 2f+1 certificates of the previous round
+func (mp *Mempool) validParents(h *Header) bool {
+	authors := make(map[identity.NodeID]struct{})
+	for _, id := range h.Parents {
+		c := mp.certs[id]
+		if c.Header.Round != h.Round-1 {
+			return false
+		}
+		authors[c.Header.Author] = struct{}{}
+	}
+	return mp.superMajority(len(authors))
+}
+
+// processVote certifies the own header with a quorum of votes, the caller must hold the lock
+func (mp *Mempool) processVote(vote *Vote) {
+	if mp.header == nil || vote.HeaderID != mp.header.ID {
+		return
+	}
+	isBuilt, qc := mp.quorum.Add(&blockchain.Vote{
+		View:      vote.Round,
+		Voter:     vote.Voter,
+		BlockID:   vote.HeaderID,
+		Signature: vote.Signature,
+	})
+	if !isBuilt {
+		return
+	}
+	qc.Leader = mp.ID()
+	c := &Certificate{Header: mp.header, QC: qc}
+	log.Debugf("[%v] the header is certified, round: %v, id: %x", mp.ID(), c.Header.Round, c.Header.ID)
+	mp.Broadcast(c)
+	mp.addCertificate(c)
+}
+
+// addCertificate adds the certificate to the DAG and moves to the next round
+// once 2f+1 certificates of the round are received. The caller must hold the lock.
+func (mp *Mempool) addCertificate(c *Certificate) {
+	id := c.Header.ID
+	if _, ok := mp.certs[id]; ok {
+		return
+	}
+	if _, ok := mp.delivered[id]; ok {
+		return
+	}
+	round := c.Header.Round
+	if round < mp.collected {
+		return
+	}
+	mp.certs[id] = c
+	if mp.rounds[round] == nil {
+		mp.rounds[round] = make(map[identity.NodeID]crypto.Identifier)
+	}
+	mp.rounds[round][c.Header.Author] = id
+	for mp.superMajority(len(mp.rounds[mp.round])) {
+		mp.round++
+	}
+	mp.synchronizer.Done(id)
+	mp.updated.Broadcast()
+	mp.processBuffered()
+}
+
+// processBuffered retries the headers waiting for batches or parents, the caller must hold the lock
+func (mp *Mempool) processBuffered() {
+	for _, h := range mp.buffered {
+		mp.processHeader(h)
+	}
+}
+
+func (mp *Mempool) superMajority(count int) bool {
+	return count > mp.n*2/3
+}
+
+/* Message Handlers */
+
+func (mp *Mempool) handleBatch(b Batch) {
+	if b.computeID() != b.ID {
+		log.Warningf("[%v] received a batch not matching its id from %v", mp.ID(), b.Author)
+		return
+	}
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	mp.addBatch(&b)
+}
+
+// addBatch stores the batch unless it is stored already, the caller must hold the lock
+func (mp *Mempool) addBatch(b *Batch) {
+	if _, ok := mp.batches[b.ID]; ok {
+		return
+	}
+	mp.batches[b.ID] = b
+	mp.synchronizer.Done(b.ID)
+	mp.updated.Broadcast()
+	mp.processBuffered()
+}
+
+func (mp *Mempool) handleHeader(h Header) {
+	if !h.Verify() {
+		log.Warningf("[%v] received a header with an invalid signature from %v", mp.ID(), h.Author)
+		return
+	}
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	mp.processHeader(&h)
+}
+
+func (mp *Mempool) handleVote(v Vote) {
+	ok, err := crypto.PubVerify(v.Signature, crypto.IDToByte(v.HeaderID), v.Voter)
+	if err != nil || !ok {
+		log.Warningf("[%v] received a header vote with an invalid signature from %v", mp.ID(), v.Voter)
+		return
+	}
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	mp.processVote(&v)
+}
+
+func (mp *Mempool) handleCertificate(c Certificate) {
+	err := c.Verify(mp.n)
+	if err != nil {
+		log.Warningf("[%v] received an invalid certificate: %v", mp.ID(), err)
+		return
+	}
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	mp.addCertificate(&c)
+}
+
+func (mp *Mempool) handleRequest(req Request) {
+	mp.mu.Lock()
+	resp := Response{Responder: mp.ID()}
+	for _, id := range req.Certificates {
+		if c, ok := mp.certs[id]; ok {
+			resp.Certificates = append(resp.Certificates, c)
+		}
+	}
+	for _, id := range req.Batches {
+		if b, ok := mp.batches[id]; ok {
+			resp.Batches = append(resp.Batches, b)
+		}
+	}
+	mp.mu.Unlock()
+	if len(resp.Certificates) == 0 && len(resp.Batches) == 0 {
+		return
+	}
+	mp.Send(req.Requester, resp)
+}
+
+func (mp *Mempool) handleResponse(resp Response) {
+	for _, b := range resp.Batches {
+		if b.computeID() != b.ID {
+			log.Warningf("[%v] received a batch not matching its id from %v", mp.ID(), resp.Responder)
+			return
+		}
+	}
+	for _, c := range resp.Certificates {
+		err := c.Verify(mp.n)
+		if err != nil {
+			log.Warningf("[%v] received an invalid certificate from %v: %v", mp.ID(), resp.Responder, err)
+			return
+		}
+	}
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	for _, b := range resp.Batches {
+		mp.addBatch(b)
+	}
+	for _, c := range resp.Certificates {
+		mp.addCertificate(c)
+	}
+}
+
+// sortCertificates orders the certificates by round and then by author
+func sortCertificates(certs []*Certificate) {
+	sort.Slice(certs, func(i, j int) bool {
+		if certs[i].Header.Round != certs[j].Header.Round {
+			return certs[i].Header.Round < certs[j].Header.Round
+		}
+		return certs[i].Header.Author.Node() < certs[j].Header.Author.Node()
+	})
+}
@@ -0,0 +1,179 @@
+package narwhal
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/gitferry/bamboo/blockchain"
+	"github.com/gitferry/bamboo/config"
+	"github.com/gitferry/bamboo/crypto"
+	"github.com/gitferry/bamboo/identity"
+	"github.com/gitferry/bamboo/message"
+	"github.com/gitferry/bamboo/types"
+)
+
+func newTestMempool() *Mempool {
+	config.Configuration.Signer = crypto.BLS_BLS12381
+	crypto.SetKeysWith(4, crypto.BLS_BLS12381)
+	mp := &Mempool{
+		n:         4,
+		round:     1,
+		batches:   make(map[crypto.Identifier]*Batch),
+		voted:     make(map[types.View]map[identity.NodeID]crypto.Identifier),
+		certs:     make(map[crypto.Identifier]*Certificate),
+		rounds:    make(map[types.View]map[identity.NodeID]crypto.Identifier),
+		buffered:  make(map[crypto.Identifier]*Header),
+		delivered: make(map[crypto.Identifier]types.View),
+	}
+	mp.synchronizer = blockchain.NewSynchronizer(time.Second)
+	mp.updated = sync.NewCond(&mp.mu)
+	return mp
+}
+
+// certify creates a certified header of the author with one batch of a transaction
+func (mp *Mempool) certify(round types.View, author int, parents ...*Certificate) *Certificate {
+	id := identity.NewNodeID(author)
+	batch := MakeBatch(id, []*message.Transaction{{ID: fmt.Sprintf("%v-%v", round, author)}})
+	mp.batches[batch.ID] = batch
+	var parentIDs []crypto.Identifier
+	for _, p := range parents {
+		parentIDs = append(parentIDs, p.Header.ID)
+	}
+	header := MakeHeader(round, id, []crypto.Identifier{batch.ID}, parentIDs)
+	quorum := blockchain.NewQuorum(4)
+	var qc *blockchain.QC
+	for i := 1; i <= 3; i++ {
+		v := MakeVote(round, identity.NewNodeID(i), header.ID)
+		_, qc = quorum.Add(&blockchain.Vote{View: v.Round, Voter: v.Voter, BlockID: v.HeaderID, Signature: v.Signature})
+	}
+	c := &Certificate{Header: header, QC: qc}
+	mp.addCertificate(c)
+	return c
+}
+
+func txnIDs(txns []*message.Transaction) []string {
+	var ids []string
+	for _, txn := range txns {
+		ids = append(ids, txn.ID)
+	}
+	return ids
+}
+
+func TestCertificate_Verify(t *testing.T) {
+	mp := newTestMempool()
+	c := mp.certify(1, 1)
+	require.NoError(t, c.Verify(4))
+	c.Header.Batches = nil
+	require.Error(t, c.Verify(4))
+}
+
+// the dag moves to the next round with 2f+1 certificates
+func TestMempool_Round(t *testing.T) {
+	mp := newTestMempool()
+	mp.certify(1, 1)
+	mp.certify(1, 2)
+	require.Equal(t, types.View(1), mp.round)
+	mp.certify(1, 3)
+	require.Equal(t, types.View(2), mp.round)
+}
+
+// committing a certificate delivers its causal history ordered by round and author, only once
+func TestMempool_Resolve(t *testing.T) {
+	mp := newTestMempool()
+	a := mp.certify(1, 1)
+	b := mp.certify(1, 2)
+	c := mp.certify(1, 3)
+	d := mp.certify(1, 4)
+	e := mp.certify(2, 2, a, b, c)
+
+	digests := mp.Digests()
+	require.Equal(t, []crypto.Identifier{d.Header.ID, e.Header.ID}, digests)
+
+	txns, err := mp.Resolve([]crypto.Identifier{e.Header.ID})
+	require.NoError(t, err)
+	require.Equal(t, []string{"1-1", "1-2", "1-3", "2-2"}, txnIDs(txns))
+	txns, err = mp.Resolve(digests)
+	require.NoError(t, err)
+	require.Equal(t, []string{"1-4"}, txnIDs(txns))
+	require.Empty(t, mp.Digests())
+	require.Equal(t, types.View(2), mp.committed)
+}
+
+// a certificate whose causal history is missing is not delivered and does not block the caller
+func TestMempool_ResolveMissing(t *testing.T) {
+	mp := newTestMempool()
+	other := newTestMempool()
+	a := other.certify(1, 1)
+	b := other.certify(1, 2)
+	c := other.certify(1, 3)
+	d := other.certify(2, 2, a, b, c)
+	for _, id := range []crypto.Identifier{a.Header.ID, b.Header.ID, c.Header.ID, d.Header.ID} {
+		// the certificates count as requested so that the test needs no peer
+		mp.synchronizer.ShouldRequest(id)
+	}
+	mp.addCertificate(d)
+	for _, id := range d.Header.Batches {
+		mp.batches[id] = other.batches[id]
+	}
+	require.False(t, mp.WaitAvailable([]crypto.Identifier{d.Header.ID}, 10*time.Millisecond))
+	_, err := mp.Resolve([]crypto.Identifier{d.Header.ID})
+	require.Error(t, err)
+	require.Empty(t, mp.delivered)
+
+	for _, parent := range []*Certificate{a, b, c} {
+		mp.addCertificate(parent)
+		for _, id := range parent.Header.Batches {
+			mp.batches[id] = other.batches[id]
+		}
+	}
+	require.True(t, mp.WaitAvailable([]crypto.Identifier{d.Header.ID}, 10*time.Millisecond))
+	txns, err := mp.Resolve([]crypto.Identifier{d.Header.ID})
+	require.NoError(t, err)
+	require.Equal(t, []string{"1-1", "1-2", "1-3", "2-2"}, txnIDs(txns))
+}
+
+// the delivered certificates and batches are collected below the last committed round only
+func TestMempool_CollectGarbage(t *testing.T) {
+	mp := newTestMempool()
+	a := mp.certify(1, 1)
+	_, err := mp.Resolve([]crypto.Identifier{a.Header.ID})
+	require.NoError(t, err)
+	// the batches are still served to the lagging replicas
+	require.Contains(t, mp.batches, a.Header.Batches[0])
+	// the dag is far ahead of the last committed round
+	mp.round = 10 * gcDepth
+	mp.collectGarbage()
+	require.Contains(t, mp.certs, a.Header.ID)
+
+	mp.committed = gcDepth + 2
+	mp.collectGarbage()
+	require.NotContains(t, mp.certs, a.Header.ID)
+	require.NotContains(t, mp.batches, a.Header.Batches[0])
+	// a collected certificate fetched again is not delivered twice
+	mp.addCertificate(a)
+	require.NotContains(t, mp.certs, a.Header.ID)
+}
+
+// a certificate committed after its parents are collected still finds them delivered
+func TestMempool_ResolveAfterCollect(t *testing.T) {
+	mp := newTestMempool()
+	a := mp.certify(1, 1)
+	b := mp.certify(1, 2)
+	c := mp.certify(1, 3)
+	// the certificate of a slow author is not committed with its parents
+	d := mp.certify(2, 4, a, b, c)
+	_, err := mp.Resolve([]crypto.Identifier{a.Header.ID, b.Header.ID, c.Header.ID})
+	require.NoError(t, err)
+	mp.committed = gcDepth + 2
+	mp.collectGarbage()
+	require.NotContains(t, mp.certs, a.Header.ID)
+
+	require.True(t, mp.WaitAvailable([]crypto.Identifier{d.Header.ID}, 10*time.Millisecond))
+	txns, err := mp.Resolve([]crypto.Identifier{d.Header.ID})
+	require.NoError(t, err)
+	require.Equal(t, []string{"2-4"}, txnIDs(txns))
+}
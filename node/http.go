@@ -1,14 +1,22 @@
 package node
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/gitferry/bamboo/config"
+	"github.com/gitferry/bamboo/db"
+	"github.com/gitferry/bamboo/identity"
 	"github.com/gitferry/bamboo/log"
+	"github.com/gitferry/bamboo/mempool"
 	"github.com/gitferry/bamboo/message"
 	"io"
 	"io/ioutil"
 	"math/rand"
 	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
@@ -17,6 +25,7 @@ import (
 const (
 	HTTPClientID  = "Id"
 	HTTPCommandID = "Cid"
+	HTTPPriority  = "Priority" // fee or priority of the transaction for the priority mempool policy
 )
 
 var ppFree = sync.Pool{
@@ -30,9 +39,14 @@ func (n *node) http() {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", n.handleRoot)
 	mux.HandleFunc("/query", n.handleQuery)
+	mux.HandleFunc("/history", n.handleHistory)
 	mux.HandleFunc("/slow", n.handleSlow)
 	mux.HandleFunc("/flaky", n.handleFlaky)
 	mux.HandleFunc("/crash", n.handleCrash)
+	mux.HandleFunc("/drop", n.handleDrop)
+	mux.HandleFunc("/partition", n.handlePartition)
+	mux.HandleFunc("/heal", n.handleHeal)
+	mux.HandleFunc("/connections", n.handleConnections)
 
 	// http string should be in form of ":8080"
 	ip, err := url.Parse(config.Configuration.HTTPAddrs[n.id])
@@ -60,38 +74,169 @@ func (n *node) handleQuery(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// handleHistory replies the history of values of the key in json
+func (n *node) handleHistory(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	k, err := strconv.Atoi(r.URL.Query().Get("key"))
+	if err != nil {
+		log.Error(err)
+		http.Error(w, "invalid key", http.StatusBadRequest)
+		return
+	}
+	b, err := json.Marshal(n.History(db.Key(k)))
+	if err != nil {
+		log.Error(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	_, err = w.Write(b)
+	if err != nil {
+		log.Error(err)
+	}
+}
+
+// handleRead serves a read of the key, the replica decides how the read is ordered
+func (n *node) handleRead(w http.ResponseWriter, r *http.Request, key db.Key) {
+	var read message.Read
+	read.Key = key
+	read.ClientID = identity.NodeID(r.Header.Get(HTTPClientID))
+	read.CommandID, _ = strconv.Atoi(r.Header.Get(HTTPCommandID))
+	read.C = make(chan message.ReadReply, 1)
+	n.TxChan <- read
+	select {
+	case reply := <-read.C:
+		if reply.Err != nil {
+			http.Error(w, reply.Err.Error(), errorStatus(reply.Err))
+			return
+		}
+		_, err := w.Write(reply.Value)
+		if err != nil {
+			log.Error(err)
+		}
+	case <-r.Context().Done():
+		log.Debugf("[%v] the read of key %v is cancelled by the client", n.id, key)
+	}
+}
+
+// errorStatus returns the http status of a failed transaction, the ones rejected by the memory pool are told apart
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, mempool.ErrDuplicate):
+		return http.StatusConflict
+	case errors.Is(err, mempool.ErrQuota):
+		return http.StatusTooManyRequests
+	case errors.Is(err, mempool.ErrFull), errors.Is(err, mempool.ErrExpired):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
 func (n *node) handleRoot(w http.ResponseWriter, r *http.Request) {
 	var req message.Transaction
 	defer r.Body.Close()
 
+	// the url is in the form of http://ip:port/key
+	key, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/"))
+	if err == nil {
+		req.Command.Key = db.Key(key)
+	}
+	if r.Method == http.MethodGet {
+		n.handleRead(w, r, req.Command.Key)
+		return
+	}
+
 	v, _ := ioutil.ReadAll(r.Body)
 	//log.Debugf("[%v] payload is %x", n.id, v)
 	req.Command.Value = v
+	req.Command.ClientID = identity.NodeID(r.Header.Get(HTTPClientID))
+	req.Command.CommandID, _ = strconv.Atoi(r.Header.Get(HTTPCommandID))
+	if p := r.Header.Get(HTTPPriority); p != "" {
+		req.Properties = map[string]string{mempool.PriorityProperty: p}
+	}
 	req.C = ppFree.Get().(chan message.TransactionReply)
 	req.NodeID = n.id
 	req.Timestamp = time.Now()
-	req.ID = r.RequestURI
+	// the id identifies the transaction across replicas for deduplication
+	if req.Command.ClientID != "" {
+		req.ID = fmt.Sprintf("%v-%v", req.Command.ClientID, req.Command.CommandID)
+	} else {
+		req.ID = fmt.Sprintf("%v-%v", n.id, req.Timestamp.UnixNano())
+	}
 	n.TxChan <- req
 
-	//reply := <-req.C
-	//
-	//log.Debugf("[%v] tx %v delay is %v", n.id, req.ID, strconv.Itoa(int(reply.Delay.Nanoseconds())))
-
-	//if reply.Err != nil {
-	//	http.Error(w, reply.Err.Error(), http.StatusInternalServerError)
-	//	return
-	//}
-	//w.Header().Set(HTTPCommandID, strconv.Itoa(int(reply.Delay.Nanoseconds())))
-	//_, err := io.WriteString(w, string(reply.Delay.Nanoseconds()))
-	//if err != nil {
-	//	log.Error(err)
-	//}
+	// long-poll until the block containing the transaction is committed
+	select {
+	case reply := <-req.C:
+		ppFree.Put(req.C)
+		log.Debugf("[%v] tx %v delay is %v", n.id, req.ID, reply.Delay)
+		if reply.Err != nil {
+			http.Error(w, reply.Err.Error(), errorStatus(reply.Err))
+			return
+		}
+		w.Header().Set(HTTPCommandID, strconv.Itoa(req.Command.CommandID))
+		_, err := w.Write(reply.Value)
+		if err != nil {
+			log.Error(err)
+		}
+	case <-r.Context().Done():
+		// the channel is not reused since the reply may still arrive
+		log.Debugf("[%v] tx %v is cancelled by the client", n.id, req.ID)
+	}
 }
 
 func (n *node) handleCrash(w http.ResponseWriter, r *http.Request) {
 	n.Socket.Crash(config.GetConfig().Crash)
 }
 
+func (n *node) handleDrop(w http.ResponseWriter, r *http.Request) {
+	id := identity.NodeID(r.URL.Query().Get("id"))
+	t, err := strconv.Atoi(r.URL.Query().Get("t"))
+	if err != nil {
+		log.Error(err)
+		http.Error(w, "invalid time", http.StatusBadRequest)
+		return
+	}
+	n.Socket.Drop(id, t)
+}
+
+// handlePartition partitions the node from the other groups, e.g., /partition?t=10&group=1,2&group=3,4,
+// the partition is symmetric unless symmetric=false
+func (n *node) handlePartition(w http.ResponseWriter, r *http.Request) {
+	t, err := strconv.Atoi(r.URL.Query().Get("t"))
+	if err != nil {
+		log.Error(err)
+		http.Error(w, "invalid time", http.StatusBadRequest)
+		return
+	}
+	symmetric := r.URL.Query().Get("symmetric") != "false"
+	var groups [][]identity.NodeID
+	for _, g := range r.URL.Query()["group"] {
+		var group []identity.NodeID
+		for _, id := range strings.Split(g, ",") {
+			if id != "" {
+				group = append(group, identity.NodeID(id))
+			}
+		}
+		groups = append(groups, group)
+	}
+	n.Socket.Partition(t, symmetric, groups...)
+}
+
+func (n *node) handleHeal(w http.ResponseWriter, r *http.Request) {
+	n.Socket.Heal()
+}
+
+// handleConnections replies the state of the connections to the peers in json
+func (n *node) handleConnections(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(n.Socket.Connections())
+	if err != nil {
+		log.Error(err)
+	}
+}
+
 func (n *node) handleSlow(w http.ResponseWriter, r *http.Request) {
 	//t, err := strconv.Atoi(r.URL.Query().Get("t"))
 	//if err != nil {
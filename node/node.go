@@ -6,6 +6,7 @@ import (
 	"sync"
 
 	"github.com/gitferry/bamboo/config"
+	"github.com/gitferry/bamboo/db"
 	"github.com/gitferry/bamboo/identity"
 	"github.com/gitferry/bamboo/log"
 	"github.com/gitferry/bamboo/message"
@@ -16,12 +17,13 @@ import (
 // it includes networking, state machine and RESTful API server
 type Node interface {
 	socket.Socket
-	//Database
+	db.StateMachine
 	ID() identity.NodeID
 	Run()
 	Retry(r message.Transaction)
 	Forward(id identity.NodeID, r message.Transaction)
 	Register(m interface{}, f interface{})
+	Deliver(m interface{})
 	IsByz() bool
 }
 
@@ -30,7 +32,7 @@ type node struct {
 	id identity.NodeID
 
 	socket.Socket
-	//Database
+	db.StateMachine
 	MessageChan chan interface{}
 	TxChan      chan interface{}
 	handles     map[string]reflect.Value
@@ -44,15 +46,20 @@ type node struct {
 
 // NewNode creates a new Node object from configuration
 func NewNode(id identity.NodeID, isByz bool) Node {
+	return NewNodeWithSocket(id, isByz, socket.NewSocket(id, config.Configuration.Addrs))
+}
+
+// NewNodeWithSocket creates a new Node object communicating through the given socket
+func NewNodeWithSocket(id identity.NodeID, isByz bool, s socket.Socket) Node {
 	return &node{
-		id:     id,
-		isByz:  isByz,
-		Socket: socket.NewSocket(id, config.Configuration.Addrs),
-		//Database:    NewDatabase(),
-		MessageChan: make(chan interface{}, config.Configuration.ChanBufferSize),
-		TxChan:      make(chan interface{}, config.Configuration.ChanBufferSize),
-		handles:     make(map[string]reflect.Value),
-		forwards:    make(map[string]*message.Transaction),
+		id:           id,
+		isByz:        isByz,
+		Socket:       s,
+		StateMachine: db.NewStateMachine(),
+		MessageChan:  make(chan interface{}, config.Configuration.ChanBufferSize),
+		TxChan:       make(chan interface{}, config.Configuration.ChanBufferSize),
+		handles:      make(map[string]reflect.Value),
+		forwards:     make(map[string]*message.Transaction),
 	}
 }
 
@@ -101,22 +108,15 @@ func (n *node) Run() {
 
 func (n *node) txn() {
 	for {
-		tx := <-n.TxChan
-		v := reflect.ValueOf(tx)
-		name := v.Type().String()
-		f, exists := n.handles[name]
-		if !exists {
-			log.Fatalf("no registered handle function for message type %v", name)
-		}
-		f.Call([]reflect.Value{v})
+		n.call(<-n.TxChan)
 	}
 }
 
-//recv receives messages from socket and pass to message channel
+// recv receives messages from socket and pass to message channel
 func (n *node) recv() {
 	for {
 		m := n.Recv()
-		if n.isByz && config.GetConfig().Strategy == "silence" {
+		if n.isByz && config.GetConfig().HasStrategy(n.id, "silence") {
 			// perform silence attack
 			continue
 		}
@@ -141,15 +141,32 @@ func (n *node) recv() {
 // handle receives messages from message channel and calls handle function using refection
 func (n *node) handle() {
 	for {
-		msg := <-n.MessageChan
-		v := reflect.ValueOf(msg)
-		name := v.Type().String()
-		f, exists := n.handles[name]
-		if !exists {
-			log.Fatalf("no registered handle function for message type %v", name)
-		}
-		f.Call([]reflect.Value{v})
+		n.call(<-n.MessageChan)
+	}
+}
+
+// Deliver passes a message received from the network to its handle function in the calling goroutine,
+// it replaces Run when the node is driven by the deterministic simulator
+func (n *node) Deliver(m interface{}) {
+	if n.isByz && config.GetConfig().HasStrategy(n.id, "silence") {
+		return
+	}
+	if txn, ok := m.(message.Transaction); ok {
+		txn.C = make(chan message.TransactionReply, 1)
+		m = txn
+	}
+	n.call(m)
+}
+
+// call calls the registered handle function of the message using reflection
+func (n *node) call(m interface{}) {
+	v := reflect.ValueOf(m)
+	name := v.Type().String()
+	f, exists := n.handles[name]
+	if !exists {
+		log.Fatalf("no registered handle function for message type %v", name)
 	}
+	f.Call([]reflect.Value{v})
 }
 
 /*
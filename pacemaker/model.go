@@ -1,25 +1,140 @@
 package pacemaker
 
 import (
+	"fmt"
+	"sort"
+
 	"github.com/gitferry/bamboo/blockchain"
 	"github.com/gitferry/bamboo/crypto"
 	"github.com/gitferry/bamboo/identity"
+	"github.com/gitferry/bamboo/log"
 	"github.com/gitferry/bamboo/types"
 )
 
+// TMO is a signed timeout message, the signature is over (view, highQC view)
 type TMO struct {
 	View   types.View
 	NodeID identity.NodeID
 	HighQC *blockchain.QC
+	crypto.Signature
 }
 
+// TC carries one aggregated signature of the timeouts plus a bitmap of its signers,
+// and the highest QC among the timeouts
 type TC struct {
 	types.View
+	Signers     crypto.Bitmap
+	HighQCViews []types.View // the high qc view of each signer, ordered as the signers
+	HighQC      *blockchain.QC
 	crypto.AggSig
 	crypto.Signature
 }
 
-func NewTC(view types.View, requesters map[identity.NodeID]*TMO) *TC {
-	// TODO: add crypto
-	return &TC{View: view}
+// MakeTMO creates a timeout message signed by the node
+func MakeTMO(view types.View, nodeID identity.NodeID, highQC *blockchain.QC) *TMO {
+	tmo := &TMO{
+		View:   view,
+		NodeID: nodeID,
+		HighQC: highQC,
+	}
+	sig, err := crypto.PrivSign(tmoDigest(view, tmo.highQCView()), nodeID, nil)
+	if err != nil {
+		log.Fatalf("[%v] has an error when signing a timeout", nodeID)
+		return nil
+	}
+	tmo.Signature = sig
+	return tmo
+}
+
+// Verify checks the signature of the timeout message
+func (tmo *TMO) Verify() bool {
+	if tmo.Signature == nil {
+		return false
+	}
+	ok, err := crypto.PubVerify(tmo.Signature, tmoDigest(tmo.View, tmo.highQCView()), tmo.NodeID)
+	if err != nil {
+		return false
+	}
+	return ok
+}
+
+func (tmo *TMO) highQCView() types.View {
+	if tmo.HighQC == nil {
+		return 0
+	}
+	return tmo.HighQC.View
+}
+
+// NewTC aggregates the timeouts of a quorum out of n nodes into a TC
+func NewTC(view types.View, n int, requesters map[identity.NodeID]*TMO) (*TC, error) {
+	nodes := make([]identity.NodeID, 0, len(requesters))
+	for id := range requesters {
+		nodes = append(nodes, id)
+	}
+	// signatures are ordered as the signers in the bitmap
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Node() < nodes[j].Node() })
+	tc := &TC{
+		View:    view,
+		Signers: crypto.NewBitmap(n),
+	}
+	sigs := make([]crypto.Signature, 0, len(nodes))
+	for _, id := range nodes {
+		tmo := requesters[id]
+		tc.Signers.Set(id)
+		tc.HighQCViews = append(tc.HighQCViews, tmo.highQCView())
+		sigs = append(sigs, tmo.Signature)
+		if tmo.HighQC != nil && (tc.HighQC == nil || tmo.HighQC.View > tc.HighQC.View) {
+			tc.HighQC = tmo.HighQC
+		}
+	}
+	aggSig, err := crypto.AggregateSignatures(sigs)
+	if err != nil {
+		return nil, fmt.Errorf("cannot aggregate timeout signatures for view %v: %w", view, err)
+	}
+	tc.AggSig = aggSig
+	return tc, nil
+}
+
+// VerifyTC checks that the tc is signed by a super majority of n nodes
+// and that it carries the highest QC reported by the signers
+func VerifyTC(tc *TC, n int) error {
+	if tc.Signers.Count() <= n*2/3 {
+		return fmt.Errorf("tc for view %v is not signed by a quorum", tc.View)
+	}
+	if len(tc.HighQCViews) != tc.Signers.Count() {
+		return fmt.Errorf("tc for view %v has %v high qc views for %v signers", tc.View, len(tc.HighQCViews), tc.Signers.Count())
+	}
+	var maxView types.View
+	msgs := make([][]byte, 0, len(tc.HighQCViews))
+	for _, v := range tc.HighQCViews {
+		if v > maxView {
+			maxView = v
+		}
+		msgs = append(msgs, tmoDigest(tc.View, v))
+	}
+	if maxView > 0 {
+		if tc.HighQC == nil || tc.HighQC.View != maxView {
+			return fmt.Errorf("tc for view %v does not carry the highest qc", tc.View)
+		}
+		ok, err := crypto.VerifyQuorumSignature(tc.HighQC.AggSig, tc.HighQC.BlockID, tc.HighQC.Signers)
+		if err != nil || !ok {
+			return fmt.Errorf("tc for view %v carries an invalid high qc", tc.View)
+		}
+	}
+	ok, err := crypto.VerifyAggregateSignature(tc.AggSig, msgs, tc.Signers)
+	if err != nil {
+		return fmt.Errorf("cannot verify tc for view %v: %w", tc.View, err)
+	}
+	if !ok {
+		return fmt.Errorf("tc for view %v has an invalid signature", tc.View)
+	}
+	return nil
+}
+
+func tmoDigest(view types.View, highQCView types.View) []byte {
+	id := crypto.MakeID(struct {
+		View       types.View
+		HighQCView types.View
+	}{view, highQCView})
+	return crypto.IDToByte(id)
 }
@@ -12,6 +12,8 @@ type Pacemaker struct {
 	curView           types.View
 	newViewChan       chan types.View
 	timeoutController *TimeoutController
+	policy            TimeoutPolicy
+	tcViews           map[types.View]struct{} // views ended by a tc that are not entered yet
 	mu                sync.Mutex
 }
 
@@ -19,6 +21,9 @@ func NewPacemaker(n int) *Pacemaker {
 	pm := new(Pacemaker)
 	pm.newViewChan = make(chan types.View, 100)
 	pm.timeoutController = NewTimeoutController(n)
+	pm.tcViews = make(map[types.View]struct{})
+	base := config.GetTimer()
+	pm.policy = NewTimeoutPolicy(config.GetConfig().TimeoutPolicy, base, time.Duration(config.GetConfig().MaxTimeout)*time.Millisecond)
 	return pm
 }
 
@@ -26,7 +31,34 @@ func (p *Pacemaker) ProcessRemoteTmo(tmo *TMO) (bool, *TC) {
 	if tmo.View < p.curView {
 		return false, nil
 	}
-	return p.timeoutController.AddTmo(tmo)
+	isBuilt, tc := p.timeoutController.AddTmo(tmo)
+	if isBuilt {
+		p.OnTC(tc.View)
+	}
+	return isBuilt, tc
+}
+
+// OnTC records that the view ends with a tc, either built locally or received from another replica
+func (p *Pacemaker) OnTC(view types.View) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if view >= p.curView {
+		p.tcViews[view] = struct{}{}
+	}
+}
+
+// EndedByTC checks if the view ended with a tc rather than a qc or a commit,
+// the views up to it are forgotten since the view after it is entered
+func (p *Pacemaker) EndedByTC(view types.View) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, ok := p.tcViews[view]
+	for v := range p.tcViews {
+		if v <= view {
+			delete(p.tcViews, v)
+		}
+	}
+	return ok
 }
 
 func (p *Pacemaker) AdvanceView(view types.View) {
@@ -50,5 +82,15 @@ func (p *Pacemaker) GetCurView() types.View {
 }
 
 func (p *Pacemaker) GetTimerForView() time.Duration {
-	return time.Duration(config.GetConfig().Timeout) * time.Millisecond
+	return p.policy.Timeout()
+}
+
+// OnTimeout reports that the timer of the current view fired
+func (p *Pacemaker) OnTimeout() {
+	p.policy.OnTimeout()
+}
+
+// OnProgress reports that a view ended with a qc or a commit before its timer fired and how long it lasted
+func (p *Pacemaker) OnProgress(d time.Duration) {
+	p.policy.OnProgress(d)
 }
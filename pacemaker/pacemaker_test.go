@@ -3,16 +3,22 @@ package pacemaker
 import (
 	"testing"
 
+	"github.com/gitferry/bamboo/blockchain"
+	"github.com/gitferry/bamboo/config"
+	"github.com/gitferry/bamboo/crypto"
+	"github.com/gitferry/bamboo/identity"
 	"github.com/stretchr/testify/require"
 )
 
+func init() {
+	config.Configuration.Signer = crypto.BLS_BLS12381
+	_ = crypto.SetKeysWith(4, crypto.BLS_BLS12381)
+}
+
 // receive only one tmo
 func TestRemoteTmo1(t *testing.T) {
 	pm := NewPacemaker(4)
-	tmo1 := &TMO{
-		View:   2,
-		NodeID: "1",
-	}
+	tmo1 := MakeTMO(2, "1", nil)
 	isBuilt, tc := pm.ProcessRemoteTmo(tmo1)
 	require.False(t, isBuilt)
 	require.Nil(t, tc)
@@ -21,15 +27,9 @@ func TestRemoteTmo1(t *testing.T) {
 // receive only two tmo
 func TestRemoteTmo2(t *testing.T) {
 	pm := NewPacemaker(4)
-	tmo1 := &TMO{
-		View:   2,
-		NodeID: "1",
-	}
+	tmo1 := MakeTMO(2, "1", nil)
 	isBuilt, tc := pm.ProcessRemoteTmo(tmo1)
-	tmo2 := &TMO{
-		View:   2,
-		NodeID: "2",
-	}
+	tmo2 := MakeTMO(2, "2", nil)
 	isBuilt, tc = pm.ProcessRemoteTmo(tmo2)
 	require.False(t, isBuilt)
 	require.Nil(t, tc)
@@ -38,20 +38,11 @@ func TestRemoteTmo2(t *testing.T) {
 // receive only three tmo
 func TestRemoteTmo3(t *testing.T) {
 	pm := NewPacemaker(4)
-	tmo1 := &TMO{
-		View:   2,
-		NodeID: "1",
-	}
+	tmo1 := MakeTMO(2, "1", nil)
 	isBuilt, tc := pm.ProcessRemoteTmo(tmo1)
-	tmo2 := &TMO{
-		View:   2,
-		NodeID: "2",
-	}
+	tmo2 := MakeTMO(2, "2", nil)
 	isBuilt, tc = pm.ProcessRemoteTmo(tmo2)
-	tmo3 := &TMO{
-		View:   2,
-		NodeID: "3",
-	}
+	tmo3 := MakeTMO(2, "3", nil)
 	isBuilt, tc = pm.ProcessRemoteTmo(tmo3)
 	require.True(t, isBuilt)
 	require.NotNil(t, tc)
@@ -60,26 +51,84 @@ func TestRemoteTmo3(t *testing.T) {
 // receive four tmo
 func TestRemoteTmo4(t *testing.T) {
 	pm := NewPacemaker(4)
-	tmo1 := &TMO{
-		View:   2,
-		NodeID: "1",
-	}
+	tmo1 := MakeTMO(2, "1", nil)
 	isBuilt, tc := pm.ProcessRemoteTmo(tmo1)
-	tmo2 := &TMO{
-		View:   2,
-		NodeID: "2",
-	}
+	tmo2 := MakeTMO(2, "2", nil)
 	isBuilt, tc = pm.ProcessRemoteTmo(tmo2)
-	tmo3 := &TMO{
-		View:   2,
-		NodeID: "3",
-	}
+	tmo3 := MakeTMO(2, "3", nil)
 	isBuilt, tc = pm.ProcessRemoteTmo(tmo3)
-	tmo4 := &TMO{
-		View:   2,
-		NodeID: "4",
-	}
+	tmo4 := MakeTMO(2, "4", nil)
 	isBuilt, tc = pm.ProcessRemoteTmo(tmo4)
 	require.False(t, isBuilt)
 	require.NotNil(t, tc)
 }
+
+// the tc is built by the third tmo only, the fourth gets the same tc
+func TestRemoteTmoBuilt(t *testing.T) {
+	pm := NewPacemaker(4)
+	pm.ProcessRemoteTmo(MakeTMO(2, "1", nil))
+	pm.ProcessRemoteTmo(MakeTMO(2, "2", nil))
+	isBuilt, built := pm.ProcessRemoteTmo(MakeTMO(2, "3", nil))
+	require.True(t, isBuilt)
+	require.NotNil(t, built)
+	isBuilt, tc := pm.ProcessRemoteTmo(MakeTMO(2, "4", nil))
+	require.False(t, isBuilt)
+	require.Equal(t, built, tc)
+}
+
+// a signed tmo carrying a forged high qc is dropped and does not spoil the tc
+func TestRemoteTmoForgedQC(t *testing.T) {
+	pm := NewPacemaker(4)
+	forged := &blockchain.QC{View: 1000, BlockID: crypto.Identifier{1}}
+	isBuilt, tc := pm.ProcessRemoteTmo(MakeTMO(2, "1", forged))
+	require.False(t, isBuilt)
+	require.Nil(t, tc)
+	for _, id := range []identity.NodeID{"2", "3"} {
+		isBuilt, tc = pm.ProcessRemoteTmo(MakeTMO(2, id, nil))
+	}
+	require.False(t, isBuilt)
+	isBuilt, tc = pm.ProcessRemoteTmo(MakeTMO(2, "4", nil))
+	require.True(t, isBuilt)
+	require.NoError(t, VerifyTC(tc, 4))
+}
+
+// a view ended by a tc shows no progress
+func TestEndedByTC(t *testing.T) {
+	pm := NewPacemaker(4)
+	for _, id := range []identity.NodeID{"1", "2", "3"} {
+		pm.ProcessRemoteTmo(MakeTMO(2, id, nil))
+	}
+	require.False(t, pm.EndedByTC(1))
+	require.True(t, pm.EndedByTC(2))
+	// the view is forgotten once entered
+	require.False(t, pm.EndedByTC(2))
+}
+
+// receive a forged tmo
+func TestRemoteTmoForged(t *testing.T) {
+	pm := NewPacemaker(4)
+	tmo1 := MakeTMO(2, "1", nil)
+	isBuilt, tc := pm.ProcessRemoteTmo(tmo1)
+	tmo2 := MakeTMO(2, "2", nil)
+	isBuilt, tc = pm.ProcessRemoteTmo(tmo2)
+	tmo3 := MakeTMO(2, "3", nil)
+	tmo3.NodeID = "4"
+	isBuilt, tc = pm.ProcessRemoteTmo(tmo3)
+	require.False(t, isBuilt)
+	require.Nil(t, tc)
+}
+
+// a tc built from three tmos is valid
+func TestVerifyTC(t *testing.T) {
+	pm := NewPacemaker(4)
+	var tc *TC
+	for _, id := range []string{"1", "2", "3"} {
+		_, tc = pm.ProcessRemoteTmo(MakeTMO(2, identity.NodeID(id), nil))
+	}
+	require.NotNil(t, tc)
+	require.NoError(t, VerifyTC(tc, 4))
+
+	// tamper with the view
+	tc.View = 3
+	require.Error(t, VerifyTC(tc, 4))
+}
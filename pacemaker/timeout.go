@@ -0,0 +1,143 @@
+package pacemaker
+
+import (
+	"sync"
+	"time"
+)
+
+// timeout policies
+const (
+	FIXED    = "fixed"
+	BACKOFF  = "backoff"
+	ADAPTIVE = "adaptive"
+)
+
+const (
+	// minAdaptiveTimeout is the lower bound of an adaptive timeout
+	minAdaptiveTimeout = 10 * time.Millisecond
+	// maxBackoff bounds the timeout to maxBackoff times the base timeout if no upper bound is given
+	maxBackoff = 64
+)
+
+// TimeoutPolicy decides how long a replica stays in a view before timing out
+type TimeoutPolicy interface {
+	// Timeout returns the timeout of the current view
+	Timeout() time.Duration
+	// OnTimeout is called when the local timer of a view fires
+	OnTimeout()
+	// OnProgress is called when a view ends with a qc or a commit before its timer fires, with the duration of the view
+	OnProgress(d time.Duration)
+}
+
+// NewTimeoutPolicy creates a policy that starts from the base timeout and never exceeds max
+func NewTimeoutPolicy(policy string, base time.Duration, max time.Duration) TimeoutPolicy {
+	if max == 0 {
+		max = maxBackoff * base
+	}
+	if max < base {
+		max = base
+	}
+	switch policy {
+	case BACKOFF:
+		return &backoff{base: base, max: max}
+	case ADAPTIVE:
+		return &adaptive{base: base, max: max, timeout: base}
+	default:
+		return &fixed{timeout: base}
+	}
+}
+
+// fixed always waits for the same timeout
+type fixed struct {
+	timeout time.Duration
+}
+
+func (f *fixed) Timeout() time.Duration {
+	return f.timeout
+}
+
+func (f *fixed) OnTimeout() {}
+
+func (f *fixed) OnProgress(d time.Duration) {}
+
+// backoff doubles the timeout on every consecutive timeout and resets it on progress
+type backoff struct {
+	base     time.Duration
+	max      time.Duration
+	timeouts int // number of consecutive timeouts
+	mu       sync.Mutex
+}
+
+func (b *backoff) Timeout() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	timeout := b.base
+	for i := 0; i < b.timeouts && timeout < b.max; i++ {
+		timeout *= 2
+	}
+	if timeout > b.max {
+		timeout = b.max
+	}
+	return timeout
+}
+
+func (b *backoff) OnTimeout() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.timeouts++
+}
+
+func (b *backoff) OnProgress(d time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.timeouts = 0
+}
+
+// adaptive estimates the timeout from the observed view durations as TCP estimates its retransmission timeout,
+// i.e., the smoothed duration plus four times its variation, and doubles it on every timeout
+type adaptive struct {
+	base    time.Duration
+	max     time.Duration
+	srtt    time.Duration // smoothed view duration
+	rttvar  time.Duration // variation of the view duration
+	timeout time.Duration
+	mu      sync.Mutex
+}
+
+func (a *adaptive) Timeout() time.Duration {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.timeout
+}
+
+func (a *adaptive) OnTimeout() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.timeout *= 2
+	if a.timeout > a.max {
+		a.timeout = a.max
+	}
+}
+
+func (a *adaptive) OnProgress(d time.Duration) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.srtt == 0 {
+		a.srtt = d
+		a.rttvar = d / 2
+	} else {
+		diff := a.srtt - d
+		if diff < 0 {
+			diff = -diff
+		}
+		a.rttvar = (3*a.rttvar + diff) / 4
+		a.srtt = (7*a.srtt + d) / 8
+	}
+	a.timeout = a.srtt + 4*a.rttvar
+	if a.timeout < minAdaptiveTimeout {
+		a.timeout = minAdaptiveTimeout
+	}
+	if a.timeout > a.max {
+		a.timeout = a.max
+	}
+}
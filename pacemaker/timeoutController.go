@@ -1,7 +1,9 @@
 package pacemaker
 
 import (
+	"github.com/gitferry/bamboo/crypto"
 	"github.com/gitferry/bamboo/identity"
+	"github.com/gitferry/bamboo/log"
 	"github.com/gitferry/bamboo/types"
 	"sync"
 )
@@ -9,6 +11,7 @@ import (
 type TimeoutController struct {
 	n        int                                     // the size of the network
 	timeouts map[types.View]map[identity.NodeID]*TMO // keeps track of timeout msgs
+	tcs      map[types.View]*TC                      // the tc built for each view
 	mu       sync.Mutex
 }
 
@@ -16,14 +19,29 @@ func NewTimeoutController(n int) *TimeoutController {
 	tcl := new(TimeoutController)
 	tcl.n = n
 	tcl.timeouts = make(map[types.View]map[identity.NodeID]*TMO)
+	tcl.tcs = make(map[types.View]*TC)
 	return tcl
 }
 
 func (tcl *TimeoutController) AddTmo(tmo *TMO) (bool, *TC) {
+	if !tmo.Verify() {
+		log.Warningf("received a timeout with an invalid signature from %v, view: %v", tmo.NodeID, tmo.View)
+		return false, nil
+	}
+	// the signature covers the view of the high qc, which must be certified by a quorum
+	// so that a forged qc cannot invalidate every tc built from the timeout
+	if tmo.HighQC != nil && tmo.HighQC.View > 0 {
+		ok, err := crypto.VerifyQuorumSignature(tmo.HighQC.AggSig, tmo.HighQC.BlockID, tmo.HighQC.Signers)
+		if err != nil || !ok {
+			log.Warningf("received a timeout with an invalid high qc from %v, view: %v", tmo.NodeID, tmo.View)
+			return false, nil
+		}
+	}
 	tcl.mu.Lock()
 	defer tcl.mu.Unlock()
+	// the tc is built once, the later timeouts get the one already built
 	if tcl.superMajority(tmo.View) {
-		return false, nil
+		return false, tcl.tcs[tmo.View]
 	}
 	_, exist := tcl.timeouts[tmo.View]
 	if !exist {
@@ -32,7 +50,13 @@ func (tcl *TimeoutController) AddTmo(tmo *TMO) (bool, *TC) {
 	}
 	tcl.timeouts[tmo.View][tmo.NodeID] = tmo
 	if tcl.superMajority(tmo.View) {
-		return true, NewTC(tmo.View, tcl.timeouts[tmo.View])
+		tc, err := NewTC(tmo.View, tcl.n, tcl.timeouts[tmo.View])
+		if err != nil {
+			log.Warningf("cannot generate a valid tc, view: %v: %v", tmo.View, err)
+			return false, nil
+		}
+		tcl.tcs[tmo.View] = tc
+		return true, tc
 	}
 
 	return false, nil
@@ -0,0 +1,43 @@
+package pacemaker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// the timeout doubles on consecutive timeouts up to the bound and resets on progress
+func TestBackoffPolicy(t *testing.T) {
+	policy := NewTimeoutPolicy(BACKOFF, 100*time.Millisecond, 350*time.Millisecond)
+	require.Equal(t, 100*time.Millisecond, policy.Timeout())
+	policy.OnTimeout()
+	require.Equal(t, 200*time.Millisecond, policy.Timeout())
+	policy.OnTimeout()
+	require.Equal(t, 350*time.Millisecond, policy.Timeout())
+	policy.OnTimeout()
+	require.Equal(t, 350*time.Millisecond, policy.Timeout())
+	policy.OnProgress(50 * time.Millisecond)
+	require.Equal(t, 100*time.Millisecond, policy.Timeout())
+}
+
+// the timeout follows the observed view durations
+func TestAdaptivePolicy(t *testing.T) {
+	policy := NewTimeoutPolicy(ADAPTIVE, 1000*time.Millisecond, 0)
+	require.Equal(t, 1000*time.Millisecond, policy.Timeout())
+	for i := 0; i < 50; i++ {
+		policy.OnProgress(20 * time.Millisecond)
+	}
+	require.True(t, policy.Timeout() < 100*time.Millisecond)
+	require.True(t, policy.Timeout() >= 20*time.Millisecond)
+	timeout := policy.Timeout()
+	policy.OnTimeout()
+	require.Equal(t, 2*timeout, policy.Timeout())
+}
+
+func TestFixedPolicy(t *testing.T) {
+	policy := NewTimeoutPolicy(FIXED, 100*time.Millisecond, 0)
+	policy.OnTimeout()
+	policy.OnProgress(10 * time.Millisecond)
+	require.Equal(t, 100*time.Millisecond, policy.Timeout())
+}
@@ -0,0 +1,120 @@
+package replica
+
+import (
+	"math"
+	"time"
+
+	"github.com/gitferry/bamboo/config"
+	"github.com/gitferry/bamboo/identity"
+	"github.com/gitferry/bamboo/log"
+	"github.com/gitferry/bamboo/types"
+)
+
+// fault actions of the timeline
+const (
+	CRASH     = "crash"
+	DROP      = "drop"
+	SLOW      = "slow"
+	FLAKY     = "flaky"
+	PARTITION = "partition"
+)
+
+// forever is the duration in seconds of the faults without a duration
+const forever = math.MaxInt32
+
+// scheduleFaults arms the faults of the timeline that are injected at a time,
+// they are handed to the event loop so that they run between two events
+func (r *Replica) scheduleFaults() {
+	for _, f := range config.GetConfig().Faults {
+		if f.View > 0 || !r.injects(f) {
+			continue
+		}
+		f := f
+		d := time.Duration(f.At) * time.Second
+		if r.clock != nil {
+			r.clock.After(d, func() {
+				r.eventChan <- f
+				r.processEvents()
+			})
+		} else {
+			time.AfterFunc(d, func() { r.eventChan <- f })
+		}
+	}
+}
+
+// injectViewFaults injects the faults of the timeline due when the replica enters the view,
+// including the ones of the views it skipped
+func (r *Replica) injectViewFaults(view types.View) {
+	for i, f := range config.GetConfig().Faults {
+		if f.View > 0 && types.View(f.View) <= view && !r.injected[i] && r.injects(f) {
+			r.injected[i] = true
+			r.injectFault(f)
+		}
+	}
+}
+
+// injects returns true if the replica takes part in the fault, every replica takes part in a partition
+func (r *Replica) injects(f config.Fault) bool {
+	return f.Action == PARTITION || contains(f.Nodes, r.ID())
+}
+
+// injectFault applies the fault to the links of the replica
+func (r *Replica) injectFault(f config.Fault) {
+	log.Infof("[%v] injects the fault %v", r.ID(), f)
+	t := f.Duration
+	if t <= 0 && f.Action != CRASH && f.Action != PARTITION {
+		t = forever
+	}
+	peers := others(f.Peers, r.ID())
+	switch f.Action {
+	case CRASH:
+		r.Crash(t)
+	case PARTITION:
+		groups := [][]identity.NodeID{f.Nodes}
+		if len(f.Peers) > 0 {
+			groups = append(groups, f.Peers)
+		}
+		r.Partition(t, true, groups...)
+	case DROP:
+		for _, id := range peers {
+			r.Drop(id, t)
+		}
+	case SLOW:
+		for _, id := range peers {
+			r.Slow(id, f.Delay, t)
+		}
+	case FLAKY:
+		for _, id := range peers {
+			r.Flaky(id, f.P, t)
+		}
+	default:
+		log.Warningf("[%v] unknown fault %v", r.ID(), f.Action)
+	}
+}
+
+// contains returns true if the id is in the ids, every id is if they are empty
+func contains(ids []identity.NodeID, id identity.NodeID) bool {
+	if len(ids) == 0 {
+		return true
+	}
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
+
+// others returns the ids, or every node if they are empty, except the excluded one
+func others(ids []identity.NodeID, excluded identity.NodeID) []identity.NodeID {
+	if len(ids) == 0 {
+		ids = config.GetConfig().IDs()
+	}
+	var peers []identity.NodeID
+	for _, id := range ids {
+		if id != excluded {
+			peers = append(peers, id)
+		}
+	}
+	return peers
+}
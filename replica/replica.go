@@ -1,7 +1,6 @@
 package replica
 
 import (
-	"encoding/gob"
 	"fmt"
 	fhs "github.com/gitferry/bamboo/fasthostuff"
 	"github.com/gitferry/bamboo/lbft"
@@ -9,34 +8,61 @@ import (
 
 	"go.uber.org/atomic"
 
+	"github.com/gitferry/bamboo/adversary"
 	"github.com/gitferry/bamboo/blockchain"
 	"github.com/gitferry/bamboo/config"
+	"github.com/gitferry/bamboo/db"
 	"github.com/gitferry/bamboo/election"
 	"github.com/gitferry/bamboo/hotstuff"
 	"github.com/gitferry/bamboo/identity"
+	"github.com/gitferry/bamboo/jolteon"
 	"github.com/gitferry/bamboo/log"
 	"github.com/gitferry/bamboo/mempool"
 	"github.com/gitferry/bamboo/message"
+	"github.com/gitferry/bamboo/narwhal"
 	"github.com/gitferry/bamboo/node"
 	"github.com/gitferry/bamboo/pacemaker"
+	"github.com/gitferry/bamboo/sft"
 	"github.com/gitferry/bamboo/streamlet"
 	"github.com/gitferry/bamboo/tchs"
+	"github.com/gitferry/bamboo/transport"
 	"github.com/gitferry/bamboo/types"
 )
 
+// read modes
+const (
+	CONSENSUS = "consensus"
+	LEASE     = "lease"
+)
+
+// readTimeout bounds the wait for the commit of a read when the transactions do not expire
+const readTimeout = 10 * time.Second
+
 type Replica struct {
 	node.Node
 	Safety
 	election.Election
 	pd              *mempool.Producer
+	dag             *narwhal.Mempool // replaces the producer if the narwhal mempool is configured
+	sft             *sft.Sft         // the protocol if it is sft, it also processes the endorsements
 	pm              *pacemaker.Pacemaker
 	start           chan bool // signal to start the node
 	isStarted       atomic.Bool
 	isByz           bool
 	timer           *time.Timer // timeout for each view
+	timedOut        bool        // the timer of the current view fired
+	clock           Clock       // virtual clock of the deterministic simulator, nil if the replica runs in real time
+	commitListener  func(block *blockchain.Block)
+	injected        map[int]bool // the faults of the timeline injected at a view so far
 	committedBlocks chan *blockchain.Block
 	forkedBlocks    chan *blockchain.Block
 	eventChan       chan interface{}
+	proposedView    atomic.Int64 // the last view in which the replica proposed
+	leaseView       atomic.Int64 // the view in which the lease is held
+	leaseStart      atomic.Int64 // proposing time of the last committed block proposed by the replica, in ns
+	readNo          atomic.Int64
+	committedView   types.View
+	unresolved      []*blockchain.Block // committed blocks waiting for the data of the dag, in the commit order
 
 	/* for monitoring node statistics */
 	thrus                string
@@ -63,19 +89,32 @@ type Replica struct {
 
 // NewReplica creates a new replica instance
 func NewReplica(id identity.NodeID, alg string, isByz bool) *Replica {
+	return newReplica(node.NewNode(id, isByz), alg, isByz)
+}
+
+func newReplica(n node.Node, alg string, isByz bool) *Replica {
 	r := new(Replica)
-	r.Node = node.NewNode(id, isByz)
+	r.Node = n
+	var adv *adversary.Adversary
 	if isByz {
 		log.Infof("[%v] is Byzantine", r.ID())
+		adv = adversary.NewAdversary(n, config.GetConfig().Strategies(n.ID()))
+		if adv != nil {
+			r.Node = adv
+		}
 	}
 	if config.GetConfig().Master == "0" {
-		r.Election = election.NewRotation(config.GetConfig().N())
+		r.Election = election.NewElection(config.GetConfig().Election, config.GetConfig().N())
 	} else {
 		r.Election = election.NewStatic(config.GetConfig().Master)
 	}
 	r.isByz = isByz
 	r.pd = mempool.NewProducer()
+	if config.GetConfig().Mempool == narwhal.NARWHAL {
+		r.dag = narwhal.NewMempool(r.Node)
+	}
 	r.pm = pacemaker.NewPacemaker(config.GetConfig().N())
+	r.injected = make(map[int]bool)
 	r.start = make(chan bool)
 	r.eventChan = make(chan interface{})
 	r.committedBlocks = make(chan *blockchain.Block, 100)
@@ -83,12 +122,19 @@ func NewReplica(id identity.NodeID, alg string, isByz bool) *Replica {
 	r.Register(blockchain.Block{}, r.HandleBlock)
 	r.Register(blockchain.Vote{}, r.HandleVote)
 	r.Register(pacemaker.TMO{}, r.HandleTmo)
+	r.Register(pacemaker.TC{}, r.HandleTC)
+	r.Register(blockchain.SyncRequest{}, r.HandleSyncRequest)
+	r.Register(blockchain.SyncResponse{}, r.HandleSyncResponse)
 	r.Register(message.Transaction{}, r.handleTxn)
 	r.Register(message.Query{}, r.handleQuery)
-	gob.Register(blockchain.Block{})
-	gob.Register(blockchain.Vote{})
-	gob.Register(pacemaker.TC{})
-	gob.Register(pacemaker.TMO{})
+	r.Register(message.Read{}, r.handleRead)
+	// the blocks go on their own stream under mux so that the votes and the timeouts are not held up behind them
+	transport.RegisterClass(blockchain.Block{}, "block")
+	transport.RegisterClass(blockchain.Vote{}, "vote")
+	transport.RegisterClass(pacemaker.TC{}, "timeout")
+	transport.RegisterClass(pacemaker.TMO{}, "timeout")
+	transport.RegisterClass(blockchain.SyncRequest{}, "sync")
+	transport.RegisterClass(blockchain.SyncResponse{}, "sync")
 
 	// Is there a better way to reduce the number of parameters?
 	switch alg {
@@ -102,9 +148,19 @@ func NewReplica(id identity.NodeID, alg string, isByz bool) *Replica {
 		r.Safety = lbft.NewLbft(r.Node, r.pm, r.Election, r.committedBlocks, r.forkedBlocks)
 	case "fasthotstuff":
 		r.Safety = fhs.NewFhs(r.Node, r.pm, r.Election, r.committedBlocks, r.forkedBlocks)
+	case "jolteon":
+		r.Safety = jolteon.NewJolteon(r.Node, r.pm, r.Election, r.committedBlocks, r.forkedBlocks)
+	case "sft":
+		r.sft = sft.NewSft(r.Node, r.pm, r.Election, r.committedBlocks, r.forkedBlocks)
+		r.Safety = r.sft
+		r.Register(sft.Endorsement{}, r.HandleEndorsement)
+		transport.RegisterClass(sft.Endorsement{}, "vote")
 	default:
 		r.Safety = hotstuff.NewHotStuff(r.Node, r.pm, r.Election, r.committedBlocks, r.forkedBlocks)
 	}
+	if adv != nil {
+		r.Safety = adv.Wrap(r.Safety)
+	}
 	return r
 }
 
@@ -134,6 +190,29 @@ func (r *Replica) HandleTmo(tmo pacemaker.TMO) {
 	r.eventChan <- tmo
 }
 
+func (r *Replica) HandleTC(tc pacemaker.TC) {
+	if tc.View < r.pm.GetCurView() {
+		return
+	}
+	log.Debugf("[%v] received a tc for view %v", r.ID(), tc.View)
+	r.eventChan <- tc
+}
+
+func (r *Replica) HandleEndorsement(e sft.Endorsement) {
+	log.Debugf("[%v] received an endorsement from %v, blockID is %x", r.ID(), e.Voter, e.BlockID)
+	r.eventChan <- e
+}
+
+func (r *Replica) HandleSyncRequest(req blockchain.SyncRequest) {
+	log.Debugf("[%v] received a sync request from %v, id: %x, count: %v", r.ID(), req.Requester, req.ID, req.Count)
+	r.eventChan <- req
+}
+
+func (r *Replica) HandleSyncResponse(resp blockchain.SyncResponse) {
+	log.Debugf("[%v] received %v synced blocks from %v", r.ID(), len(resp.Blocks), resp.Responder)
+	r.eventChan <- resp
+}
+
 // handleQuery replies a query with the statistics of the node
 func (r *Replica) handleQuery(m message.Query) {
 	//realAveProposeTime := float64(r.totalProposeDuration.Milliseconds()) / float64(r.processedNo)
@@ -148,14 +227,72 @@ func (r *Replica) handleQuery(m message.Query) {
 	r.thrus += fmt.Sprintf("Time: %v s. Throughput: %v txs/s\n", time.Now().Sub(r.startTime).Seconds(), float64(r.totalCommittedTx)/time.Now().Sub(r.tmpTime).Seconds())
 	r.totalCommittedTx = 0
 	r.tmpTime = time.Now()
-	status := fmt.Sprintf("Latency: %v\n%s", latency, r.thrus)
+	status := fmt.Sprintf("Latency: %v\n%sMessage sizes:\n%s", latency, r.thrus, transport.SizesString())
 	//status := fmt.Sprintf("chain status is: %s\nCommitted rate is %v.\nAve. block size is %v.\nAve. trans. delay is %v ms.\nAve. creation time is %f ms.\nAve. processing time is %v ms.\nAve. vote time is %v ms.\nRequest rate is %f txs/s.\nAve. round time is %f ms.\nLatency is %f ms.\nThroughput is %f txs/s.\n", r.Safety.GetChainStatus(), committedRate, aveBlockSize, aveTransDelay, aveCreateDuration, aveProcessTime, aveVoteProcessTime, requestRate, aveRoundTime, latency, throughput)
 	//status := fmt.Sprintf("Ave. actual proposing time is %v ms.\nAve. proposing time is %v ms.\nAve. processing time is %v ms.\nAve. vote time is %v ms.\nAve. block size is %v.\nAve. round time is %v ms.\nLatency is %v ms.\n", realAveProposeTime, aveProposeTime, aveProcessTime, aveVoteProcessTime, aveBlockSize, aveRoundTime, latency)
 	m.Reply(message.QueryReply{Info: status})
 }
 
+// handleRead serves a read locally if the replica holds the leader lease,
+// otherwise the read is ordered with the writes through consensus
+func (r *Replica) handleRead(m message.Read) {
+	if config.GetConfig().ReadMode == LEASE && r.holdsLease() {
+		log.Debugf("[%v] serves the read of key %v under the lease", r.ID(), m.Key)
+		m.Reply(message.ReadReply{CommandID: m.CommandID, Value: r.Get(m.Key)})
+		return
+	}
+	txn := message.Transaction{
+		Command: db.Command{
+			Key:       m.Key,
+			ClientID:  m.ClientID,
+			CommandID: m.CommandID,
+		},
+		Timestamp: time.Now(),
+		NodeID:    r.ID(),
+		ID:        fmt.Sprintf("%v-read-%v", r.ID(), r.readNo.Inc()),
+		C:         make(chan message.TransactionReply, 1),
+	}
+	// a read that is forked and never committed expires like a queued transaction
+	ttl := time.Duration(config.GetConfig().TxnTTL) * time.Millisecond
+	if ttl <= 0 {
+		ttl = readTimeout
+	}
+	go func() {
+		timer := time.NewTimer(ttl)
+		defer timer.Stop()
+		select {
+		case reply := <-txn.C:
+			m.Reply(message.ReadReply{CommandID: m.CommandID, Value: reply.Value, Err: reply.Err})
+		case <-timer.C:
+			m.Reply(message.ReadReply{CommandID: m.CommandID, Err: mempool.ErrExpired})
+		}
+	}()
+	r.handleTxn(txn)
+}
+
+// holdsLease checks if the replica leads the current view, holds the lease of the view and the lease is not expired.
+// A lease is granted in a view the replica proposed in when a block it proposed is committed in that view,
+// and it ends at the next view change.
+// Since a quorum voted for the block after it was proposed, no other replica
+// can be elected before the quorum times out, which takes longer than the lease.
+func (r *Replica) holdsLease() bool {
+	view := r.pm.GetCurView()
+	if types.View(r.leaseView.Load()) != view || !r.IsLeader(r.ID(), view) {
+		return false
+	}
+	lease := time.Duration(config.GetConfig().Lease) * time.Millisecond
+	if lease == 0 {
+		lease = config.GetTimer() / 2
+	}
+	return time.Now().Before(time.Unix(0, r.leaseStart.Load()).Add(lease))
+}
+
 func (r *Replica) handleTxn(m message.Transaction) {
-	r.pd.AddTxn(&m)
+	if r.dag != nil {
+		r.dag.AddTxn(&m)
+	} else if !r.addTxn(&m) {
+		return
+	}
 	r.startSignal()
 	// the first leader kicks off the protocol
 	if r.pm.GetCurView() == 0 && r.IsLeader(r.ID(), 1) {
@@ -164,20 +301,108 @@ func (r *Replica) handleTxn(m message.Transaction) {
 	}
 }
 
+// addTxn adds the transaction to the memory pool and gossips the ones received from clients,
+// it returns false if the transaction is rejected
+func (r *Replica) addTxn(txn *message.Transaction) bool {
+	if txn.NodeID != r.ID() {
+		// the reply channel of a gossiped transaction belongs to another replica
+		txn.C = nil
+	}
+	if err := r.pd.AddTxn(txn); err != nil {
+		log.Debugf("[%v] rejected the transaction %v: %v", r.ID(), txn.ID, err)
+		if txn.C != nil {
+			txn.Reply(message.TransactionReply{Command: txn.Command, Err: err})
+		}
+		return false
+	}
+	if txn.NodeID == r.ID() {
+		gossip := *txn
+		gossip.C = nil
+		r.Broadcast(gossip)
+	}
+	return true
+}
+
 /* Processors */
 
 func (r *Replica) processCommittedBlock(block *blockchain.Block) {
+	// SFT reports the blocks again when their commits get stronger
+	if block.Strength > 0 && block.View <= r.committedView {
+		log.Infof("[%v] the block is %v-strong committed, view: %v, current view: %v, latency: %v, id: %x", r.ID(), block.Strength, block.View, r.pm.GetCurView(), r.now().Sub(block.Timestamp), block.ID)
+		return
+	}
+	r.committedView = block.View
+	if r.dag != nil {
+		r.unresolved = append(r.unresolved, block)
+		r.resolveCommittedBlocks()
+		return
+	}
+	r.executeBlock(block, block.Payload)
+}
+
+// resolveCommittedBlocks executes the committed blocks in the commit order once the dag delivers their transactions,
+// the blocks behind one whose data cannot be fetched in time wait for the next attempt
+func (r *Replica) resolveCommittedBlocks() {
+	for len(r.unresolved) > 0 {
+		block := r.unresolved[0]
+		txns, err := r.dag.Resolve(block.Digests)
+		if err != nil {
+			log.Warningf("[%v] cannot execute the committed block yet, view: %v, id: %x: %v", r.ID(), block.View, block.ID, err)
+			return
+		}
+		r.unresolved = r.unresolved[1:]
+		r.executeBlock(block, txns)
+	}
+}
+
+// executeBlock applies the transactions of the committed block and replies to the clients
+func (r *Replica) executeBlock(block *blockchain.Block, txns []*message.Transaction) {
+	// a transaction may be proposed by several leaders before it is committed,
+	// only the first commit takes effect
+	payload := make([]*message.Transaction, 0, len(txns))
+	for _, txn := range txns {
+		if r.pd.IsCommitted(txn) {
+			continue
+		}
+		if local := r.pd.Commit(txn); local != nil {
+			txn = local
+		}
+		payload = append(payload, txn)
+	}
+	cmds := make([]db.Command, 0, len(payload))
+	for _, txn := range payload {
+		cmds = append(cmds, txn.Command)
+	}
+	results := r.Apply(cmds)
+	if e, ok := r.Election.(election.Adaptive); ok {
+		e.Commit(block)
+	}
 	if block.Proposer == r.ID() {
-		for _, txn := range block.Payload {
-			// only record the delay of transactions from the local memory pool
-			delay := time.Now().Sub(txn.Timestamp)
-			r.totalDelay += delay
-			r.latencyNo++
+		view := r.pm.GetCurView()
+		if types.View(r.proposedView.Load()) == view {
+			r.leaseStart.Store(block.Timestamp.UnixNano())
+			r.leaseView.Store(int64(view))
+		}
+	}
+	for i, txn := range payload {
+		// only transactions from the local memory pool have a client waiting for the reply
+		if txn.C == nil {
+			continue
 		}
+		delay := time.Now().Sub(txn.Timestamp)
+		r.totalDelay += delay
+		r.latencyNo++
+		reply := message.NewReply(delay)
+		reply.Command = txn.Command
+		reply.Value = results[i]
+		txn.Reply(reply)
 	}
 	r.committedNo++
-	r.totalCommittedTx += len(block.Payload)
-	log.Infof("[%v] the block is committed, No. of transactions: %v, view: %v, current view: %v, id: %x", r.ID(), len(block.Payload), block.View, r.pm.GetCurView(), block.ID)
+	r.totalCommittedTx += len(payload)
+	log.Infof("[%v] the block is committed, No. of transactions: %v, view: %v, current view: %v, id: %x, prevID: %x", r.ID(), len(payload), block.View, r.pm.GetCurView(), block.ID, block.PrevID)
+	if r.commitListener != nil {
+		r.commitListener(block)
+	}
 }
 
 func (r *Replica) processForkedBlock(block *blockchain.Block) {
@@ -190,8 +415,23 @@ func (r *Replica) processForkedBlock(block *blockchain.Block) {
 	log.Infof("[%v] the block is forked, No. of transactions: %v, view: %v, current view: %v, id: %x", r.ID(), len(block.Payload), block.View, r.pm.GetCurView(), block.ID)
 }
 
+// processTC validates a tc received from another replica before the view is advanced
+func (r *Replica) processTC(tc *pacemaker.TC) {
+	if tc.View < r.pm.GetCurView() {
+		return
+	}
+	err := pacemaker.VerifyTC(tc, config.GetConfig().N())
+	if err != nil {
+		log.Warningf("[%v] received an invalid tc: %v", r.ID(), err)
+		return
+	}
+	r.pm.OnTC(tc.View)
+	r.Safety.ProcessTC(tc)
+}
+
 func (r *Replica) processNewView(newView types.View) {
 	log.Debugf("[%v] is processing new view: %v, leader is %v", r.ID(), newView, r.FindLeaderFor(newView))
+	r.injectViewFaults(newView)
 	if !r.IsLeader(r.ID(), newView) {
 		return
 	}
@@ -200,16 +440,25 @@ func (r *Replica) processNewView(newView types.View) {
 
 func (r *Replica) proposeBlock(view types.View) {
 	createStart := time.Now()
-	block := r.Safety.MakeProposal(view, r.pd.GeneratePayload())
+	var payload []*message.Transaction
+	if r.dag == nil {
+		payload = r.pd.GeneratePayload()
+	}
+	block := r.Safety.MakeProposal(view, payload)
+	if r.dag != nil {
+		// the block carries the certificates of the dag instead of the transactions
+		block.SetDigests(r.dag.Digests())
+	}
 	r.totalBlockSize += len(block.Payload)
 	r.proposedNo++
 	createEnd := time.Now()
 	createDuration := createEnd.Sub(createStart)
-	block.Timestamp = time.Now()
+	block.Timestamp = r.now()
+	r.proposedView.Store(int64(view))
 	r.totalCreateDuration += createDuration
 	r.Broadcast(block)
 	_ = r.Safety.ProcessBlock(block)
-	r.voteStart = time.Now()
+	r.voteStart = r.now()
 }
 
 // ListenLocalEvent listens new view and timeout events
@@ -222,34 +471,79 @@ func (r *Replica) ListenLocalEvent() {
 		for {
 			select {
 			case view := <-r.pm.EnteringViewEvent():
-				if view >= 2 {
-					r.totalVoteTime += time.Now().Sub(r.voteStart)
-				}
-				// measure round time
-				now := time.Now()
-				lasts := now.Sub(r.lastViewTime)
-				r.totalRoundTime += lasts
-				r.roundNo++
-				r.lastViewTime = now
-				r.eventChan <- view
-				log.Debugf("[%v] the last view lasts %v milliseconds, current view: %v", r.ID(), lasts.Milliseconds(), view)
+				r.enterView(view)
 				break L
 			case <-r.timer.C:
-				r.Safety.ProcessLocalTmo(r.pm.GetCurView())
+				r.processLocalTmo()
 				break L
 			}
 		}
 	}
 }
 
+// enterView records the statistics of the last view and hands the new view to the event loop
+func (r *Replica) enterView(view types.View) {
+	now := r.now()
+	if view >= 2 {
+		r.totalVoteTime += now.Sub(r.voteStart)
+	}
+	// measure round time
+	lasts := now.Sub(r.lastViewTime)
+	r.totalRoundTime += lasts
+	r.roundNo++
+	r.lastViewTime = now
+	// the view ended with a qc or a commit before the timer fired,
+	// a view ended by a tc shows no progress even if the timer of this replica did not fire
+	if !r.pm.EndedByTC(view-1) && !r.timedOut {
+		r.pm.OnProgress(lasts)
+	}
+	r.timedOut = false
+	r.eventChan <- view
+	log.Debugf("[%v] the last view lasts %v milliseconds, current view: %v", r.ID(), lasts.Milliseconds(), view)
+}
+
+// processLocalTmo handles the timeout of the current view
+func (r *Replica) processLocalTmo() {
+	r.timedOut = true
+	r.pm.OnTimeout()
+	r.Safety.ProcessLocalTmo(r.pm.GetCurView())
+}
+
+// now returns the time of the replica, which is virtual in a simulation
+func (r *Replica) now() time.Time {
+	if r.clock != nil {
+		return r.clock.Now()
+	}
+	return time.Now()
+}
+
+// awaitDigests hands the block back to the event loop once the dag stores the certificates it refers to,
+// the block is dropped if they do not arrive within the view timeout
+func (r *Replica) awaitDigests(block blockchain.Block) {
+	if !r.dag.WaitAvailable(block.Digests, config.GetTimer()) {
+		log.Warningf("[%v] the certificates of the block are not available, view: %v, id: %x", r.ID(), block.View, block.ID)
+		return
+	}
+	r.eventChan <- block
+}
+
 // ListenCommittedBlocks listens committed blocks and forked blocks from the protocols
 func (r *Replica) ListenCommittedBlocks() {
+	// the committed blocks whose dag data is missing are retried periodically
+	var retry <-chan time.Time
+	if r.dag != nil {
+		ticker := time.NewTicker(config.GetTimer())
+		defer ticker.Stop()
+		retry = ticker.C
+	}
 	for {
 		select {
 		case committedBlock := <-r.committedBlocks:
 			r.processCommittedBlock(committedBlock)
 		case forkedBlock := <-r.forkedBlocks:
 			r.processForkedBlock(forkedBlock)
+		case <-retry:
+			r.resolveCommittedBlocks()
 		}
 	}
 }
@@ -267,30 +561,54 @@ func (r *Replica) startSignal() {
 // Start starts event loop
 func (r *Replica) Start() {
 	go r.Run()
+	if r.sft != nil {
+		go r.sft.ListenCommits()
+	}
+	r.scheduleFaults()
 	// wait for the start signal
 	<-r.start
 	go r.ListenLocalEvent()
 	go r.ListenCommittedBlocks()
 	for r.isStarted.Load() {
-		event := <-r.eventChan
-		switch v := event.(type) {
-		case types.View:
-			r.processNewView(v)
-		case blockchain.Block:
-			startProcessTime := time.Now()
-			r.totalProposeDuration += startProcessTime.Sub(v.Timestamp)
-			_ = r.Safety.ProcessBlock(&v)
-			r.totalProcessDuration += time.Now().Sub(startProcessTime)
-			r.voteStart = time.Now()
-			r.processedNo++
-		case blockchain.Vote:
-			startProcessTime := time.Now()
-			r.Safety.ProcessVote(&v)
-			processingDuration := time.Now().Sub(startProcessTime)
-			r.totalVoteTime += processingDuration
-			r.voteNo++
-		case pacemaker.TMO:
-			r.Safety.ProcessRemoteTmo(&v)
+		r.processEvent(<-r.eventChan)
+	}
+}
+
+// processEvent dispatches an event of the event loop
+func (r *Replica) processEvent(event interface{}) {
+	switch v := event.(type) {
+	case types.View:
+		r.processNewView(v)
+	case blockchain.Block:
+		if r.dag != nil && v.Proposer != r.ID() && !r.dag.WaitAvailable(v.Digests, 0) {
+			// the block is processed, and thus voted for, once the certificates it refers to are stored,
+			// they are fetched without holding up the event loop
+			go r.awaitDigests(v)
+			return
 		}
+		startProcessTime := time.Now()
+		r.totalProposeDuration += startProcessTime.Sub(v.Timestamp)
+		_ = r.Safety.ProcessBlock(&v)
+		r.totalProcessDuration += time.Now().Sub(startProcessTime)
+		r.voteStart = r.now()
+		r.processedNo++
+	case blockchain.Vote:
+		startProcessTime := time.Now()
+		r.Safety.ProcessVote(&v)
+		processingDuration := time.Now().Sub(startProcessTime)
+		r.totalVoteTime += processingDuration
+		r.voteNo++
+	case pacemaker.TMO:
+		r.Safety.ProcessRemoteTmo(&v)
+	case pacemaker.TC:
+		r.processTC(&v)
+	case sft.Endorsement:
+		r.sft.ProcessEndorsement(&v)
+	case config.Fault:
+		r.injectFault(v)
+	case blockchain.SyncRequest:
+		r.Safety.ProcessSyncRequest(&v)
+	case blockchain.SyncResponse:
+		r.Safety.ProcessSyncResponse(&v)
 	}
 }
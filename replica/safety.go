@@ -12,6 +12,9 @@ type Safety interface {
 	ProcessVote(vote *blockchain.Vote)
 	ProcessRemoteTmo(tmo *pacemaker.TMO)
 	ProcessLocalTmo(view types.View)
+	ProcessTC(tc *pacemaker.TC)
+	ProcessSyncRequest(req *blockchain.SyncRequest)
+	ProcessSyncResponse(resp *blockchain.SyncResponse)
 	MakeProposal(view types.View, payload []*message.Transaction) *blockchain.Block
 	GetChainStatus() string
 }
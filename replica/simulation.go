@@ -0,0 +1,97 @@
+package replica
+
+import (
+	"time"
+
+	"github.com/gitferry/bamboo/blockchain"
+	"github.com/gitferry/bamboo/config"
+	"github.com/gitferry/bamboo/identity"
+	"github.com/gitferry/bamboo/log"
+	"github.com/gitferry/bamboo/narwhal"
+	"github.com/gitferry/bamboo/node"
+	"github.com/gitferry/bamboo/socket"
+)
+
+// Clock provides the virtual time and the view timer of a replica driven by the deterministic simulator
+type Clock interface {
+	// Now returns the virtual time
+	Now() time.Time
+	// Reset arms the view timer of the replica to call Timeout after d, the previous timer is cancelled
+	Reset(d time.Duration)
+	// After calls f after d
+	After(d time.Duration, f func())
+}
+
+// NewSimulatedReplica creates a replica that does not run goroutines of its own,
+// the simulator calls Deliver and Timeout instead of Start.
+// The narwhal mempool runs in real time and cannot be simulated.
+func NewSimulatedReplica(id identity.NodeID, alg string, isByz bool, s socket.Socket, clock Clock) *Replica {
+	if config.GetConfig().Mempool == narwhal.NARWHAL {
+		log.Fatalf("[%v] the narwhal mempool cannot be simulated, set the mempool to default", id)
+	}
+	r := newReplica(node.NewNodeWithSocket(id, isByz, s), alg, isByz)
+	r.clock = clock
+	// the events are buffered and processed by the caller of Deliver and Timeout
+	r.start = make(chan bool, 1)
+	r.eventChan = make(chan interface{}, config.GetConfig().ChanBufferSize)
+	r.lastViewTime = clock.Now()
+	r.scheduleFaults()
+	// arms the timer of the first view like ListenLocalEvent does in real time,
+	// a protocol that waits for a timeout to leave the first view would stall otherwise
+	clock.Reset(r.pm.GetTimerForView())
+	return r
+}
+
+// SetCommitListener sets a function that is called with every block committed by the replica
+func (r *Replica) SetCommitListener(listener func(block *blockchain.Block)) {
+	r.commitListener = listener
+}
+
+// Deliver handles a message from the network and processes every event it causes
+func (r *Replica) Deliver(m interface{}) {
+	r.Node.Deliver(m)
+	r.processEvents()
+}
+
+// Timeout fires the view timer of the replica and processes every event it causes
+func (r *Replica) Timeout() {
+	r.processLocalTmo()
+	r.clock.Reset(r.pm.GetTimerForView())
+	r.processEvents()
+}
+
+// processEvents runs the pending events in a fixed order until there is none left,
+// so that the execution only depends on the order in which messages and timeouts arrive
+func (r *Replica) processEvents() {
+	for {
+		if r.sft != nil {
+			r.sft.ProcessCommits()
+		}
+		select {
+		case block := <-r.committedBlocks:
+			r.processCommittedBlock(block)
+			continue
+		default:
+		}
+		select {
+		case block := <-r.forkedBlocks:
+			r.processForkedBlock(block)
+			continue
+		default:
+		}
+		select {
+		case view := <-r.pm.EnteringViewEvent():
+			r.enterView(view)
+			r.clock.Reset(r.pm.GetTimerForView())
+			continue
+		default:
+		}
+		select {
+		case event := <-r.eventChan:
+			r.processEvent(event)
+			continue
+		default:
+		}
+		return
+	}
+}
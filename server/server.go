@@ -2,8 +2,8 @@ package main
 
 import (
 	"flag"
-	"strconv"
 	"sync"
+	"time"
 
 	"github.com/gitferry/bamboo"
 	"github.com/gitferry/bamboo/config"
@@ -11,16 +11,18 @@ import (
 	"github.com/gitferry/bamboo/identity"
 	"github.com/gitferry/bamboo/log"
 	"github.com/gitferry/bamboo/replica"
+	"github.com/gitferry/bamboo/simulator"
 )
 
 var algorithm = flag.String("algorithm", "hotstuff", "BFT consensus algorithm")
 var id = flag.String("id", "", "NodeID of the node")
 var simulation = flag.Bool("sim", false, "simulation mode")
+var deterministic = flag.Bool("deterministic", false, "deterministic simulation mode with a virtual clock, configured by the simulation section of the config")
 
 func initReplica(id identity.NodeID, isByz bool) {
 	log.Infof("node %v starting...", id)
 	if isByz {
-		log.Infof("node %v is Byzantine", id)
+		log.Infof("node %v is Byzantine with strategies %v", id, config.GetConfig().Strategies(id))
 	}
 
 	r := replica.NewReplica(id, *algorithm, isByz)
@@ -34,25 +36,24 @@ func main() {
 	if errCrypto != nil {
 		log.Fatal("Could not generate keys:", errCrypto)
 	}
+	if *deterministic {
+		sim := simulator.NewSimulator(*algorithm)
+		err := sim.Run(time.Duration(config.GetConfig().Simulation.Duration) * time.Millisecond)
+		if err != nil {
+			log.Fatalf("the simulation violates the invariants:\n%v", err)
+		}
+		return
+	}
 	if *simulation {
 		var wg sync.WaitGroup
 		wg.Add(1)
 		config.Simulation()
 		for id := range config.GetConfig().Addrs {
-			isByz := false
-			if id.Node() <= config.GetConfig().ByzNo {
-				isByz = true
-			}
-			go initReplica(id, isByz)
+			go initReplica(id, config.GetConfig().IsByzantine(id))
 		}
 		wg.Wait()
 	} else {
 		setupDebug()
-		isByz := false
-		i, _ := strconv.Atoi(*id)
-		if i <= config.GetConfig().ByzNo {
-			isByz = true
-		}
-		initReplica(identity.NodeID(*id), isByz)
+		initReplica(identity.NodeID(*id), config.GetConfig().IsByzantine(identity.NodeID(*id)))
 	}
 }
@@ -0,0 +1,56 @@
+package sft
+
+import (
+	"github.com/gitferry/bamboo/crypto"
+	"github.com/gitferry/bamboo/identity"
+	"github.com/gitferry/bamboo/log"
+	"github.com/gitferry/bamboo/types"
+)
+
+// Endorsement is broadcast along with every vote, the signature is over (block id, marker).
+// The marker is the highest view of the blocks the voter has voted for
+// that are not extended by the block, so the vote also endorses
+// the ancestors of the block whose views are higher than the marker.
+type Endorsement struct {
+	types.View
+	Voter   identity.NodeID
+	BlockID crypto.Identifier
+	Marker  types.View
+	crypto.Signature
+}
+
+// MakeEndorsement creates an endorsement signed by the voter
+func MakeEndorsement(view types.View, voter identity.NodeID, id crypto.Identifier, marker types.View) *Endorsement {
+	sig, err := crypto.PrivSign(endorsementDigest(id, marker), voter, nil)
+	if err != nil {
+		log.Fatalf("[%v] has an error when signing an endorsement", voter)
+		return nil
+	}
+	return &Endorsement{
+		View:      view,
+		Voter:     voter,
+		BlockID:   id,
+		Marker:    marker,
+		Signature: sig,
+	}
+}
+
+// Verify checks the signature of the endorsement
+func (e *Endorsement) Verify() bool {
+	if e.Signature == nil {
+		return false
+	}
+	ok, err := crypto.PubVerify(e.Signature, endorsementDigest(e.BlockID, e.Marker), e.Voter)
+	if err != nil {
+		return false
+	}
+	return ok
+}
+
+func endorsementDigest(id crypto.Identifier, marker types.View) []byte {
+	digest := crypto.MakeID(struct {
+		BlockID crypto.Identifier
+		Marker  types.View
+	}{id, marker})
+	return crypto.IDToByte(digest)
+}
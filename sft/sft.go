@@ -0,0 +1,315 @@
+package sft
+
+import (
+	"sync"
+
+	"github.com/gitferry/bamboo/blockchain"
+	"github.com/gitferry/bamboo/config"
+	"github.com/gitferry/bamboo/crypto"
+	"github.com/gitferry/bamboo/election"
+	"github.com/gitferry/bamboo/hotstuff"
+	"github.com/gitferry/bamboo/identity"
+	"github.com/gitferry/bamboo/log"
+	"github.com/gitferry/bamboo/node"
+	"github.com/gitferry/bamboo/pacemaker"
+	"github.com/gitferry/bamboo/types"
+)
+
+// maxPending bounds the number of committed blocks whose strength is still tracked
+const maxPending = 1000
+
+// Sft runs HotStuff and strengthens its commits (SFT, strengthened fault tolerance).
+// Every replica broadcasts an endorsement along with its vote and counts the endorsers of each block.
+// A block is x-strong certified if it is endorsed by x+f+1 replicas, and it is x-strong committed
+// if it starts three x-strong certified blocks of consecutive views or one of its descendants is x-strong committed.
+// A regular commit is f-strong, a block is sent to the committed-block channel once it is committed
+// and again each time its strength increases, up to 2f, in the order of the commits and the increases.
+type Sft struct {
+	*hotstuff.HotStuff
+	f               int
+	bc              *blockchain.BlockChain
+	commits         chan *blockchain.Block
+	committedBlocks chan *blockchain.Block
+	endorsers       map[crypto.Identifier]map[identity.NodeID]struct{}
+	buffered        map[crypto.Identifier][]*Endorsement // endorsements of the blocks not received yet
+	voted           []*blockchain.Block                  // the voted blocks that are not committed yet
+	forkedView      types.View                           // the highest view of the voted blocks that are forked
+	pending         []*commitment                        // the committed blocks in ascending order of view
+	reports         []*blockchain.Block                  // the commits and upgrades not forwarded to the replica yet
+	reported        chan struct{}                        // signalled when a report is queued
+	mu              sync.Mutex
+}
+
+type commitment struct {
+	block    *blockchain.Block
+	strength int
+}
+
+func NewSft(
+	node node.Node,
+	pm *pacemaker.Pacemaker,
+	elec election.Election,
+	committedBlocks chan *blockchain.Block,
+	forkedBlocks chan *blockchain.Block) *Sft {
+	sf := new(Sft)
+	sf.f = (config.GetConfig().N() - 1) / 3
+	sf.commits = make(chan *blockchain.Block, cap(committedBlocks))
+	sf.committedBlocks = committedBlocks
+	sf.reported = make(chan struct{}, 1)
+	sf.endorsers = make(map[crypto.Identifier]map[identity.NodeID]struct{})
+	sf.buffered = make(map[crypto.Identifier][]*Endorsement)
+	sf.HotStuff = hotstuff.NewHotStuff(node, pm, elec, sf.commits, forkedBlocks)
+	sf.bc = sf.GetBlockChain()
+	sf.SetVoteListener(sf.endorse)
+	return sf
+}
+
+func (sf *Sft) ProcessBlock(block *blockchain.Block) error {
+	err := sf.HotStuff.ProcessBlock(block)
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	// the endorsements of the block and of the buffered blocks it released
+	for id, endorsements := range sf.buffered {
+		b, err := sf.bc.GetBlockByID(id)
+		if err != nil {
+			continue
+		}
+		delete(sf.buffered, id)
+		for _, e := range endorsements {
+			sf.countEndorsement(b, e)
+		}
+	}
+	sf.updateStrength()
+	return err
+}
+
+// ProcessEndorsement counts the endorsement for the block and its ancestors above the marker
+func (sf *Sft) ProcessEndorsement(e *Endorsement) {
+	log.Debugf("[%v] is processing an endorsement from %v, marker: %v, block id: %x", sf.ID(), e.Voter, e.Marker, e.BlockID)
+	if e.Voter != sf.ID() && !e.Verify() {
+		log.Warningf("[%v] received an endorsement with an invalid signature from %v", sf.ID(), e.Voter)
+		return
+	}
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	block, err := sf.bc.GetBlockByID(e.BlockID)
+	if err != nil {
+		sf.buffered[e.BlockID] = append(sf.buffered[e.BlockID], e)
+		return
+	}
+	sf.countEndorsement(block, e)
+	sf.updateStrength()
+}
+
+// GetStrength returns the x of the x-strong commit of a committed block which is still tracked
+func (sf *Sft) GetStrength(id crypto.Identifier) (int, bool) {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	for _, c := range sf.pending {
+		if c.block.ID == id {
+			return c.strength, true
+		}
+	}
+	return 0, false
+}
+
+// endorse broadcasts the endorsement of a block the replica votes for
+func (sf *Sft) endorse(block *blockchain.Block) {
+	sf.mu.Lock()
+	marker := sf.marker(block)
+	sf.voted = append(sf.voted, block)
+	sf.mu.Unlock()
+	e := MakeEndorsement(block.View, sf.ID(), block.ID, marker)
+	sf.Broadcast(e)
+	sf.ProcessEndorsement(e)
+}
+
+// marker returns the highest view of the voted blocks that the block does not extend,
+// the caller must hold the lock
+func (sf *Sft) marker(block *blockchain.Block) types.View {
+	ancestors := make(map[crypto.Identifier]struct{})
+	for b, err := sf.bc.GetParentBlock(block.ID); err == nil; b, err = sf.bc.GetParentBlock(b.ID) {
+		ancestors[b.ID] = struct{}{}
+	}
+	marker := sf.forkedView
+	for _, v := range sf.voted {
+		if _, ok := ancestors[v.ID]; !ok && v.View > marker {
+			marker = v.View
+		}
+	}
+	return marker
+}
+
+// countEndorsement records the voter as an endorser of the block and of its ancestors
+// whose views are higher than the marker, the caller must hold the lock
+func (sf *Sft) countEndorsement(block *blockchain.Block, e *Endorsement) {
+	last := block
+	for b := block; b.View > e.Marker; {
+		sf.addEndorser(b.ID, e.Voter)
+		last = b
+		parent, err := sf.bc.GetParentBlock(b.ID)
+		if err != nil {
+			break
+		}
+		b = parent
+	}
+	// the pruned committed blocks are ancestors only if the walk reached the last committed one
+	n := len(sf.pending)
+	if n == 0 || sf.pending[n-1].block.ID != last.ID {
+		return
+	}
+	for i := n - 2; i >= 0 && sf.pending[i].block.View > e.Marker; i-- {
+		sf.addEndorser(sf.pending[i].block.ID, e.Voter)
+	}
+}
+
+func (sf *Sft) addEndorser(id crypto.Identifier, voter identity.NodeID) {
+	endorsers, ok := sf.endorsers[id]
+	if !ok {
+		endorsers = make(map[identity.NodeID]struct{})
+		sf.endorsers[id] = endorsers
+	}
+	endorsers[voter] = struct{}{}
+}
+
+// certified returns the x such that the block is x-strong certified, the caller must hold the lock
+func (sf *Sft) certified(id crypto.Identifier) int {
+	x := len(sf.endorsers[id]) - sf.f - 1
+	if x > 2*sf.f {
+		x = 2 * sf.f
+	}
+	return x
+}
+
+// updateStrength raises the strength of the committed blocks and reports the increases,
+// the caller must hold the lock
+func (sf *Sft) updateStrength() {
+	strength := -1
+	for i := len(sf.pending) - 1; i >= 0; i-- {
+		if i+2 < len(sf.pending) {
+			b0, b1, b2 := sf.pending[i].block, sf.pending[i+1].block, sf.pending[i+2].block
+			if b0.View+1 == b1.View && b1.View+1 == b2.View {
+				x := min(sf.certified(b0.ID), min(sf.certified(b1.ID), sf.certified(b2.ID)))
+				if x > strength {
+					strength = x
+				}
+			}
+		}
+		c := sf.pending[i]
+		if strength > c.strength {
+			c.strength = strength
+			// the block may be read by the replica, so the stronger commit is reported with a copy
+			upgraded := *c.block
+			upgraded.Strength = strength
+			sf.report(&upgraded)
+		}
+	}
+	sf.prune()
+}
+
+// prune stops tracking the committed blocks that reached the highest strength
+// and the oldest ones beyond maxPending, the caller must hold the lock
+func (sf *Sft) prune() {
+	i := 0
+	// the two blocks after a tracked block are kept to certify it
+	for i < len(sf.pending)-2 && (sf.pending[i].strength >= 2*sf.f || len(sf.pending)-i > maxPending) {
+		delete(sf.endorsers, sf.pending[i].block.ID)
+		i++
+	}
+	sf.pending = sf.pending[i:]
+}
+
+// report queues the committed block for the replica, the caller must hold the lock.
+// The queue is not bounded so that the lock is never held while waiting for the replica.
+func (sf *Sft) report(block *blockchain.Block) {
+	sf.reports = append(sf.reports, block)
+	select {
+	case sf.reported <- struct{}{}:
+	default:
+	}
+}
+
+// ListenCommits tracks the blocks committed by HotStuff and forwards them and their upgrades to the replica
+func (sf *Sft) ListenCommits() {
+	for {
+		select {
+		case block := <-sf.commits:
+			sf.processCommit(block)
+		case <-sf.reported:
+		}
+		sf.mu.Lock()
+		reports := sf.reports
+		sf.reports = nil
+		sf.mu.Unlock()
+		for _, block := range reports {
+			sf.committedBlocks <- block
+		}
+	}
+}
+
+// ProcessCommits tracks the blocks committed by HotStuff so far and forwards the reports without waiting,
+// a replica driven by the simulator calls it instead of running ListenCommits
+func (sf *Sft) ProcessCommits() {
+	for {
+		select {
+		case block := <-sf.commits:
+			sf.processCommit(block)
+		default:
+			sf.forward()
+			return
+		}
+	}
+}
+
+// forward sends the reports to the replica as long as it has room for them, the rest wait for the next call
+func (sf *Sft) forward() {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	for len(sf.reports) > 0 {
+		select {
+		case sf.committedBlocks <- sf.reports[0]:
+			sf.reports = sf.reports[1:]
+		default:
+			return
+		}
+	}
+}
+
+func (sf *Sft) processCommit(block *blockchain.Block) {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	sf.pending = append(sf.pending, &commitment{block: block, strength: sf.f})
+	sf.pruneVoted(block)
+	for id, endorsements := range sf.buffered {
+		if len(endorsements) > 0 && endorsements[0].View <= block.View {
+			delete(sf.buffered, id)
+		}
+	}
+	// a regular commit is f-strong, the block is shared with HotStuff so the strength is set on a copy
+	committed := *block
+	committed.Strength = sf.f
+	sf.report(&committed)
+	sf.updateStrength()
+}
+
+// pruneVoted drops the voted blocks up to the committed block
+// and remembers the highest view of the forked ones, the caller must hold the lock
+func (sf *Sft) pruneVoted(committed *blockchain.Block) {
+	voted := sf.voted[:0]
+	for _, v := range sf.voted {
+		switch {
+		case v.View > committed.View:
+			voted = append(voted, v)
+		case v.ID != committed.ID && v.View > sf.forkedView:
+			sf.forkedView = v.View
+		}
+	}
+	sf.voted = voted
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
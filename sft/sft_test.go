@@ -0,0 +1,94 @@
+package sft
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/gitferry/bamboo/blockchain"
+	"github.com/gitferry/bamboo/config"
+	"github.com/gitferry/bamboo/crypto"
+	"github.com/gitferry/bamboo/identity"
+	"github.com/gitferry/bamboo/types"
+)
+
+func newTestSft(views ...types.View) *Sft {
+	sf := &Sft{
+		f:               1,
+		committedBlocks: make(chan *blockchain.Block, 100),
+		endorsers:       make(map[crypto.Identifier]map[identity.NodeID]struct{}),
+	}
+	for _, view := range views {
+		block := &blockchain.Block{View: view, ID: crypto.MakeID(view), Strength: sf.f}
+		sf.pending = append(sf.pending, &commitment{block: block, strength: sf.f})
+	}
+	return sf
+}
+
+func (sf *Sft) endorseAll(voters int) {
+	for _, c := range sf.pending {
+		for i := 1; i <= voters; i++ {
+			sf.addEndorser(c.block.ID, identity.NewNodeID(i))
+		}
+	}
+}
+
+// a 3-chain endorsed by all the 4 replicas is 2-strong committed along with its ancestors
+func TestSft_StrongCommit(t *testing.T) {
+	sf := newTestSft(1, 2, 3, 4)
+	sf.endorseAll(3)
+	sf.updateStrength()
+	sf.ProcessCommits()
+	require.Len(t, sf.committedBlocks, 0)
+
+	sf.endorseAll(4)
+	sf.updateStrength()
+	sf.ProcessCommits()
+	require.Len(t, sf.committedBlocks, 2)
+	for i := 0; i < 2; i++ {
+		block := <-sf.committedBlocks
+		require.Equal(t, 2, block.Strength)
+	}
+	// the original blocks are not changed
+	require.Equal(t, 1, sf.pending[0].block.Strength)
+}
+
+// the blocks of a 3-chain must have consecutive views
+func TestSft_NonConsecutiveViews(t *testing.T) {
+	sf := newTestSft(1, 2, 4)
+	sf.endorseAll(4)
+	sf.updateStrength()
+	sf.ProcessCommits()
+	require.Len(t, sf.committedBlocks, 0)
+}
+
+// the upgrades beyond the room of the replica wait without holding the lock
+func TestSft_ManyUpgrades(t *testing.T) {
+	var views []types.View
+	for view := types.View(1); view <= 300; view++ {
+		views = append(views, view)
+	}
+	sf := newTestSft(views...)
+	sf.endorseAll(4)
+	sf.updateStrength()
+	// the replica processes the reports one by one as the simulator does
+	upgraded := make(map[types.View]struct{})
+	for i := 0; i < 298; i++ {
+		sf.ProcessCommits()
+		block := <-sf.committedBlocks
+		require.Equal(t, 2, block.Strength)
+		upgraded[block.View] = struct{}{}
+	}
+	require.Len(t, upgraded, 298)
+	sf.ProcessCommits()
+	require.Len(t, sf.committedBlocks, 0)
+}
+
+func TestEndorsement_Verify(t *testing.T) {
+	config.Configuration.Signer = crypto.BLS_BLS12381
+	crypto.SetKeysWith(4, crypto.BLS_BLS12381)
+	e := MakeEndorsement(3, identity.NewNodeID(1), crypto.MakeID("block"), 1)
+	require.True(t, e.Verify())
+	e.Marker = 0
+	require.False(t, e.Verify())
+}
@@ -0,0 +1,229 @@
+package simulator
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gitferry/bamboo/config"
+	"github.com/gitferry/bamboo/identity"
+	"github.com/gitferry/bamboo/log"
+	"github.com/gitferry/bamboo/socket"
+	"github.com/gitferry/bamboo/transport"
+)
+
+type link struct {
+	from, to identity.NodeID
+}
+
+// fault is a fault injected into the link to a peer until a virtual time
+type fault struct {
+	until time.Duration
+	delay time.Duration // for slow links
+	p     float64       // for flaky links
+}
+
+// Network carries the messages of the simulated sockets through the scheduler
+type Network struct {
+	sched   *Scheduler
+	ids     []identity.NodeID
+	latency socket.Latency
+	links   map[link]socket.Latency
+	sockets map[identity.NodeID]*simSocket
+	deliver func(from, to identity.NodeID, m interface{})
+}
+
+// NewNetwork creates a network among the nodes with the configured latencies,
+// deliver is called in the scheduler when a message arrives
+func NewNetwork(sched *Scheduler, ids []identity.NodeID, c config.SimConfig, deliver func(from, to identity.NodeID, m interface{})) *Network {
+	sorted := append([]identity.NodeID(nil), ids...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Node() < sorted[j].Node() })
+	n := &Network{
+		sched:   sched,
+		ids:     sorted,
+		latency: socket.NewLatency(c.Latency),
+		links:   make(map[link]socket.Latency),
+		sockets: make(map[identity.NodeID]*simSocket),
+		deliver: deliver,
+	}
+	for l, lc := range c.Links {
+		ends := strings.Split(l, "-")
+		if len(ends) != 2 {
+			log.Fatalf("the link %v is not in the form of from-to", l)
+		}
+		n.links[link{identity.NodeID(ends[0]), identity.NodeID(ends[1])}] = socket.NewLatency(lc)
+	}
+	for _, id := range sorted {
+		n.sockets[id] = &simSocket{
+			id:        id,
+			net:       n,
+			drop:      make(map[identity.NodeID]fault),
+			slow:      make(map[identity.NodeID]fault),
+			flaky:     make(map[identity.NodeID]fault),
+			partition: make(map[identity.NodeID]fault),
+		}
+	}
+	return n
+}
+
+// Socket returns the socket of the node
+func (n *Network) Socket(id identity.NodeID) socket.Socket {
+	return n.sockets[id]
+}
+
+// delay draws the delay of a message on the link, messages to self are not delayed
+func (n *Network) delay(from, to identity.NodeID) time.Duration {
+	if from == to {
+		return 0
+	}
+	if l, ok := n.links[link{from, to}]; ok {
+		return l.Sample(n.sched.Rand())
+	}
+	return n.latency.Sample(n.sched.Rand())
+}
+
+// clone copies the message through the codec as a real transport does, so that replicas share no memory
+func clone(m interface{}) interface{} {
+	codec := transport.NewCodec(*transport.CodecName)
+	b, err := codec.Marshal(m)
+	if err != nil {
+		log.Errorf("cannot encode the message %T: %v", m, err)
+		return m
+	}
+	c, err := codec.Unmarshal(b)
+	if err != nil {
+		log.Errorf("cannot decode the message %T: %v", m, err)
+		return m
+	}
+	return c
+}
+
+// simSocket implements socket.Socket on the simulated network, the faults last for virtual time
+type simSocket struct {
+	id        identity.NodeID
+	net       *Network
+	crash     time.Duration // crashed until, -1 for ever
+	drop      map[identity.NodeID]fault
+	slow      map[identity.NodeID]fault
+	flaky     map[identity.NodeID]fault
+	partition map[identity.NodeID]fault // until -1 for ever
+}
+
+func (s *simSocket) now() time.Duration {
+	return s.net.sched.Elapsed()
+}
+
+func (s *simSocket) crashed() bool {
+	return s.crash < 0 || s.now() < s.crash
+}
+
+func (s *simSocket) Send(to identity.NodeID, m interface{}) {
+	if s.crashed() {
+		return
+	}
+	if f, ok := s.drop[to]; ok && s.now() < f.until {
+		return
+	}
+	if f, ok := s.partition[to]; ok && (f.until < 0 || s.now() < f.until) {
+		return
+	}
+	if f, ok := s.flaky[to]; ok && s.now() < f.until && s.net.sched.Rand().Float64() < f.p {
+		return
+	}
+	delay := s.net.delay(s.id, to)
+	if f, ok := s.slow[to]; ok && s.now() < f.until {
+		delay += f.delay
+	}
+	c := clone(m)
+	from := s.id
+	s.net.sched.After(delay, func() {
+		if s.net.sockets[to].crashed() {
+			return
+		}
+		s.net.deliver(from, to, c)
+	})
+}
+
+func (s *simSocket) MulticastQuorum(quorum int, m interface{}) {
+	sent := map[int]struct{}{}
+	for i := 0; i < quorum; i++ {
+		r := s.net.sched.Rand().Intn(len(s.net.ids)) + 1
+		_, exists := sent[r]
+		if exists {
+			continue
+		}
+		s.Send(identity.NewNodeID(r), m)
+		sent[r] = struct{}{}
+	}
+}
+
+// Broadcast sends to the peers in the order of their ids
+func (s *simSocket) Broadcast(m interface{}) {
+	for _, id := range s.net.ids {
+		if id == s.id {
+			continue
+		}
+		s.Send(id, m)
+	}
+}
+
+// Recv is not used since the simulator delivers the messages
+func (s *simSocket) Recv() interface{} {
+	log.Fatalf("[%v] a simulated socket delivers messages through the scheduler", s.id)
+	return nil
+}
+
+func (s *simSocket) Close() {}
+
+func (s *simSocket) Drop(id identity.NodeID, t int) {
+	s.drop[id] = fault{until: s.now() + time.Duration(t)*time.Second}
+}
+
+func (s *simSocket) Slow(id identity.NodeID, d int, t int) {
+	s.slow[id] = fault{until: s.now() + time.Duration(t)*time.Second, delay: time.Duration(d) * time.Millisecond}
+}
+
+func (s *simSocket) Flaky(id identity.NodeID, p float64, t int) {
+	s.flaky[id] = fault{until: s.now() + time.Duration(t)*time.Second, p: p}
+}
+
+// Crash crashes the node for t seconds, for ever if t is not positive as a real socket does
+func (s *simSocket) Crash(t int) {
+	if t <= 0 {
+		s.crash = -1
+		return
+	}
+	s.crash = s.now() + time.Duration(t)*time.Second
+}
+
+// Partition cuts the links to the other groups for t seconds, for ever if t is not positive as a real socket does
+func (s *simSocket) Partition(t int, symmetric bool, groups ...[]identity.NodeID) {
+	until := s.now() + time.Duration(t)*time.Second
+	if t <= 0 {
+		until = -1
+	}
+	s.partition = make(map[identity.NodeID]fault)
+	for _, id := range socket.Partitioned(s.id, s.net.ids, symmetric, groups...) {
+		s.partition[id] = fault{until: until}
+	}
+}
+
+func (s *simSocket) Heal() {
+	s.partition = make(map[identity.NodeID]fault)
+}
+
+// Connections reports the simulated links as connected unless they are partitioned
+func (s *simSocket) Connections() map[identity.NodeID]transport.State {
+	states := make(map[identity.NodeID]transport.State)
+	for _, id := range s.net.ids {
+		if id == s.id {
+			continue
+		}
+		state := transport.State{Status: transport.CONNECTED}
+		if f, ok := s.partition[id]; ok && (f.until < 0 || s.now() < f.until) {
+			state.Status = transport.CONNECTING
+		}
+		states[id] = state
+	}
+	return states
+}
@@ -0,0 +1,7 @@
+//go:build race
+// +build race
+
+package simulator
+
+// race tells if the tests run under the race detector, which slows the simulations down about tenfold
+const race = true
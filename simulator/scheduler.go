@@ -0,0 +1,101 @@
+package simulator
+
+import (
+	"container/heap"
+	"math/rand"
+	"time"
+)
+
+// epoch is the virtual time at which every simulation starts
+var epoch = time.Unix(0, 0).UTC()
+
+// event is a function to run at a virtual time
+type event struct {
+	at        time.Duration
+	seq       uint64 // events of the same time run in the order they are scheduled
+	fn        func()
+	cancelled bool
+}
+
+type queue []*event
+
+func (q queue) Len() int { return len(q) }
+
+func (q queue) Less(i, j int) bool {
+	if q[i].at != q[j].at {
+		return q[i].at < q[j].at
+	}
+	return q[i].seq < q[j].seq
+}
+
+func (q queue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *queue) Push(x interface{}) { *q = append(*q, x.(*event)) }
+
+func (q *queue) Pop() interface{} {
+	old := *q
+	e := old[len(old)-1]
+	old[len(old)-1] = nil
+	*q = old[:len(old)-1]
+	return e
+}
+
+// Scheduler runs events one by one in the order of their virtual time,
+// the randomness of a simulation comes from its seeded source only
+type Scheduler struct {
+	now   time.Duration
+	seq   uint64
+	queue queue
+	rand  *rand.Rand
+}
+
+// NewScheduler creates a scheduler with the seed
+func NewScheduler(seed int64) *Scheduler {
+	return &Scheduler{
+		rand: rand.New(rand.NewSource(seed)),
+	}
+}
+
+// Now returns the virtual time
+func (s *Scheduler) Now() time.Time {
+	return epoch.Add(s.now)
+}
+
+// Elapsed returns the virtual time since the start of the simulation
+func (s *Scheduler) Elapsed() time.Duration {
+	return s.now
+}
+
+// Rand returns the seeded source of randomness
+func (s *Scheduler) Rand() *rand.Rand {
+	return s.rand
+}
+
+// After schedules fn to run after d of virtual time and returns the event so that it can be cancelled
+func (s *Scheduler) After(d time.Duration, fn func()) *event {
+	if d < 0 {
+		d = 0
+	}
+	s.seq++
+	e := &event{at: s.now + d, seq: s.seq, fn: fn}
+	heap.Push(&s.queue, e)
+	return e
+}
+
+// Run runs the events scheduled up to the virtual time until, it returns the number of events run
+func (s *Scheduler) Run(until time.Duration) int {
+	ran := 0
+	for s.queue.Len() > 0 && s.queue[0].at <= until {
+		e := heap.Pop(&s.queue).(*event)
+		if e.cancelled {
+			continue
+		}
+		s.now = e.at
+		e.fn()
+		ran++
+	}
+	if s.now < until {
+		s.now = until
+	}
+	return ran
+}
@@ -0,0 +1,197 @@
+package simulator
+
+import (
+	"fmt"
+	"hash"
+	"hash/fnv"
+	"time"
+
+	"github.com/gitferry/bamboo/blockchain"
+	"github.com/gitferry/bamboo/checker"
+	"github.com/gitferry/bamboo/config"
+	"github.com/gitferry/bamboo/crypto"
+	"github.com/gitferry/bamboo/db"
+	"github.com/gitferry/bamboo/identity"
+	"github.com/gitferry/bamboo/log"
+	"github.com/gitferry/bamboo/message"
+	"github.com/gitferry/bamboo/replica"
+	"github.com/gitferry/bamboo/types"
+)
+
+// Commit is a block committed by a replica at a virtual time
+type Commit struct {
+	At   time.Duration
+	View types.View
+	ID   crypto.Identifier
+}
+
+// Simulator runs every replica in the calling goroutine on a virtual clock,
+// the messages and the timeouts are delivered one by one by a seeded scheduler
+// so that the same seed replays the same execution
+type Simulator struct {
+	*Scheduler
+	net      *Network
+	ids      []identity.NodeID
+	replicas map[identity.NodeID]*replica.Replica
+	commits  map[identity.NodeID][]Commit
+	checker  *checker.Checker
+	digest   hash.Hash64
+	events   int
+	txnNo    int
+}
+
+// timer is the view timer of a replica in virtual time
+type timer struct {
+	sched *Scheduler
+	event *event
+	fire  func()
+}
+
+func (t *timer) Now() time.Time {
+	return t.sched.Now()
+}
+
+func (t *timer) After(d time.Duration, f func()) {
+	t.sched.After(d, f)
+}
+
+func (t *timer) Reset(d time.Duration) {
+	if t.event != nil {
+		t.event.cancelled = true
+	}
+	// fire is set once the replica is created, which arms its first timer
+	t.event = t.sched.After(d, func() { t.fire() })
+}
+
+// NewSimulator creates the replicas of the configuration running the algorithm
+func NewSimulator(alg string) *Simulator {
+	c := config.GetConfig().Simulation
+	s := &Simulator{
+		Scheduler: NewScheduler(c.Seed),
+		replicas:  make(map[identity.NodeID]*replica.Replica),
+		commits:   make(map[identity.NodeID][]Commit),
+		digest:    fnv.New64a(),
+	}
+	blockchain.SetClock(s.Now)
+	ids := config.GetConfig().IDs()
+	s.net = NewNetwork(s.Scheduler, ids, c, s.deliver)
+	s.ids = s.net.ids
+	var honest []identity.NodeID
+	for _, id := range s.ids {
+		if !config.GetConfig().IsByzantine(id) {
+			honest = append(honest, id)
+		}
+	}
+	s.checker = checker.NewChecker(honest, s.Now(), time.Duration(c.MaxGap)*time.Millisecond)
+	for _, id := range s.ids {
+		id := id
+		isByz := config.GetConfig().IsByzantine(id)
+		t := &timer{sched: s.Scheduler}
+		r := replica.NewSimulatedReplica(id, alg, isByz, s.net.Socket(id), t)
+		t.fire = func() {
+			s.trace("%v timeout", id)
+			r.Timeout()
+		}
+		r.SetCommitListener(func(block *blockchain.Block) {
+			s.trace("%v commit %v", id, block.View)
+			s.commits[id] = append(s.commits[id], Commit{At: s.Elapsed(), View: block.View, ID: block.ID})
+			if !isByz {
+				s.checker.Commit(id, block, s.Now())
+			}
+		})
+		s.replicas[id] = r
+	}
+	return s
+}
+
+// deliver hands the message to the replica
+func (s *Simulator) deliver(from, to identity.NodeID, m interface{}) {
+	s.trace("%v -> %v %T", from, to, m)
+	s.replicas[to].Deliver(m)
+}
+
+// trace adds an event to the digest of the execution
+func (s *Simulator) trace(format string, args ...interface{}) {
+	s.events++
+	line := fmt.Sprintf("%v ", s.Elapsed()) + fmt.Sprintf(format, args...)
+	log.Debugf("[sim] %v", line)
+	s.digest.Write([]byte(line + "\n"))
+}
+
+// Submit submits a transaction of the key to the replica after d of virtual time
+func (s *Simulator) Submit(d time.Duration, id identity.NodeID, key db.Key) {
+	s.After(d, func() {
+		s.txnNo++
+		txn := message.Transaction{
+			Command: db.Command{
+				Key:       key,
+				Value:     []byte(fmt.Sprintf("%v", s.txnNo)),
+				ClientID:  identity.NodeID("sim"),
+				CommandID: s.txnNo,
+			},
+			NodeID:    id,
+			ID:        fmt.Sprintf("sim-%v", s.txnNo),
+			Timestamp: s.Now(),
+		}
+		s.trace("client -> %v txn %v", id, txn.ID)
+		s.replicas[id].Deliver(txn)
+	})
+}
+
+// workload submits transactions at the configured rate to random replicas
+func (s *Simulator) workload(until time.Duration) {
+	rate := config.GetConfig().Simulation.Rate
+	if rate <= 0 {
+		return
+	}
+	keys := config.GetConfig().Benchmark.K
+	if keys <= 0 {
+		keys = 1000
+	}
+	var targets []identity.NodeID
+	for _, id := range s.ids {
+		// clients do not send requests to silent Byzantine nodes
+		if config.GetConfig().HasStrategy(id, "silence") {
+			continue
+		}
+		targets = append(targets, id)
+	}
+	interval := time.Second / time.Duration(rate)
+	for at := time.Duration(0); at < until; at += interval {
+		id := targets[s.Rand().Intn(len(targets))]
+		s.Submit(at, id, db.Key(s.Rand().Intn(keys)))
+	}
+}
+
+// Run runs the simulation for d of virtual time with the configured workload,
+// it returns an error if the honest replicas violate the safety or the liveness invariants
+func (s *Simulator) Run(d time.Duration) error {
+	start := s.Elapsed()
+	s.workload(d)
+	s.Scheduler.Run(start + d)
+	log.Infof("[sim] seed %v, %v of virtual time, %v events, digest: %x", config.GetConfig().Simulation.Seed, d, s.events, s.Digest())
+	for _, g := range s.checker.Gaps(s.Now()) {
+		log.Infof("[sim] %v", g)
+	}
+	return s.checker.Check(s.Now())
+}
+
+// Checker returns the invariant checker of the honest replicas
+func (s *Simulator) Checker() *checker.Checker {
+	return s.checker
+}
+
+// Replica returns the replica of the id
+func (s *Simulator) Replica(id identity.NodeID) *replica.Replica {
+	return s.replicas[id]
+}
+
+// Commits returns the blocks committed by the replica in the order of commit
+func (s *Simulator) Commits(id identity.NodeID) []Commit {
+	return s.commits[id]
+}
+
+// Digest returns a hash of every event of the execution so far, two runs with the same seed have the same digest
+func (s *Simulator) Digest() uint64 {
+	return s.digest.Sum64()
+}
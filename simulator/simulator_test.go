@@ -0,0 +1,188 @@
+package simulator
+
+import (
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/gitferry/bamboo/adversary"
+	"github.com/gitferry/bamboo/checker"
+	"github.com/gitferry/bamboo/config"
+	"github.com/gitferry/bamboo/crypto"
+	"github.com/gitferry/bamboo/identity"
+	"github.com/gitferry/bamboo/socket"
+	"github.com/gitferry/bamboo/transport"
+)
+
+// loadTestConfig loads a configuration of 4 replicas through a config file since the number of nodes is set by Load
+func loadTestConfig(t *testing.T, seed int64) {
+	c := config.MakeDefaultConfig()
+	c.Addrs = make(map[identity.NodeID]string)
+	c.HTTPAddrs = make(map[identity.NodeID]string)
+	for i := 1; i <= 4; i++ {
+		id := identity.NewNodeID(i)
+		c.Addrs[id] = "chan://sim-" + string(id)
+		c.HTTPAddrs[id] = "http://sim-" + string(id)
+	}
+	c.Signer = crypto.BLS_BLS12381
+	c.Master = "0"
+	c.Timeout = 100
+	c.BSize = 10
+	c.MemSize = 1000
+	c.Simulation.Seed = seed
+	c.Simulation.Rate = 50
+	c.Simulation.Latency = config.LatencyConfig{Distribution: socket.UNIFORM, Min: 1, Max: 10}
+	data, err := json.Marshal(c)
+	require.NoError(t, err)
+	dir, err := ioutil.TempDir("", "simulator")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	file := filepath.Join(dir, "config.json")
+	require.NoError(t, ioutil.WriteFile(file, data, 0644))
+	require.NoError(t, flag.Set("config", file))
+	config.Configuration = config.Config{}
+	config.Configuration.Load()
+	crypto.SetKeysWith(4, crypto.BLS_BLS12381)
+}
+
+func run(t *testing.T, alg string, seed int64) *Simulator {
+	return runFor(t, alg, seed, time.Second)
+}
+
+func runFor(t *testing.T, alg string, seed int64, d time.Duration) *Simulator {
+	loadTestConfig(t, seed)
+	s := NewSimulator(alg)
+	require.NoError(t, s.Run(d))
+	return s
+}
+
+// a Byzantine replica cannot break the safety of the honest ones
+func TestSimulator_Byzantine(t *testing.T) {
+	for _, strategy := range []string{"fork", "silence"} {
+		loadTestConfig(t, 4)
+		config.Configuration.ByzNo = 1
+		config.Configuration.Strategy = strategy
+		s := NewSimulator("hotstuff")
+		require.NoError(t, s.Run(time.Second), strategy)
+		require.NotEmpty(t, s.Checker().Log(identity.NewNodeID(2)), strategy)
+	}
+}
+
+// the strategies of the adversary cannot break the safety of the honest replicas
+func TestSimulator_Adversary(t *testing.T) {
+	runs := []struct {
+		alg        string
+		strategies []string
+	}{
+		{"hotstuff", []string{adversary.EQUIVOCATE, adversary.DOUBLE_VOTE}},
+		{"hotstuff", []string{adversary.WITHHOLD, adversary.SELECTIVE_TMO}},
+		{"hotstuff", []string{adversary.STALE_QC}},
+		{"streamlet", []string{adversary.EQUIVOCATE, adversary.DOUBLE_VOTE}},
+		{"lbft", []string{adversary.STALE_QC, adversary.SELECTIVE_TMO}},
+	}
+	for _, run := range runs {
+		loadTestConfig(t, 5)
+		config.Configuration.Byzantine = map[identity.NodeID][]string{identity.NewNodeID(1): run.strategies}
+		s := NewSimulator(run.alg)
+		require.NoError(t, s.Run(time.Second), "%v %v", run.alg, run.strategies)
+		require.NotEmpty(t, s.Checker().Log(identity.NewNodeID(2)), "%v %v", run.alg, run.strategies)
+	}
+}
+
+// the replicas inject the faults of the timeline in virtual time
+func TestSimulator_Faults(t *testing.T) {
+	loadTestConfig(t, 6)
+	config.Configuration.Faults = []config.Fault{{At: 1, Action: "partition", Nodes: []identity.NodeID{"1", "2"}, Duration: 1}}
+	s := NewSimulator("hotstuff")
+	require.NoError(t, s.Run(3*time.Second))
+	var before, during, after int
+	for _, c := range s.Commits("3") {
+		switch {
+		case c.At < time.Second:
+			before++
+		case c.At < 2*time.Second:
+			during++
+		default:
+			after++
+		}
+	}
+	require.NotZero(t, before)
+	require.Zero(t, during)
+	require.NotZero(t, after)
+
+	loadTestConfig(t, 6)
+	config.Configuration.Faults = []config.Fault{{View: 5, Action: "crash", Nodes: []identity.NodeID{"4"}}}
+	s = NewSimulator("hotstuff")
+	require.NoError(t, s.Run(3*time.Second))
+	require.Empty(t, s.Commits("4"))
+	require.NotEmpty(t, s.Commits("3"))
+}
+
+// the replicas reach the same commits whatever the codec of the messages
+func TestSimulator_Codec(t *testing.T) {
+	defer func() { *transport.CodecName = transport.GOB }()
+	gob := run(t, "hotstuff", 7)
+	for _, codec := range []string{transport.JSON, transport.BINARY} {
+		loadTestConfig(t, 7)
+		*transport.CodecName = codec
+		s := NewSimulator("hotstuff")
+		require.NoError(t, s.Run(time.Second), codec)
+		require.NotEmpty(t, s.Commits("1"), codec)
+		require.Equal(t, gob.Commits("1"), s.Commits("1"), codec)
+	}
+}
+
+// the same seed replays the same execution of every protocol,
+// only hotstuff is replayed in the short mode and under the race detector
+func TestSimulator_Replay(t *testing.T) {
+	algs := []string{"hotstuff", "tchs", "streamlet", "lbft", "fasthotstuff", "jolteon", "sft"}
+	if testing.Short() || race {
+		algs = algs[:1]
+	}
+	for _, alg := range algs {
+		a := runFor(t, alg, 1, 500*time.Millisecond)
+		b := runFor(t, alg, 1, 500*time.Millisecond)
+		require.Equal(t, a.Digest(), b.Digest(), alg)
+		for _, id := range a.ids {
+			require.NotEmpty(t, a.Commits(id), "%v %v", alg, id)
+			require.Equal(t, a.Commits(id), b.Commits(id), "%v %v", alg, id)
+		}
+	}
+	// another seed is another execution
+	a := runFor(t, "hotstuff", 1, 500*time.Millisecond)
+	c := runFor(t, "hotstuff", 2, 500*time.Millisecond)
+	require.NotEqual(t, a.Digest(), c.Digest())
+}
+
+// the checker catches a replica committing a block that the others did not
+func TestSimulator_Violation(t *testing.T) {
+	s := run(t, "streamlet", 3)
+	id := identity.NewNodeID(1)
+	last := s.Commits(id)[len(s.Commits(id))-1]
+	s.Checker().Record(id, checker.Entry{View: last.View + 1, ID: crypto.Identifier{1}, PrevID: crypto.Identifier{2}, At: s.Now()})
+	require.Error(t, s.Checker().Check(s.Now()))
+}
+
+func TestScheduler_Order(t *testing.T) {
+	s := NewScheduler(1)
+	var order []int
+	s.After(2*time.Millisecond, func() { order = append(order, 3) })
+	s.After(time.Millisecond, func() { order = append(order, 1) })
+	e := s.After(time.Millisecond, func() { order = append(order, 0) })
+	s.After(time.Millisecond, func() {
+		order = append(order, 2)
+		s.After(0, func() { order = append(order, 4) })
+	})
+	e.cancelled = true
+	require.Equal(t, 3, s.Run(time.Millisecond))
+	require.Equal(t, []int{1, 2, 4}, order)
+	s.Run(time.Second)
+	require.Equal(t, []int{1, 2, 4, 3}, order)
+	require.Equal(t, time.Second, s.Elapsed())
+}
@@ -0,0 +1,81 @@
+package socket
+
+import (
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/gitferry/bamboo/config"
+	"github.com/gitferry/bamboo/log"
+)
+
+// latency distributions
+const (
+	CONSTANT    = "constant"
+	UNIFORM     = "uniform"
+	NORMAL      = "normal"
+	EXPONENTIAL = "exponential"
+)
+
+// Latency is the distribution of the one-way delay of a link
+type Latency interface {
+	// Sample draws a delay from the source
+	Sample(r *rand.Rand) time.Duration
+}
+
+// NewLatency creates the latency distribution of the configuration
+func NewLatency(c config.LatencyConfig) Latency {
+	switch c.Distribution {
+	case UNIFORM:
+		return &uniform{min: c.Min, max: c.Max}
+	case NORMAL:
+		return &normal{mean: c.Mean, std: c.Std}
+	case EXPONENTIAL:
+		return &exponential{mean: c.Mean}
+	case CONSTANT, "":
+		return &constant{delay: c.Mean}
+	default:
+		log.Fatalf("unknown latency distribution %v", c.Distribution)
+		return nil
+	}
+}
+
+func ms(d float64) time.Duration {
+	return time.Duration(d * float64(time.Millisecond))
+}
+
+// constant delays every message by the same time
+type constant struct {
+	delay float64
+}
+
+func (c *constant) Sample(r *rand.Rand) time.Duration {
+	return ms(c.delay)
+}
+
+// uniform draws delays uniformly from [min, max)
+type uniform struct {
+	min, max float64
+}
+
+func (u *uniform) Sample(r *rand.Rand) time.Duration {
+	return ms(u.min + r.Float64()*(u.max-u.min))
+}
+
+// normal draws delays from a normal distribution truncated at zero
+type normal struct {
+	mean, std float64
+}
+
+func (n *normal) Sample(r *rand.Rand) time.Duration {
+	return ms(math.Max(0, n.mean+r.NormFloat64()*n.std))
+}
+
+// exponential draws delays from an exponential distribution, which models a long tail
+type exponential struct {
+	mean float64
+}
+
+func (e *exponential) Sample(r *rand.Rand) time.Duration {
+	return ms(r.ExpFloat64() * e.mean)
+}
@@ -5,6 +5,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/gitferry/bamboo/config"
 	"github.com/gitferry/bamboo/identity"
 	"github.com/gitferry/bamboo/log"
 	"github.com/gitferry/bamboo/transport"
@@ -33,6 +34,27 @@ type Socket interface {
 	Slow(id identity.NodeID, d int, t int)      // delays every message send to NodeID for d ms and last for t seconds
 	Flaky(id identity.NodeID, p float64, t int) // drop message by chance p for t seconds
 	Crash(t int)                                // node crash for t seconds
+
+	// Partition cuts the links between the groups of nodes for t seconds, for ever if t is not positive,
+	// the nodes in no group form one more group, and only the messages from a group to the groups after it
+	// are dropped if the partition is not symmetric
+	Partition(t int, symmetric bool, groups ...[]identity.NodeID)
+	// Heal removes the partition
+	Heal()
+
+	// Connections returns the state of the connections to the peers
+	Connections() map[identity.NodeID]transport.State
+}
+
+// fault is a fault injected into the link to a peer until a time
+type fault struct {
+	until time.Time
+	delay time.Duration // for slow links
+	p     float64       // for flaky links
+}
+
+func (f fault) active() bool {
+	return time.Now().Before(f.until)
 }
 
 type socket struct {
@@ -40,27 +62,50 @@ type socket struct {
 	addresses map[identity.NodeID]string
 	nodes     map[identity.NodeID]transport.Transport
 
-	crash bool
-	drop  map[identity.NodeID]bool
-	slow  map[identity.NodeID]int
-	flaky map[identity.NodeID]float64
+	crash     fault
+	drop      map[identity.NodeID]fault
+	slow      map[identity.NodeID]fault
+	flaky     map[identity.NodeID]fault
+	partition map[identity.NodeID]fault
 
-	lock sync.RWMutex // locking map nodes
+	retry map[identity.NodeID]backoff // peers that could not be dialed
+	wan   *wan                        // emulated links, nil if there is none
+
+	lock sync.RWMutex // locking map nodes, retry and the faults
+}
+
+// backoff delays dialing a peer again after a failure
+type backoff struct {
+	at      time.Time
+	backoff time.Duration
+	err     error
 }
 
+const (
+	minBackoff = 50 * time.Millisecond
+	maxBackoff = 5 * time.Second
+)
+
 // NewSocket return Socket interface instance given self NodeID, node list, transport and codec name
 func NewSocket(id identity.NodeID, addrs map[identity.NodeID]string) Socket {
 	socket := &socket{
 		id:        id,
 		addresses: addrs,
 		nodes:     make(map[identity.NodeID]transport.Transport),
-		crash:     false,
-		drop:      make(map[identity.NodeID]bool),
-		slow:      make(map[identity.NodeID]int),
-		flaky:     make(map[identity.NodeID]float64),
+		drop:      make(map[identity.NodeID]fault),
+		slow:      make(map[identity.NodeID]fault),
+		flaky:     make(map[identity.NodeID]fault),
+		partition: make(map[identity.NodeID]fault),
+		retry:     make(map[identity.NodeID]backoff),
 	}
 
-	socket.nodes[id] = transport.NewTransport(addrs[id])
+	ids := make([]identity.NodeID, 0, len(addrs))
+	for peer := range addrs {
+		ids = append(ids, peer)
+	}
+	socket.wan = newWAN(id, ids, config.GetConfig())
+
+	socket.nodes[id] = transport.NewPeerTransport(addrs[id], id, id)
 	socket.nodes[id].Listen()
 
 	return socket
@@ -69,59 +114,34 @@ func NewSocket(id identity.NodeID, addrs map[identity.NodeID]string) Socket {
 func (s *socket) Send(to identity.NodeID, m interface{}) {
 	//log.Debugf("node %s send message %+v to %v", s.id, m, to)
 
-	if s.crash {
-		return
-	}
-
-	if s.drop[to] {
+	s.lock.RLock()
+	crash, drop, partition := s.crash.active(), s.drop[to].active(), s.partition[to].active()
+	flaky, slow := s.flaky[to], s.slow[to]
+	s.lock.RUnlock()
+	if crash || drop || partition {
 		return
 	}
 
-	if p, ok := s.flaky[to]; ok && p > 0 {
-		if rand.Float64() < p {
+	if flaky.active() && flaky.p > 0 {
+		if rand.Float64() < flaky.p {
 			return
 		}
 	}
 
-	s.lock.RLock()
-	t, exists := s.nodes[to]
-	s.lock.RUnlock()
-	if !exists {
-		s.lock.RLock()
-		address, ok := s.addresses[to]
-		s.lock.RUnlock()
-		if !ok {
-			log.Errorf("socket does not have address of node %s", to)
-			return
-		}
-		t = transport.NewTransport(address)
-		err := utils.Retry(t.Dial, 100, time.Duration(50)*time.Millisecond)
-		if err != nil {
-			panic(err)
-		}
-		s.lock.Lock()
-		s.nodes[to] = t
-		s.lock.Unlock()
-	}
-
-	// add simulated transmission delay
-	//if config.GetConfig().Delay != 0 {
-	//	delay := config.GetConfig().Delay
-	//	err := config.GetConfig().DErr
-	//	rand.Seed(time.Now().UnixNano())
-	//	max := delay + err
-	//	min := delay - err
-	//	randDelay := time.Duration(rand.Intn(max-min+1)+min) * time.Millisecond
-	//	timer := time.NewTimer(randDelay)
-	//	go func() {
-	//		<-timer.C
-	//		t.Send(m)
-	//	}()
-	//	return
-	//
-	//}
-	if delay, ok := s.slow[to]; ok && delay > 0 {
-		timer := time.NewTimer(time.Duration(delay) * time.Millisecond)
+	t := s.transport(to)
+	if t == nil {
+		return
+	}
+
+	var extra time.Duration
+	if slow.active() {
+		extra = slow.delay
+	}
+	if s.wan != nil && s.wan.send(t, to, m, extra) {
+		return
+	}
+	if extra > 0 {
+		timer := time.NewTimer(extra)
 		go func() {
 			<-timer.C
 			t.Send(m)
@@ -133,13 +153,86 @@ func (s *socket) Send(to identity.NodeID, m interface{}) {
 	//log.Debugf("[%v] message %v is sent to %v", s.id, m, to)
 }
 
+// transport returns the transport to the peer, dialed on the first message,
+// the messages are dropped until a peer that cannot be dialed is retried after a backoff
+func (s *socket) transport(to identity.NodeID) transport.Transport {
+	s.lock.RLock()
+	t, exists := s.nodes[to]
+	address, ok := s.addresses[to]
+	retry := s.retry[to]
+	s.lock.RUnlock()
+	if exists {
+		return t
+	}
+	if !ok {
+		log.Errorf("socket does not have address of node %s", to)
+		return nil
+	}
+	if time.Now().Before(retry.at) {
+		return nil
+	}
+
+	t = transport.NewPeerTransport(address, s.id, to)
+	err := utils.Retry(t.Dial, 10, time.Duration(50)*time.Millisecond)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if err != nil {
+		retry := s.retry[to]
+		retry.backoff *= 2
+		if retry.backoff < minBackoff {
+			retry.backoff = minBackoff
+		}
+		if retry.backoff > maxBackoff {
+			retry.backoff = maxBackoff
+		}
+		retry.at = time.Now().Add(retry.backoff)
+		retry.err = err
+		s.retry[to] = retry
+		log.Errorf("[%v] cannot dial node %v, retrying in %v: %v", s.id, to, retry.backoff, err)
+		return nil
+	}
+	// another message dialed the peer meanwhile
+	if existing, exists := s.nodes[to]; exists {
+		t.Close()
+		return existing
+	}
+	delete(s.retry, to)
+	s.nodes[to] = t
+	return t
+}
+
+// Connections returns the state of the connections to the peers, the ones never dialed are connecting
+func (s *socket) Connections() map[identity.NodeID]transport.State {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	states := make(map[identity.NodeID]transport.State)
+	for id := range s.addresses {
+		if id == s.id {
+			continue
+		}
+		if t, ok := s.nodes[id]; ok {
+			states[id] = t.State()
+			continue
+		}
+		state := transport.State{Status: transport.CONNECTING}
+		if retry, ok := s.retry[id]; ok {
+			state.Error = retry.err.Error()
+		}
+		states[id] = state
+	}
+	return states
+}
+
 func (s *socket) Recv() interface{} {
 	s.lock.RLock()
 	t := s.nodes[s.id]
 	s.lock.RUnlock()
 	for {
 		m := t.Recv()
-		if !s.crash {
+		s.lock.RLock()
+		crash := s.crash.active()
+		s.lock.RUnlock()
+		if !crash {
 			return m
 		}
 	}
@@ -171,45 +264,96 @@ func (s *socket) Broadcast(m interface{}) {
 }
 
 func (s *socket) Close() {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if s.wan != nil {
+		s.wan.close()
+	}
 	for _, t := range s.nodes {
 		t.Close()
 	}
 }
 
 func (s *socket) Drop(id identity.NodeID, t int) {
-	s.drop[id] = true
-	timer := time.NewTimer(time.Duration(t) * time.Second)
-	go func() {
-		<-timer.C
-		s.drop[id] = false
-	}()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.drop[id] = fault{until: deadline(t)}
 }
 
 func (s *socket) Slow(id identity.NodeID, delay int, t int) {
-	s.slow[id] = delay
-	timer := time.NewTimer(time.Duration(t) * time.Second)
-	go func() {
-		<-timer.C
-		s.slow[id] = 0
-	}()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.slow[id] = fault{until: deadline(t), delay: time.Duration(delay) * time.Millisecond}
 }
 
 func (s *socket) Flaky(id identity.NodeID, p float64, t int) {
-	s.flaky[id] = p
-	timer := time.NewTimer(time.Duration(t) * time.Second)
-	go func() {
-		<-timer.C
-		s.flaky[id] = 0
-	}()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.flaky[id] = fault{until: deadline(t), p: p}
 }
 
+// Crash crashes the node for t seconds, for ever if t is not positive
 func (s *socket) Crash(t int) {
-	s.crash = true
-	if t > 0 {
-		timer := time.NewTimer(time.Duration(t) * time.Second)
-		go func() {
-			<-timer.C
-			s.crash = false
-		}()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if t <= 0 {
+		s.crash = fault{until: forever}
+		return
+	}
+	s.crash = fault{until: deadline(t)}
+}
+
+func (s *socket) Partition(t int, symmetric bool, groups ...[]identity.NodeID) {
+	ids := make([]identity.NodeID, 0, len(s.addresses))
+	for id := range s.addresses {
+		ids = append(ids, id)
+	}
+	cut := Partitioned(s.id, ids, symmetric, groups...)
+	log.Infof("node %v is partitioned from %v for %v seconds", s.id, cut, t)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	until := deadline(t)
+	if t <= 0 {
+		until = forever
+	}
+	s.partition = make(map[identity.NodeID]fault)
+	for _, id := range cut {
+		s.partition[id] = fault{until: until}
+	}
+}
+
+func (s *socket) Heal() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.partition = make(map[identity.NodeID]fault)
+}
+
+// forever is the end of the faults that never end
+var forever = time.Unix(1<<40, 0)
+
+// deadline returns the time in t seconds
+func deadline(t int) time.Time {
+	return time.Now().Add(time.Duration(t) * time.Second)
+}
+
+// Partitioned returns the nodes among the ids that the node cannot send to in the partition of the groups,
+// the ids in no group form one more group
+func Partitioned(id identity.NodeID, ids []identity.NodeID, symmetric bool, groups ...[]identity.NodeID) []identity.NodeID {
+	group := func(id identity.NodeID) int {
+		for i, g := range groups {
+			for _, member := range g {
+				if member == id {
+					return i
+				}
+			}
+		}
+		return len(groups)
+	}
+	var cut []identity.NodeID
+	for _, peer := range ids {
+		if g, p := group(id), group(peer); g != p && (symmetric || g < p) {
+			cut = append(cut, peer)
+		}
 	}
+	return cut
 }
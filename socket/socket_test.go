@@ -0,0 +1,159 @@
+package socket
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/gitferry/bamboo/identity"
+	"github.com/gitferry/bamboo/transport"
+)
+
+// newSockets creates the sockets of n nodes and the channels of the messages they receive
+func newSockets(n int) (map[identity.NodeID]Socket, map[identity.NodeID]chan interface{}) {
+	addrs := make(map[identity.NodeID]string)
+	for i := 1; i <= n; i++ {
+		id := identity.NewNodeID(i)
+		addrs[id] = "chan://partition-" + string(id)
+	}
+	sockets := make(map[identity.NodeID]Socket)
+	received := make(map[identity.NodeID]chan interface{})
+	for id := range addrs {
+		s := NewSocket(id, addrs)
+		c := make(chan interface{}, 10)
+		go func() {
+			for {
+				c <- s.Recv()
+			}
+		}()
+		sockets[id] = s
+		received[id] = c
+	}
+	return sockets, received
+}
+
+// recv returns the message received in time, nil if there is none
+func recv(c chan interface{}) interface{} {
+	select {
+	case m := <-c:
+		return m
+	case <-time.After(100 * time.Millisecond):
+		return nil
+	}
+}
+
+func TestPartitioned(t *testing.T) {
+	ids := []identity.NodeID{"1", "2", "3", "4", "5"}
+	require.ElementsMatch(t, []identity.NodeID{"3", "4", "5"}, Partitioned("1", ids, true, []identity.NodeID{"1", "2"}))
+	require.ElementsMatch(t, []identity.NodeID{"1", "2", "5"}, Partitioned("3", ids, true, []identity.NodeID{"1", "2"}, []identity.NodeID{"3", "4"}))
+	require.ElementsMatch(t, []identity.NodeID{"5"}, Partitioned("3", ids, false, []identity.NodeID{"1", "2"}, []identity.NodeID{"3", "4"}))
+	require.Empty(t, Partitioned("5", ids, false, []identity.NodeID{"1", "2"}, []identity.NodeID{"3", "4"}))
+}
+
+func TestPartition(t *testing.T) {
+	sockets, received := newSockets(3)
+	for _, s := range sockets {
+		s.Partition(0, false, []identity.NodeID{"1"})
+	}
+	// the partition only drops the messages from 1
+	sockets["1"].Send("2", 1)
+	require.Nil(t, recv(received["2"]))
+	sockets["2"].Send("1", 2)
+	require.Equal(t, 2, recv(received["1"]))
+
+	for _, s := range sockets {
+		s.Heal()
+	}
+	sockets["1"].Send("2", 3)
+	require.Equal(t, 3, recv(received["2"]))
+
+	// the partition is safe under concurrent sends and heals on schedule
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sockets["3"].Partition(1, true, []identity.NodeID{"3"})
+			sockets["3"].Send("2", 4)
+			sockets["3"].Drop("1", 1)
+		}()
+	}
+	wg.Wait()
+	require.Nil(t, recv(received["2"]))
+	time.Sleep(time.Second)
+	sockets["3"].Send("2", 5)
+	require.Equal(t, 5, recv(received["2"]))
+}
+
+func TestConnections(t *testing.T) {
+	sockets, received := newSockets(2)
+	addrs := map[identity.NodeID]string{"1": "chan://partition-1", "2": "chan://partition-2", "3": "chan://connections-3"}
+	s := sockets["1"].(*socket)
+	s.addresses = addrs
+
+	// node 3 never listens, the messages to it are dropped instead of failing the sender
+	s.Send("3", 1)
+	s.Send("2", 2)
+	require.Equal(t, 2, recv(received["2"]))
+	connections := s.Connections()
+	require.Len(t, connections, 2)
+	require.Equal(t, transport.CONNECTED, connections["2"].Status)
+	require.Equal(t, transport.CONNECTING, connections["3"].Status)
+	require.NotEmpty(t, connections["3"].Error)
+}
+
+type count struct {
+	N int
+}
+
+// a peer that is down holds up neither the broadcasts nor the messages to the other peers
+func TestBroadcast_PeerDown(t *testing.T) {
+	transport.Register(count{})
+	addrs := map[identity.NodeID]string{
+		"1": "tcp://127.0.0.1:17611",
+		"2": "tcp://127.0.0.1:17612",
+		"3": "tcp://127.0.0.1:17613",
+		"4": "tcp://127.0.0.1:17614",
+	}
+	// node 4 is down, it never listens
+	sockets := make(map[identity.NodeID]Socket)
+	for _, id := range []identity.NodeID{"1", "2", "3"} {
+		sockets[id] = NewSocket(id, addrs)
+		defer sockets[id].Close()
+	}
+	// more messages than the queue to node 4 holds
+	total := 3 * 10240
+	for _, id := range []identity.NodeID{"2", "3"} {
+		received := make(chan struct{})
+		go func(s Socket) {
+			for {
+				if s.Recv().(count).N == total-1 {
+					close(received)
+					return
+				}
+			}
+		}(sockets[id])
+		defer func(id identity.NodeID) {
+			select {
+			case <-received:
+			case <-time.After(10 * time.Second):
+				t.Errorf("node %v does not receive the broadcasts", id)
+			}
+		}(id)
+	}
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < total; i++ {
+			sockets["1"].Broadcast(count{N: i})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("the broadcasts are held up by the peer that is down")
+	}
+	require.NotZero(t, sockets["1"].Connections()["4"].Dropped)
+}
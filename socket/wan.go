@@ -0,0 +1,149 @@
+package socket
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/gitferry/bamboo/config"
+	"github.com/gitferry/bamboo/identity"
+	"github.com/gitferry/bamboo/log"
+	"github.com/gitferry/bamboo/transport"
+)
+
+// wan emulates the latency and the bandwidth of the links from a node to its peers
+type wan struct {
+	links map[identity.NodeID]*link
+	rand  *rand.Rand
+	done  chan struct{}
+	lock  sync.Mutex // locking rand and the links
+}
+
+// link is the emulated link to a peer, its messages are transmitted one after the other at the bandwidth
+// and arrive in order after the latency, as on a tcp connection
+type link struct {
+	latency   Latency   // nil if the link has no latency
+	bandwidth float64   // bytes per second, unlimited if 0
+	free      time.Time // end of the transmission of the last message
+	last      time.Time // arrival of the last message
+	queue     chan delayed
+}
+
+// delayed is a message held by a link until it arrives
+type delayed struct {
+	at time.Time
+	t  transport.Transport
+	m  interface{}
+}
+
+// newWAN creates the emulated links of the node to the peers of the config, nil if no link is emulated,
+// delay and derr of the config give the latency of the links that the wan section leaves out
+func newWAN(id identity.NodeID, ids []identity.NodeID, c config.Config) *wan {
+	region := func(id identity.NodeID) string {
+		if r, ok := c.WAN.Regions[id]; ok {
+			return r
+		}
+		return string(id)
+	}
+	w := &wan{
+		links: make(map[identity.NodeID]*link),
+		rand:  rand.New(rand.NewSource(time.Now().UnixNano())),
+		done:  make(chan struct{}),
+	}
+	for _, peer := range ids {
+		if peer == id {
+			continue
+		}
+		from, to := region(id), region(peer)
+		l := &link{queue: make(chan delayed, 10240)}
+		if c.Delay > 0 {
+			l.latency = NewLatency(config.LatencyConfig{Distribution: UNIFORM, Min: float64(c.Delay - c.DErr), Max: float64(c.Delay + c.DErr)})
+		}
+		for _, key := range keys(from, to) {
+			if lc, ok := c.WAN.Latency[key]; ok {
+				l.latency = NewLatency(lc)
+				break
+			}
+		}
+		for _, key := range keys(from, to) {
+			if mbps, ok := c.WAN.Bandwidth[key]; ok {
+				l.bandwidth = mbps * 1e6 / 8
+				break
+			}
+		}
+		if l.latency == nil && l.bandwidth == 0 {
+			continue
+		}
+		w.links[peer] = l
+		go w.deliver(l)
+	}
+	if len(w.links) == 0 {
+		return nil
+	}
+	log.Infof("[%v] emulates the wan links to %v nodes", id, len(w.links))
+	return w
+}
+
+// keys returns the keys of the link between the regions by precedence, the link, its reverse and every link
+func keys(from, to string) []string {
+	return []string{from + "-" + to, to + "-" + from, "*"}
+}
+
+// send holds the message on the link to the peer until it arrives, plus the extra delay,
+// it returns false if the link is not emulated
+func (w *wan) send(t transport.Transport, to identity.NodeID, m interface{}, extra time.Duration) bool {
+	l, ok := w.links[to]
+	if !ok {
+		return false
+	}
+	size := 0
+	if l.bandwidth > 0 {
+		size = transport.FrameSize(m)
+	}
+	w.lock.Lock()
+	at := w.schedule(l, size, time.Now()).Add(extra)
+	w.lock.Unlock()
+	select {
+	case l.queue <- delayed{at: at, t: t, m: m}:
+	case <-w.done:
+	}
+	return true
+}
+
+// schedule returns the arrival time of a message of the size sent on the link at the time
+func (w *wan) schedule(l *link, size int, now time.Time) time.Time {
+	start := now
+	if l.free.After(start) {
+		start = l.free
+	}
+	if l.bandwidth > 0 {
+		start = start.Add(time.Duration(float64(size) / l.bandwidth * float64(time.Second)))
+	}
+	l.free = start
+	at := start
+	if l.latency != nil {
+		at = at.Add(l.latency.Sample(w.rand))
+	}
+	if at.Before(l.last) {
+		at = l.last
+	}
+	l.last = at
+	return at
+}
+
+// deliver hands the messages of the link to the transport when they arrive
+func (w *wan) deliver(l *link) {
+	for {
+		select {
+		case <-w.done:
+			return
+		case d := <-l.queue:
+			time.Sleep(time.Until(d.at))
+			d.t.Send(d.m)
+		}
+	}
+}
+
+func (w *wan) close() {
+	close(w.done)
+}
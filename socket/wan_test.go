@@ -0,0 +1,76 @@
+package socket
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/gitferry/bamboo/config"
+	"github.com/gitferry/bamboo/identity"
+)
+
+func TestLatency(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	u := NewLatency(config.LatencyConfig{Distribution: UNIFORM, Min: 2, Max: 4})
+	n := NewLatency(config.LatencyConfig{Distribution: NORMAL, Mean: 1, Std: 5})
+	for i := 0; i < 100; i++ {
+		d := u.Sample(r)
+		require.True(t, d >= 2*time.Millisecond && d < 4*time.Millisecond)
+		require.True(t, n.Sample(r) >= 0)
+	}
+	require.Equal(t, 5*time.Millisecond, NewLatency(config.LatencyConfig{Mean: 5}).Sample(r))
+}
+
+func TestWAN(t *testing.T) {
+	ids := []identity.NodeID{"1", "2", "3", "4"}
+	var c config.Config
+	require.Nil(t, newWAN("1", ids, c))
+
+	c.WAN = config.WANConfig{
+		Regions: map[identity.NodeID]string{"1": "us", "2": "us", "3": "eu"},
+		Latency: map[string]config.LatencyConfig{
+			"us-us": {Mean: 1},
+			"us-eu": {Mean: 100},
+		},
+		Bandwidth: map[string]float64{"us-eu": 8},
+	}
+	c.Delay = 50
+	w := newWAN("1", ids, c)
+	defer w.close()
+	now := time.Now()
+	require.Equal(t, now.Add(time.Millisecond), w.schedule(w.links["2"], 1000, now))
+	// 1000 bytes take 1ms at 8 Mbit/s and the second message waits for the first one
+	require.Equal(t, now.Add(101*time.Millisecond), w.schedule(w.links["3"], 1000, now))
+	require.Equal(t, now.Add(102*time.Millisecond), w.schedule(w.links["3"], 1000, now))
+	require.Equal(t, now.Add(1102*time.Millisecond), w.schedule(w.links["3"], 1000000, now))
+	// delay applies to the links left out
+	require.Equal(t, now.Add(50*time.Millisecond), w.schedule(w.links["4"], 1000, now))
+
+	// the reverse link has the same latency
+	r := newWAN("3", ids, c)
+	defer r.close()
+	require.Equal(t, now.Add(101*time.Millisecond), r.schedule(r.links["1"], 1000, now))
+}
+
+// the messages arrive after the latency of the link, in order
+func TestWANSocket(t *testing.T) {
+	defer func() { config.Configuration.WAN = config.WANConfig{} }()
+	config.Configuration.WAN = config.WANConfig{
+		Latency: map[string]config.LatencyConfig{"*": {Distribution: UNIFORM, Min: 100, Max: 200}},
+	}
+	sockets, received := newSockets(2)
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		sockets["1"].Send("2", i)
+	}
+	for i := 0; i < 10; i++ {
+		m := <-received["2"]
+		require.Equal(t, i, m)
+		if i == 0 {
+			require.True(t, time.Since(start) >= 100*time.Millisecond)
+		}
+	}
+	require.True(t, time.Since(start) < time.Second)
+}
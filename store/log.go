@@ -0,0 +1,184 @@
+package store
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/gitferry/bamboo/blockchain"
+	"github.com/gitferry/bamboo/crypto"
+)
+
+const (
+	blockFile = "blocks.log"
+	stateFile = "safety.state"
+)
+
+// logStore appends committed blocks to a log file as length-prefixed gob records
+// and keeps an in-memory index from block id to the record offset.
+// The safety state is kept in a separate file which is replaced atomically.
+type logStore struct {
+	dir    string
+	file   *os.File
+	index  map[crypto.Identifier]int64
+	offset int64 // end of the last complete record
+	last   *blockchain.Block
+	mu     sync.RWMutex
+}
+
+// NewLogStore opens (or creates) the log store in dir and rebuilds its index
+func NewLogStore(dir string) (blockchain.Store, error) {
+	err := os.MkdirAll(dir, os.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(filepath.Join(dir, blockFile), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	s := &logStore{
+		dir:   dir,
+		file:  file,
+		index: make(map[crypto.Identifier]int64),
+	}
+	err = s.recover()
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// recover scans the log and truncates a partially written record at its tail
+func (s *logStore) recover() error {
+	var offset int64
+	for {
+		block, size, err := s.readAt(offset)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("corrupted block log at offset %d: %w", offset, err)
+		}
+		s.index[block.ID] = offset
+		s.last = block
+		offset += size
+	}
+	s.offset = offset
+	return s.file.Truncate(offset)
+}
+
+// readAt reads the record at offset and returns the block and the size of the record
+func (s *logStore) readAt(offset int64) (*blockchain.Block, int64, error) {
+	var header [4]byte
+	_, err := s.file.ReadAt(header[:], offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	length := binary.BigEndian.Uint32(header[:])
+	data := make([]byte, length)
+	n, err := s.file.ReadAt(data, offset+4)
+	if err != nil && !(err == io.EOF && n == int(length)) {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, 0, err
+	}
+	block := new(blockchain.Block)
+	err = gob.NewDecoder(bytes.NewReader(data)).Decode(block)
+	if err != nil {
+		return nil, 0, err
+	}
+	return block, int64(length) + 4, nil
+}
+
+func (s *logStore) AppendBlock(block *blockchain.Block) error {
+	var buf bytes.Buffer
+	buf.Write(make([]byte, 4))
+	err := gob.NewEncoder(&buf).Encode(block)
+	if err != nil {
+		return err
+	}
+	record := buf.Bytes()
+	binary.BigEndian.PutUint32(record[:4], uint32(len(record)-4))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.file.WriteAt(record, s.offset)
+	if err != nil {
+		return err
+	}
+	err = s.file.Sync()
+	if err != nil {
+		return err
+	}
+	s.index[block.ID] = s.offset
+	s.offset += int64(len(record))
+	s.last = block
+	return nil
+}
+
+func (s *logStore) GetBlock(id crypto.Identifier) (*blockchain.Block, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	offset, ok := s.index[id]
+	if !ok {
+		return nil, fmt.Errorf("the block is not stored, id: %x", id)
+	}
+	block, _, err := s.readAt(offset)
+	return block, err
+}
+
+func (s *logStore) LastBlock() (*blockchain.Block, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.last, nil
+}
+
+// SaveState writes the state into a temporary file and renames it,
+// so that a crash never leaves a half-written state behind
+func (s *logStore) SaveState(state *blockchain.SafetyState) error {
+	tmp := filepath.Join(s.dir, stateFile+".tmp")
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	err = gob.NewEncoder(file).Encode(state)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(s.dir, stateFile))
+}
+
+func (s *logStore) LoadState() (*blockchain.SafetyState, error) {
+	file, err := os.Open(filepath.Join(s.dir, stateFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	state := new(blockchain.SafetyState)
+	err = gob.NewDecoder(file).Decode(state)
+	if err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+func (s *logStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
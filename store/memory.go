@@ -0,0 +1,66 @@
+package store
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/gitferry/bamboo/blockchain"
+	"github.com/gitferry/bamboo/crypto"
+)
+
+// memStore keeps everything in memory, nothing survives a restart
+type memStore struct {
+	blocks map[crypto.Identifier]*blockchain.Block
+	last   *blockchain.Block
+	state  *blockchain.SafetyState
+	mu     sync.RWMutex
+}
+
+// NewMemStore creates an in-memory store
+func NewMemStore() blockchain.Store {
+	return &memStore{
+		blocks: make(map[crypto.Identifier]*blockchain.Block),
+	}
+}
+
+func (m *memStore) AppendBlock(block *blockchain.Block) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.blocks[block.ID] = block
+	m.last = block
+	return nil
+}
+
+func (m *memStore) GetBlock(id crypto.Identifier) (*blockchain.Block, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	block, ok := m.blocks[id]
+	if !ok {
+		return nil, fmt.Errorf("the block is not stored, id: %x", id)
+	}
+	return block, nil
+}
+
+func (m *memStore) LastBlock() (*blockchain.Block, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.last, nil
+}
+
+func (m *memStore) SaveState(state *blockchain.SafetyState) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := *state
+	m.state = &s
+	return nil
+}
+
+func (m *memStore) LoadState() (*blockchain.SafetyState, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.state, nil
+}
+
+func (m *memStore) Close() error {
+	return nil
+}
@@ -0,0 +1,33 @@
+package store
+
+import (
+	"path/filepath"
+
+	"github.com/gitferry/bamboo/blockchain"
+	"github.com/gitferry/bamboo/config"
+	"github.com/gitferry/bamboo/identity"
+	"github.com/gitferry/bamboo/log"
+)
+
+const (
+	MEMORY = "memory"
+	LOG    = "log"
+)
+
+// NewStore creates the block store of the node according to the configuration
+func NewStore(id identity.NodeID) blockchain.Store {
+	switch config.GetConfig().Store {
+	case LOG:
+		dir := filepath.Join(config.GetConfig().StoreDir, string(id))
+		s, err := NewLogStore(dir)
+		if err != nil {
+			log.Fatalf("[%v] cannot open the block store in %v: %v", id, dir, err)
+		}
+		return s
+	case MEMORY, "":
+		return NewMemStore()
+	default:
+		log.Fatalf("unknown store %s", config.GetConfig().Store)
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gitferry/bamboo/blockchain"
+	"github.com/gitferry/bamboo/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func makeChain(n int) []*blockchain.Block {
+	var blocks []*blockchain.Block
+	prev := &blockchain.QC{View: 0, BlockID: utils.IdentifierFixture()}
+	for i := 1; i <= n; i++ {
+		b := &blockchain.Block{
+			View:   prev.View + 1,
+			QC:     prev,
+			PrevID: prev.BlockID,
+			ID:     utils.IdentifierFixture(),
+		}
+		blocks = append(blocks, b)
+		prev = &blockchain.QC{View: b.View, BlockID: b.ID}
+	}
+	return blocks
+}
+
+// tempDir creates a directory removed at the end of the test
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "store")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+// blocks and state survive reopening the store
+func TestLogStore_Reopen(t *testing.T) {
+	dir := tempDir(t)
+	s, err := NewLogStore(dir)
+	require.NoError(t, err)
+	blocks := makeChain(3)
+	for _, b := range blocks {
+		require.NoError(t, s.AppendBlock(b))
+	}
+	state := &blockchain.SafetyState{LastVotedView: 5, PreferredView: 3, HighQC: blocks[2].QC}
+	require.NoError(t, s.SaveState(state))
+	require.NoError(t, s.Close())
+
+	s, err = NewLogStore(dir)
+	require.NoError(t, err)
+	last, err := s.LastBlock()
+	require.NoError(t, err)
+	require.Equal(t, blocks[2].ID, last.ID)
+	b, err := s.GetBlock(blocks[0].ID)
+	require.NoError(t, err)
+	require.Equal(t, blocks[0].View, b.View)
+	loaded, err := s.LoadState()
+	require.NoError(t, err)
+	require.Equal(t, state.LastVotedView, loaded.LastVotedView)
+	require.Equal(t, state.PreferredView, loaded.PreferredView)
+	require.Equal(t, state.HighQC.BlockID, loaded.HighQC.BlockID)
+}
+
+// a partially written record at the tail is dropped
+func TestLogStore_TruncatedTail(t *testing.T) {
+	dir := tempDir(t)
+	s, err := NewLogStore(dir)
+	require.NoError(t, err)
+	blocks := makeChain(2)
+	for _, b := range blocks {
+		require.NoError(t, s.AppendBlock(b))
+	}
+	require.NoError(t, s.Close())
+
+	path := filepath.Join(dir, blockFile)
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	require.NoError(t, os.Truncate(path, info.Size()-3))
+
+	s, err = NewLogStore(dir)
+	require.NoError(t, err)
+	last, err := s.LastBlock()
+	require.NoError(t, err)
+	require.Equal(t, blocks[0].ID, last.ID)
+	require.NoError(t, s.AppendBlock(blocks[1]))
+	last, err = s.LastBlock()
+	require.NoError(t, err)
+	require.Equal(t, blocks[1].ID, last.ID)
+}
+
+// the blockchain is recovered from the last committed block
+func TestBlockchain_Recover(t *testing.T) {
+	s := NewMemStore()
+	blocks := makeChain(4)
+	bc, err := blockchain.NewBlockchainWithStore(4, s)
+	require.NoError(t, err)
+	for _, b := range blocks {
+		bc.AddBlock(b)
+	}
+	committed, _, err := bc.CommitBlock(blocks[2].ID, 5)
+	require.NoError(t, err)
+	require.Len(t, committed, 3)
+	last, err := s.LastBlock()
+	require.NoError(t, err)
+	require.Equal(t, blocks[2].ID, last.ID)
+
+	// restart
+	bc, err = blockchain.NewBlockchainWithStore(4, s)
+	require.NoError(t, err)
+	require.True(t, bc.Exists(blocks[2].ID))
+	bc.AddBlock(blocks[3])
+	parent, err := bc.GetParentBlock(blocks[3].ID)
+	require.NoError(t, err)
+	require.Equal(t, blocks[2].ID, parent.ID)
+}
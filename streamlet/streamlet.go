@@ -6,10 +6,12 @@ import (
 	"github.com/gitferry/bamboo/config"
 	"github.com/gitferry/bamboo/crypto"
 	"github.com/gitferry/bamboo/election"
+	"github.com/gitferry/bamboo/identity"
 	"github.com/gitferry/bamboo/log"
 	"github.com/gitferry/bamboo/message"
 	"github.com/gitferry/bamboo/node"
 	"github.com/gitferry/bamboo/pacemaker"
+	"github.com/gitferry/bamboo/store"
 	"github.com/gitferry/bamboo/types"
 )
 
@@ -18,6 +20,8 @@ type Streamlet struct {
 	election.Election
 	pm                     *pacemaker.Pacemaker
 	bc                     *blockchain.BlockChain
+	store                  blockchain.Store
+	lastVotedView          types.View
 	notarizedChain         [][]*blockchain.Block
 	bufferedBlocks         map[crypto.Identifier]*blockchain.Block
 	bufferedQCs            map[crypto.Identifier]*blockchain.QC
@@ -26,6 +30,7 @@ type Streamlet struct {
 	forkedBlocks           chan *blockchain.Block
 	echoedBlock            map[crypto.Identifier]struct{}
 	echoedVote             map[crypto.Identifier]struct{}
+	synchronizer           *blockchain.Synchronizer
 }
 
 // NewStreamlet creates a new Streamlet instance
@@ -41,14 +46,21 @@ func NewStreamlet(
 	sl.pm = pm
 	sl.committedBlocks = committedBlocks
 	sl.forkedBlocks = forkedBlocks
-	sl.bc = blockchain.NewBlockchain(config.GetConfig().N())
+	sl.store = store.NewStore(node.ID())
+	bc, err := blockchain.NewBlockchainWithStore(config.GetConfig().N(), sl.store)
+	if err != nil {
+		log.Fatalf("[%v] cannot create the blockchain: %v", node.ID(), err)
+	}
+	sl.bc = bc
 	sl.bufferedBlocks = make(map[crypto.Identifier]*blockchain.Block)
 	sl.bufferedQCs = make(map[crypto.Identifier]*blockchain.QC)
 	sl.bufferedNotarizedBlock = make(map[crypto.Identifier]*blockchain.QC)
 	sl.notarizedChain = make([][]*blockchain.Block, 0)
 	sl.echoedBlock = make(map[crypto.Identifier]struct{})
 	sl.echoedVote = make(map[crypto.Identifier]struct{})
+	sl.synchronizer = blockchain.NewSynchronizer(config.GetTimer())
 	sl.pm.AdvanceView(0)
+	sl.recoverState()
 	return sl
 }
 
@@ -74,6 +86,7 @@ func (sl *Streamlet) ProcessBlock(block *blockchain.Block) error {
 		// buffer future blocks
 		sl.bufferedBlocks[block.PrevID] = block
 		log.Debugf("[%v] buffer the block for future processing, view: %v, id: %x", sl.ID(), block.View, block.ID)
+		sl.requestBlocks(block.PrevID, int(block.View-curView)+1, block.Proposer)
 		return nil
 	}
 	if !sl.Election.IsLeader(block.Proposer, block.View) {
@@ -98,6 +111,9 @@ func (sl *Streamlet) ProcessBlock(block *blockchain.Block) error {
 		log.Debugf("[%v] buffer the block for future processing, view: %v, id: %x", sl.ID(), block.View, block.ID)
 		return nil
 	}
+	sl.lastVotedView = block.View
+	// the vote must be remembered before it is sent out
+	sl.persistState()
 	vote := blockchain.MakeVote(block.View, sl.ID(), block.ID)
 	// vote to the current leader
 	sl.ProcessVote(vote)
@@ -155,13 +171,15 @@ func (sl *Streamlet) ProcessRemoteTmo(tmo *pacemaker.TMO) {
 	}
 	log.Debugf("[%v] a tc is built for view %v", sl.ID(), tc.View)
 	sl.processTC(tc)
+	// the leader of the next view may have missed some of the timeouts
+	nextLeader := sl.FindLeaderFor(tc.View + 1)
+	if nextLeader != sl.ID() {
+		sl.Send(nextLeader, tc)
+	}
 }
 
 func (sl *Streamlet) ProcessLocalTmo(view types.View) {
-	tmo := &pacemaker.TMO{
-		View:   view,
-		NodeID: sl.ID(),
-	}
+	tmo := pacemaker.MakeTMO(view, sl.ID(), nil)
 	sl.Broadcast(tmo)
 	sl.ProcessRemoteTmo(tmo)
 }
@@ -188,11 +206,17 @@ func (sl *Streamlet) forkChoice() crypto.Identifier {
 	return prevID
 }
 
+// ProcessTC processes a tc received from another replica, the tc has been validated
+func (sl *Streamlet) ProcessTC(tc *pacemaker.TC) {
+	log.Debugf("[%v] is processing a tc for view %v", sl.ID(), tc.View)
+	sl.processTC(tc)
+}
+
 func (sl *Streamlet) processTC(tc *pacemaker.TC) {
 	if tc.View < sl.pm.GetCurView() {
 		return
 	}
-	go sl.pm.AdvanceView(tc.View)
+	sl.pm.AdvanceView(tc.View)
 }
 
 // 1. advance view
@@ -208,6 +232,7 @@ func (sl *Streamlet) processCertificate(qc *blockchain.QC) {
 	if err != nil && qc.View > 1 {
 		log.Debugf("[%v] buffered the QC, view: %v, id: %x", sl.ID(), qc.View, qc.BlockID)
 		sl.bufferedQCs[qc.BlockID] = qc
+		sl.requestBlocks(qc.BlockID, 1, sl.FindLeaderFor(qc.View))
 		return
 	}
 	if qc.Leader != sl.ID() {
@@ -293,6 +318,53 @@ func (sl *Streamlet) updateNotarizedChain(qc *blockchain.QC) error {
 	return fmt.Errorf("the block is not extending the notarized chain")
 }
 
+// ProcessSyncRequest replies the blocks asked by a lagging replica
+func (sl *Streamlet) ProcessSyncRequest(req *blockchain.SyncRequest) {
+	blocks := sl.bc.GetBlocks(req)
+	if len(blocks) == 0 {
+		log.Debugf("[%v] has no block requested by %v", sl.ID(), req.Requester)
+		return
+	}
+	log.Debugf("[%v] is sending %v blocks to %v", sl.ID(), len(blocks), req.Requester)
+	sl.Send(req.Requester, &blockchain.SyncResponse{Responder: sl.ID(), Blocks: blocks})
+}
+
+// ProcessSyncResponse adds the fetched blocks to the chain
+// and then unblocks the buffered qcs and blocks waiting for them
+func (sl *Streamlet) ProcessSyncResponse(resp *blockchain.SyncResponse) {
+	for _, block := range resp.Blocks {
+		sl.synchronizer.Done(block.ID)
+		if sl.bc.Exists(block.ID) {
+			continue
+		}
+		err := sl.bc.AddSyncedBlock(block)
+		if err != nil {
+			log.Warningf("[%v] received an invalid block from %v: %v", sl.ID(), resp.Responder, err)
+			return
+		}
+		log.Debugf("[%v] synced a block from %v, view: %v, id: %x", sl.ID(), resp.Responder, block.View, block.ID)
+		qc, ok := sl.bufferedQCs[block.ID]
+		if ok {
+			delete(sl.bufferedQCs, block.ID)
+			sl.processCertificate(qc)
+		}
+		b, ok := sl.bufferedBlocks[block.ID]
+		if ok {
+			delete(sl.bufferedBlocks, block.ID)
+			_ = sl.ProcessBlock(b)
+		}
+	}
+}
+
+// requestBlocks asks the peer for the block and its ancestors unless they are being fetched
+func (sl *Streamlet) requestBlocks(id crypto.Identifier, count int, peer identity.NodeID) {
+	if peer == sl.ID() || !sl.synchronizer.ShouldRequest(id) {
+		return
+	}
+	log.Debugf("[%v] requests %v blocks from %v, id: %x", sl.ID(), count, peer, id)
+	sl.Send(peer, blockchain.MakeSyncRequest(sl.ID(), id, count))
+}
+
 func (sl *Streamlet) GetChainStatus() string {
 	chainGrowthRate := sl.bc.GetChainGrowth()
 	blockIntervals := sl.bc.GetBlockIntervals()
@@ -303,12 +375,20 @@ func (sl *Streamlet) GetNotarizedHeight() int {
 	return len(sl.notarizedChain)
 }
 
-// 1. get the tail of the longest notarized chain (could be more than one)
-// 2. check if the block is extending one of them
+// 1. vote at most once per view
+// 2. get the tail of the longest notarized chain (could be more than one)
+// 3. check if the block is extending one of them
 func (sl *Streamlet) votingRule(block *blockchain.Block) bool {
+	if block.View <= sl.lastVotedView {
+		return false
+	}
 	if block.View <= 2 {
 		return true
 	}
+	if sl.GetNotarizedHeight() == 0 {
+		// e.g., after a restart, nothing is notarized yet
+		return false
+	}
 	lastBlocks := sl.notarizedChain[sl.GetNotarizedHeight()-1]
 	for _, b := range lastBlocks {
 		if block.PrevID == b.ID {
@@ -348,3 +428,27 @@ func (sl *Streamlet) commitRule() (bool, *blockchain.Block) {
 	}
 	return false, nil
 }
+
+// recoverState restores the safety state saved before a restart
+func (sl *Streamlet) recoverState() {
+	state, err := sl.store.LoadState()
+	if err != nil {
+		log.Fatalf("[%v] cannot load the safety state: %v", sl.ID(), err)
+	}
+	if state == nil {
+		return
+	}
+	sl.lastVotedView = state.LastVotedView
+	log.Infof("[%v] recovered the safety state, last voted view: %v", sl.ID(), sl.lastVotedView)
+	if sl.lastVotedView > 0 {
+		sl.pm.AdvanceView(sl.lastVotedView)
+	}
+}
+
+// persistState saves the safety state
+func (sl *Streamlet) persistState() {
+	err := sl.store.SaveState(&blockchain.SafetyState{LastVotedView: sl.lastVotedView})
+	if err != nil {
+		log.Errorf("[%v] cannot persist the safety state: %v", sl.ID(), err)
+	}
+}
@@ -2,16 +2,19 @@ package tchs
 
 import (
 	"fmt"
+	"sort"
 	"sync"
 
 	"github.com/gitferry/bamboo/blockchain"
 	"github.com/gitferry/bamboo/config"
 	"github.com/gitferry/bamboo/crypto"
 	"github.com/gitferry/bamboo/election"
+	"github.com/gitferry/bamboo/identity"
 	"github.com/gitferry/bamboo/log"
 	"github.com/gitferry/bamboo/message"
 	"github.com/gitferry/bamboo/node"
 	"github.com/gitferry/bamboo/pacemaker"
+	"github.com/gitferry/bamboo/store"
 	"github.com/gitferry/bamboo/types"
 )
 
@@ -24,10 +27,12 @@ type Tchs struct {
 	lastVotedView   types.View
 	preferredView   types.View
 	bc              *blockchain.BlockChain
+	store           blockchain.Store
 	committedBlocks chan *blockchain.Block
 	forkedBlocks    chan *blockchain.Block
 	bufferedQCs     map[crypto.Identifier]*blockchain.QC
 	bufferedBlocks  map[types.View]*blockchain.Block
+	synchronizer    *blockchain.Synchronizer
 	highQC          *blockchain.QC
 	mu              sync.Mutex
 }
@@ -42,12 +47,19 @@ func NewTchs(
 	th.Node = node
 	th.Election = elec
 	th.pm = pm
-	th.bc = blockchain.NewBlockchain(config.GetConfig().N())
+	th.store = store.NewStore(node.ID())
+	bc, err := blockchain.NewBlockchainWithStore(config.GetConfig().N(), th.store)
+	if err != nil {
+		log.Fatalf("[%v] cannot create the blockchain: %v", node.ID(), err)
+	}
+	th.bc = bc
 	th.bufferedBlocks = make(map[types.View]*blockchain.Block)
 	th.bufferedQCs = make(map[crypto.Identifier]*blockchain.QC)
+	th.synchronizer = blockchain.NewSynchronizer(config.GetTimer())
 	th.highQC = &blockchain.QC{View: 0}
 	th.committedBlocks = committedBlocks
 	th.forkedBlocks = forkedBlocks
+	th.recoverState()
 	return th
 }
 
@@ -64,6 +76,9 @@ func (th *Tchs) ProcessBlock(block *blockchain.Block) error {
 		//	buffer the block
 		th.bufferedBlocks[block.View-1] = block
 		log.Debugf("[%v] the block is buffered, view: %v, current view is: %v, id: %x", th.ID(), block.View, curView, block.ID)
+		if !th.bc.Exists(block.PrevID) {
+			th.requestBlocks(block.PrevID, int(block.View-curView), block.Proposer)
+		}
 		return nil
 	}
 	if block.QC != nil {
@@ -119,6 +134,13 @@ func (th *Tchs) ProcessBlock(block *blockchain.Block) error {
 		log.Debugf("[%v] is not going to vote for block, id: %x", th.ID(), block.ID)
 		return nil
 	}
+	err = th.updateLastVotedView(block.View)
+	if err != nil {
+		log.Warningf("[%v] cannot vote for block, id: %x: %v", th.ID(), block.ID, err)
+		return nil
+	}
+	// the vote must be remembered before it is sent out
+	th.persistState()
 	vote := blockchain.MakeVote(block.View, th.ID(), block.ID)
 	// vote to the next leader
 	voteAggregator := th.FindLeaderFor(block.View + 1)
@@ -160,6 +182,7 @@ func (th *Tchs) ProcessVote(vote *blockchain.Vote) {
 	_, err := th.bc.GetBlockByID(qc.BlockID)
 	if err != nil {
 		th.bufferedQCs[qc.BlockID] = qc
+		th.requestBlocks(qc.BlockID, 1, th.FindLeaderFor(qc.View))
 		return
 	}
 	th.processCertificate(qc)
@@ -177,15 +200,16 @@ func (th *Tchs) ProcessRemoteTmo(tmo *pacemaker.TMO) {
 	}
 	log.Debugf("[%v] a tc is built for view %v", th.ID(), tc.View)
 	th.processTC(tc)
+	// the leader of the next view may have missed some of the timeouts
+	nextLeader := th.FindLeaderFor(tc.View + 1)
+	if nextLeader != th.ID() {
+		th.Send(nextLeader, tc)
+	}
 }
 
 func (th *Tchs) ProcessLocalTmo(view types.View) {
 	th.pm.AdvanceView(view + 1)
-	tmo := &pacemaker.TMO{
-		View:   view + 1,
-		NodeID: th.ID(),
-		HighQC: th.GetHighQC(),
-	}
+	tmo := pacemaker.MakeTMO(view+1, th.ID(), th.GetHighQC())
 	th.Broadcast(tmo)
 	th.ProcessRemoteTmo(tmo)
 	log.Debugf("[%v] broadcast is done for sending tmo", th.ID())
@@ -198,10 +222,20 @@ func (th *Tchs) MakeProposal(view types.View, payload []*message.Transaction) *b
 }
 
 func (th *Tchs) forkChoice() *blockchain.QC {
-	choice := th.GetHighQC()
+	// a copy, the high qc is shared with the timeouts signed over its view
+	choice := *th.GetHighQC()
 	// to simulate TC under forking attack
 	choice.View = th.pm.GetCurView() - 1
-	return choice
+	return &choice
+}
+
+// ProcessTC processes a tc received from another replica, the tc has been validated
+func (th *Tchs) ProcessTC(tc *pacemaker.TC) {
+	log.Debugf("[%v] is processing a tc for view %v", th.ID(), tc.View)
+	if tc.HighQC != nil {
+		th.processCertificate(tc.HighQC)
+	}
+	th.processTC(tc)
 }
 
 func (th *Tchs) processTC(tc *pacemaker.TC) {
@@ -217,6 +251,69 @@ func (th *Tchs) GetChainStatus() string {
 	return fmt.Sprintf("[%v] The current view is: %v, chain growth rate is: %v, ave block interval is: %v", th.ID(), th.pm.GetCurView(), chainGrowthRate, blockIntervals)
 }
 
+// ProcessSyncRequest replies the blocks asked by a lagging replica
+func (th *Tchs) ProcessSyncRequest(req *blockchain.SyncRequest) {
+	blocks := th.bc.GetBlocks(req)
+	if len(blocks) == 0 {
+		log.Debugf("[%v] has no block requested by %v", th.ID(), req.Requester)
+		return
+	}
+	log.Debugf("[%v] is sending %v blocks to %v", th.ID(), len(blocks), req.Requester)
+	th.Send(req.Requester, &blockchain.SyncResponse{Responder: th.ID(), Blocks: blocks})
+}
+
+// ProcessSyncResponse adds the fetched blocks to the chain
+// and then unblocks the buffered qcs and blocks waiting for them
+func (th *Tchs) ProcessSyncResponse(resp *blockchain.SyncResponse) {
+	for _, block := range resp.Blocks {
+		th.synchronizer.Done(block.ID)
+		if th.bc.Exists(block.ID) {
+			continue
+		}
+		err := th.bc.AddSyncedBlock(block)
+		if err != nil {
+			log.Warningf("[%v] received an invalid block from %v: %v", th.ID(), resp.Responder, err)
+			return
+		}
+		log.Debugf("[%v] synced a block from %v, view: %v, id: %x", th.ID(), resp.Responder, block.View, block.ID)
+		if block.QC != nil {
+			th.processCertificate(block.QC)
+		}
+		qc, ok := th.bufferedQCs[block.ID]
+		if ok {
+			delete(th.bufferedQCs, block.ID)
+			th.processCertificate(qc)
+		}
+	}
+	th.processBufferedBlocks()
+}
+
+// processBufferedBlocks processes the buffered blocks that are no longer ahead of the current view
+func (th *Tchs) processBufferedBlocks() {
+	views := make([]types.View, 0, len(th.bufferedBlocks))
+	for view := range th.bufferedBlocks {
+		views = append(views, view)
+	}
+	sort.Slice(views, func(i, j int) bool { return views[i] < views[j] })
+	for _, view := range views {
+		b, ok := th.bufferedBlocks[view]
+		if !ok || b.View > th.pm.GetCurView()+1 {
+			continue
+		}
+		delete(th.bufferedBlocks, view)
+		_ = th.ProcessBlock(b)
+	}
+}
+
+// requestBlocks asks the peer for the block and its ancestors unless they are being fetched
+func (th *Tchs) requestBlocks(id crypto.Identifier, count int, peer identity.NodeID) {
+	if peer == th.ID() || !th.synchronizer.ShouldRequest(id) {
+		return
+	}
+	log.Debugf("[%v] requests %v blocks from %v, id: %x", th.ID(), count, peer, id)
+	th.Send(peer, blockchain.MakeSyncRequest(th.ID(), id, count))
+}
+
 func (th *Tchs) GetHighQC() *blockchain.QC {
 	th.mu.Lock()
 	defer th.mu.Unlock()
@@ -228,6 +325,7 @@ func (th *Tchs) updateHighQC(qc *blockchain.QC) {
 	defer th.mu.Unlock()
 	if qc.View > th.highQC.View {
 		th.highQC = qc
+		th.persistStateLocked()
 	}
 }
 
@@ -236,14 +334,15 @@ func (th *Tchs) processCertificate(qc *blockchain.QC) {
 	if qc.View < th.pm.GetCurView() {
 		return
 	}
-	if qc.Leader != th.ID() {
+	// the qc of view 0 certifies the genesis and carries no signature
+	if qc.Leader != th.ID() && !(qc.View == 0 && qc.BlockID == crypto.Identifier{}) {
 		quorumIsVerified, _ := crypto.VerifyQuorumSignature(qc.AggSig, qc.BlockID, qc.Signers)
 		if quorumIsVerified == false {
 			log.Warningf("[%v] received a quorum with invalid signatures", th.ID())
 			return
 		}
 	}
-	if th.IsByz() && config.GetConfig().Strategy == FORK && th.IsLeader(th.ID(), qc.View+1) {
+	if th.IsByz() && config.GetConfig().HasStrategy(th.ID(), FORK) && th.IsLeader(th.ID(), qc.View+1) {
 		th.pm.AdvanceView(qc.View)
 		return
 	}
@@ -251,6 +350,7 @@ func (th *Tchs) processCertificate(qc *blockchain.QC) {
 	if err != nil {
 		th.bufferedQCs[qc.BlockID] = qc
 		log.Debugf("[%v] a qc is buffered, view: %v, id: %x", th.ID(), qc.View, qc.BlockID)
+		th.requestBlocks(qc.BlockID, 3, th.FindLeaderFor(qc.View))
 		return
 	}
 	th.updateHighQC(qc)
@@ -305,6 +405,45 @@ func (th *Tchs) updatePreferredView(qc *blockchain.QC) error {
 	if qc.View > th.preferredView {
 		log.Debugf("[%v] preferred view has been updated to %v", th.ID(), qc.View)
 		th.preferredView = qc.View
+		th.persistState()
 	}
 	return nil
 }
+
+// recoverState restores the safety state saved before a restart
+func (th *Tchs) recoverState() {
+	state, err := th.store.LoadState()
+	if err != nil {
+		log.Fatalf("[%v] cannot load the safety state: %v", th.ID(), err)
+	}
+	if state == nil {
+		return
+	}
+	th.lastVotedView = state.LastVotedView
+	th.preferredView = state.PreferredView
+	if state.HighQC != nil {
+		th.highQC = state.HighQC
+	}
+	log.Infof("[%v] recovered the safety state, last voted view: %v, preferred view: %v, high qc view: %v", th.ID(), th.lastVotedView, th.preferredView, th.highQC.View)
+	if th.highQC.View > 0 {
+		th.pm.AdvanceView(th.highQC.View)
+	}
+}
+
+func (th *Tchs) persistState() {
+	th.mu.Lock()
+	defer th.mu.Unlock()
+	th.persistStateLocked()
+}
+
+// persistStateLocked saves the safety state, the caller must hold the lock
+func (th *Tchs) persistStateLocked() {
+	err := th.store.SaveState(&blockchain.SafetyState{
+		LastVotedView: th.lastVotedView,
+		PreferredView: th.preferredView,
+		HighQC:        th.highQC,
+	})
+	if err != nil {
+		log.Errorf("[%v] cannot persist the safety state: %v", th.ID(), err)
+	}
+}
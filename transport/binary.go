@@ -0,0 +1,360 @@
+package transport
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"sync"
+)
+
+// binaryCodec encodes the exported fields of the messages in order with varints and length-prefixed bytes,
+// the channels and functions are skipped and the types implementing encoding.BinaryMarshaler, e.g., time.Time,
+// are encoded by themselves
+type binaryCodec struct{}
+
+var binaryMarshaler = reflect.TypeOf((*encoding.BinaryMarshaler)(nil)).Elem()
+
+// fields caches the indexes of the encoded fields of the struct types
+var fields sync.Map
+
+func fieldsOf(t reflect.Type) []int {
+	if f, ok := fields.Load(t); ok {
+		return f.([]int)
+	}
+	var f []int
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.PkgPath != "" || sf.Type.Kind() == reflect.Chan || sf.Type.Kind() == reflect.Func {
+			continue
+		}
+		f = append(f, i)
+	}
+	fields.Store(t, f)
+	return f
+}
+
+func (binaryCodec) Marshal(m interface{}) ([]byte, error) {
+	name, v, err := typeOf(m)
+	if err != nil {
+		return nil, err
+	}
+	w := new(bytes.Buffer)
+	putString(w, name)
+	if err := encodeValue(w, v); err != nil {
+		return nil, err
+	}
+	return w.Bytes(), nil
+}
+
+func (binaryCodec) Unmarshal(b []byte) (interface{}, error) {
+	r := bytes.NewReader(b)
+	name, err := getString(r)
+	if err != nil {
+		return nil, err
+	}
+	t, err := typeByName(name)
+	if err != nil {
+		return nil, err
+	}
+	v := reflect.New(t).Elem()
+	if err := decodeValue(r, v); err != nil {
+		return nil, fmt.Errorf("cannot decode %v: %w", name, err)
+	}
+	if r.Len() > 0 {
+		return nil, fmt.Errorf("%v bytes left after decoding %v", r.Len(), name)
+	}
+	return v.Interface(), nil
+}
+
+func putUvarint(w *bytes.Buffer, x uint64) {
+	var b [binary.MaxVarintLen64]byte
+	w.Write(b[:binary.PutUvarint(b[:], x)])
+}
+
+func putVarint(w *bytes.Buffer, x int64) {
+	var b [binary.MaxVarintLen64]byte
+	w.Write(b[:binary.PutVarint(b[:], x)])
+}
+
+func putString(w *bytes.Buffer, s string) {
+	putUvarint(w, uint64(len(s)))
+	w.WriteString(s)
+}
+
+// getLen reads a length that cannot exceed the remaining bytes
+func getLen(r *bytes.Reader) (int, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return 0, err
+	}
+	if n > uint64(r.Len()) {
+		return 0, errTruncated
+	}
+	return int(n), nil
+}
+
+func getBytes(r *bytes.Reader) ([]byte, error) {
+	n, err := getLen(r)
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, n)
+	if n == 0 {
+		return b, nil
+	}
+	_, err = r.Read(b)
+	return b, err
+}
+
+// marshals returns true if the value encodes itself, pointers and interfaces are encoded before their elements
+func marshals(t reflect.Type) bool {
+	return t.Kind() != reflect.Ptr && t.Kind() != reflect.Interface && reflect.PtrTo(t).Implements(binaryUnmarshaler) && t.Implements(binaryMarshaler)
+}
+
+func getString(r *bytes.Reader) (string, error) {
+	b, err := getBytes(r)
+	return string(b), err
+}
+
+func encodeValue(w *bytes.Buffer, v reflect.Value) error {
+	if marshals(v.Type()) {
+		b, err := v.Interface().(encoding.BinaryMarshaler).MarshalBinary()
+		if err != nil {
+			return err
+		}
+		putUvarint(w, uint64(len(b)))
+		w.Write(b)
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			w.WriteByte(1)
+		} else {
+			w.WriteByte(0)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		putVarint(w, v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		putUvarint(w, v.Uint())
+	case reflect.Float32, reflect.Float64:
+		putUvarint(w, math.Float64bits(v.Float()))
+	case reflect.String:
+		putString(w, v.String())
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			putUvarint(w, uint64(v.Len()))
+			w.Write(v.Bytes())
+			return nil
+		}
+		putUvarint(w, uint64(v.Len()))
+		for i := 0; i < v.Len(); i++ {
+			if err := encodeValue(w, v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			b := make([]byte, v.Len())
+			reflect.Copy(reflect.ValueOf(b), v)
+			w.Write(b)
+			return nil
+		}
+		for i := 0; i < v.Len(); i++ {
+			if err := encodeValue(w, v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		// the entries are sorted by their encoded keys so that a message always has the same encoding
+		type entry struct{ key, value []byte }
+		entries := make([]entry, 0, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			var k, e bytes.Buffer
+			if err := encodeValue(&k, iter.Key()); err != nil {
+				return err
+			}
+			if err := encodeValue(&e, iter.Value()); err != nil {
+				return err
+			}
+			entries = append(entries, entry{k.Bytes(), e.Bytes()})
+		}
+		sort.Slice(entries, func(i, j int) bool { return bytes.Compare(entries[i].key, entries[j].key) < 0 })
+		putUvarint(w, uint64(len(entries)))
+		for _, e := range entries {
+			w.Write(e.key)
+			w.Write(e.value)
+		}
+	case reflect.Ptr:
+		if v.IsNil() {
+			w.WriteByte(0)
+			return nil
+		}
+		w.WriteByte(1)
+		return encodeValue(w, v.Elem())
+	case reflect.Interface:
+		if v.IsNil() {
+			w.WriteByte(0)
+			return nil
+		}
+		name, e, err := typeOf(v.Interface())
+		if err != nil {
+			return err
+		}
+		w.WriteByte(1)
+		putString(w, name)
+		return encodeValue(w, e)
+	case reflect.Struct:
+		for _, i := range fieldsOf(v.Type()) {
+			if err := encodeValue(w, v.Field(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Chan, reflect.Func:
+	default:
+		return fmt.Errorf("cannot encode a value of type %v", v.Type())
+	}
+	return nil
+}
+
+func decodeValue(r *bytes.Reader, v reflect.Value) error {
+	if marshals(v.Type()) {
+		b, err := getBytes(r)
+		if err != nil {
+			return err
+		}
+		return v.Addr().Interface().(encoding.BinaryUnmarshaler).UnmarshalBinary(b)
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		b, err := r.ReadByte()
+		if err != nil {
+			return err
+		}
+		v.SetBool(b == 1)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		x, err := binary.ReadVarint(r)
+		if err != nil {
+			return err
+		}
+		v.SetInt(x)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		x, err := binary.ReadUvarint(r)
+		if err != nil {
+			return err
+		}
+		v.SetUint(x)
+	case reflect.Float32, reflect.Float64:
+		x, err := binary.ReadUvarint(r)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(math.Float64frombits(x))
+	case reflect.String:
+		s, err := getString(r)
+		if err != nil {
+			return err
+		}
+		v.SetString(s)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			b, err := getBytes(r)
+			if err != nil {
+				return err
+			}
+			if len(b) > 0 {
+				v.SetBytes(b)
+			}
+			return nil
+		}
+		n, err := getLen(r)
+		if err != nil || n == 0 {
+			return err
+		}
+		s := reflect.MakeSlice(v.Type(), n, n)
+		for i := 0; i < n; i++ {
+			if err := decodeValue(r, s.Index(i)); err != nil {
+				return err
+			}
+		}
+		v.Set(s)
+	case reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			if r.Len() < v.Len() {
+				return errTruncated
+			}
+			b := make([]byte, v.Len())
+			_, err := r.Read(b)
+			reflect.Copy(v, reflect.ValueOf(b))
+			return err
+		}
+		for i := 0; i < v.Len(); i++ {
+			if err := decodeValue(r, v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		n, err := getLen(r)
+		if err != nil {
+			return err
+		}
+		m := reflect.MakeMapWithSize(v.Type(), n)
+		for i := 0; i < n; i++ {
+			k := reflect.New(v.Type().Key()).Elem()
+			if err := decodeValue(r, k); err != nil {
+				return err
+			}
+			e := reflect.New(v.Type().Elem()).Elem()
+			if err := decodeValue(r, e); err != nil {
+				return err
+			}
+			m.SetMapIndex(k, e)
+		}
+		v.Set(m)
+	case reflect.Ptr:
+		b, err := r.ReadByte()
+		if err != nil || b == 0 {
+			return err
+		}
+		p := reflect.New(v.Type().Elem())
+		if err := decodeValue(r, p.Elem()); err != nil {
+			return err
+		}
+		v.Set(p)
+	case reflect.Interface:
+		b, err := r.ReadByte()
+		if err != nil || b == 0 {
+			return err
+		}
+		name, err := getString(r)
+		if err != nil {
+			return err
+		}
+		t, err := typeByName(name)
+		if err != nil {
+			return err
+		}
+		e := reflect.New(t).Elem()
+		if err := decodeValue(r, e); err != nil {
+			return err
+		}
+		v.Set(e)
+	case reflect.Struct:
+		for _, i := range fieldsOf(v.Type()) {
+			if err := decodeValue(r, v.Field(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Chan, reflect.Func:
+	default:
+		return fmt.Errorf("cannot decode a value of type %v", v.Type())
+	}
+	return nil
+}
+
+var binaryUnmarshaler = reflect.TypeOf((*encoding.BinaryUnmarshaler)(nil)).Elem()
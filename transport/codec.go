@@ -0,0 +1,299 @@
+package transport
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"sync"
+
+	"github.com/gitferry/bamboo/log"
+)
+
+// codecs of the messages between nodes
+const (
+	GOB    = "gob"
+	JSON   = "json"
+	BINARY = "binary"
+)
+
+var CodecName = flag.String("codec", GOB, "message codec (gob, json, binary), default gob")
+
+// maxFrame bounds the size of a frame so that a corrupted length cannot exhaust the memory
+const maxFrame = 64 << 20
+
+// Codec serializes the messages sent in one direction of a connection,
+// a codec may keep state between the messages, e.g., the types already sent by gob
+type Codec interface {
+	// Marshal encodes the message into the payload of a frame
+	Marshal(m interface{}) ([]byte, error)
+	// Unmarshal decodes the payload of a frame into a message
+	Unmarshal(b []byte) (interface{}, error)
+}
+
+// NewCodec creates a codec by name
+func NewCodec(name string) Codec {
+	switch name {
+	case GOB:
+		return newGobCodec()
+	case JSON:
+		return jsonCodec{}
+	case BINARY:
+		return binaryCodec{}
+	default:
+		log.Fatalf("unknown codec %s", name)
+	}
+	return nil
+}
+
+var (
+	typesByName = make(map[string]reflect.Type)
+	namesByType = make(map[reflect.Type]string)
+	registry    sync.RWMutex
+)
+
+// Register makes the type of the message known to every codec, the messages are decoded as values of the type
+func Register(m interface{}) {
+	gob.Register(m)
+	t := reflect.TypeOf(m)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	registry.Lock()
+	defer registry.Unlock()
+	typesByName[t.String()] = t
+	namesByType[t] = t.String()
+}
+
+// typeOf returns the name of the registered type of the message and its value, pointers are dereferenced
+func typeOf(m interface{}) (string, reflect.Value, error) {
+	v := reflect.ValueOf(m)
+	for v.Kind() == reflect.Ptr && !v.IsNil() {
+		v = v.Elem()
+	}
+	registry.RLock()
+	name, ok := namesByType[v.Type()]
+	registry.RUnlock()
+	if !ok {
+		return "", v, fmt.Errorf("type %v is not registered", v.Type())
+	}
+	return name, v, nil
+}
+
+// typeByName returns the registered type of the name
+func typeByName(name string) (reflect.Type, error) {
+	registry.RLock()
+	defer registry.RUnlock()
+	t, ok := typesByName[name]
+	if !ok {
+		return nil, fmt.Errorf("type %v is not registered", name)
+	}
+	return t, nil
+}
+
+// WriteFrame writes the payload prefixed by its length in 4 bytes
+func WriteFrame(w io.Writer, b []byte) error {
+	frame := make([]byte, 4+len(b))
+	binary.BigEndian.PutUint32(frame, uint32(len(b)))
+	copy(frame[4:], b)
+	_, err := w.Write(frame)
+	return err
+}
+
+// ReadFrame reads the payload of a frame written by WriteFrame
+func ReadFrame(r io.Reader) ([]byte, error) {
+	var prefix [4]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(prefix[:])
+	if n > maxFrame {
+		return nil, fmt.Errorf("frame of %v bytes exceeds the limit of %v bytes", n, maxFrame)
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// overhead is the size of the type descriptors that a codec such as gob sends with the first message of a type
+type overhead struct {
+	codec string
+	t     reflect.Type
+}
+
+var (
+	overheads     = make(map[overhead]int)
+	overheadsLock sync.Mutex
+)
+
+// FrameSize returns the size of the frame of the message with the codec of the transports
+// on a connection that sent a message of its type before, as most messages are sent.
+// The type descriptors are measured once per type by encoding the first message twice and are left out.
+func FrameSize(m interface{}) int {
+	codec := NewCodec(*CodecName)
+	b, err := codec.Marshal(m)
+	if err != nil {
+		log.Errorf("cannot encode %T: %v", m, err)
+		return 0
+	}
+	key := overhead{codec: *CodecName, t: reflect.TypeOf(m)}
+	overheadsLock.Lock()
+	defer overheadsLock.Unlock()
+	n, ok := overheads[key]
+	if !ok {
+		again, err := codec.Marshal(m)
+		if err != nil {
+			log.Errorf("cannot encode %T: %v", m, err)
+			return 4 + len(b)
+		}
+		n = len(b) - len(again)
+		overheads[key] = n
+	}
+	return 4 + len(b) - n
+}
+
+// Size is the traffic of a type of message sent by the node
+type Size struct {
+	Count uint64 // number of messages
+	Bytes uint64 // bytes of the frames
+}
+
+// Average returns the average size of the messages in bytes
+func (s Size) Average() float64 {
+	if s.Count == 0 {
+		return 0
+	}
+	return float64(s.Bytes) / float64(s.Count)
+}
+
+var (
+	sizes     = make(map[string]Size)
+	sizesLock sync.Mutex
+)
+
+// record adds a frame of n bytes carrying the message to the sizes
+func record(m interface{}, n int) {
+	t := reflect.TypeOf(m)
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	name := fmt.Sprint(t)
+	sizesLock.Lock()
+	defer sizesLock.Unlock()
+	s := sizes[name]
+	s.Count++
+	s.Bytes += uint64(n)
+	sizes[name] = s
+}
+
+// Sizes returns the traffic of every type of message sent so far
+func Sizes() map[string]Size {
+	sizesLock.Lock()
+	defer sizesLock.Unlock()
+	s := make(map[string]Size, len(sizes))
+	for name, size := range sizes {
+		s[name] = size
+	}
+	return s
+}
+
+// SizesString formats the sizes one type per line sorted by name
+func SizesString() string {
+	s := Sizes()
+	names := make([]string, 0, len(s))
+	for name := range s {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b bytes.Buffer
+	for _, name := range names {
+		fmt.Fprintf(&b, "%v: %v messages, %v bytes, %.1f bytes on average\n", name, s[name].Count, s[name].Bytes, s[name].Average())
+	}
+	return b.String()
+}
+
+/******************************
+/*            gob             *
+/******************************/
+
+// gobCodec is a gob stream cut into frames, the types are only sent once per connection
+type gobCodec struct {
+	w   bytes.Buffer
+	enc *gob.Encoder
+	r   bytes.Buffer
+	dec *gob.Decoder
+}
+
+func newGobCodec() *gobCodec {
+	c := new(gobCodec)
+	c.enc = gob.NewEncoder(&c.w)
+	c.dec = gob.NewDecoder(&c.r)
+	return c
+}
+
+func (c *gobCodec) Marshal(m interface{}) ([]byte, error) {
+	c.w.Reset()
+	if err := c.enc.Encode(&m); err != nil {
+		return nil, err
+	}
+	return append([]byte(nil), c.w.Bytes()...), nil
+}
+
+func (c *gobCodec) Unmarshal(b []byte) (interface{}, error) {
+	c.r.Write(b)
+	var m interface{}
+	if err := c.dec.Decode(&m); err != nil {
+		c.r.Reset()
+		return nil, err
+	}
+	return m, nil
+}
+
+/******************************
+/*            JSON            *
+/******************************/
+
+type jsonCodec struct{}
+
+type envelope struct {
+	Type    string          `json:"t"`
+	Message json.RawMessage `json:"m"`
+}
+
+func (jsonCodec) Marshal(m interface{}) ([]byte, error) {
+	name, v, err := typeOf(m)
+	if err != nil {
+		return nil, err
+	}
+	b, err := json.Marshal(v.Interface())
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(envelope{Type: name, Message: b})
+}
+
+func (jsonCodec) Unmarshal(b []byte) (interface{}, error) {
+	var e envelope
+	if err := json.Unmarshal(b, &e); err != nil {
+		return nil, err
+	}
+	t, err := typeByName(e.Type)
+	if err != nil {
+		return nil, err
+	}
+	v := reflect.New(t)
+	if err := json.Unmarshal(e.Message, v.Interface()); err != nil {
+		return nil, err
+	}
+	return v.Elem().Interface(), nil
+}
+
+var errTruncated = errors.New("truncated message")
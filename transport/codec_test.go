@@ -0,0 +1,127 @@
+package transport
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type inner struct {
+	N int
+}
+
+type message struct {
+	ID      [32]byte
+	Data    []byte
+	Text    string
+	Ratio   float64
+	Inner   *inner
+	Nil     *inner
+	Values  map[string]int
+	Items   []inner
+	Any     interface{}
+	At      time.Time
+	C       chan struct{} `json:"-"`
+	private int
+}
+
+func init() {
+	Register(message{})
+	Register(inner{})
+}
+
+func TestCodec(t *testing.T) {
+	m := message{
+		ID:     [32]byte{1, 2, 3},
+		Data:   []byte("data"),
+		Text:   "text",
+		Ratio:  0.5,
+		Inner:  &inner{N: -1},
+		Values: map[string]int{"a": 1, "b": 2},
+		Items:  []inner{{1}, {2}},
+		Any:    inner{N: 3},
+		At:     time.Unix(1600000000, 42).UTC(),
+	}
+	for _, name := range []string{GOB, JSON, BINARY} {
+		codec := NewCodec(name)
+		for i := 0; i < 2; i++ {
+			b, err := codec.Marshal(&m)
+			require.NoError(t, err, name)
+			decoded, err := codec.Unmarshal(b)
+			require.NoError(t, err, name)
+			d, ok := decoded.(message)
+			require.True(t, ok, name)
+			require.Equal(t, m.ID, d.ID, name)
+			require.Equal(t, m.Data, d.Data, name)
+			require.Equal(t, m.Text, d.Text, name)
+			require.Equal(t, m.Ratio, d.Ratio, name)
+			require.Equal(t, m.Inner, d.Inner, name)
+			require.Nil(t, d.Nil, name)
+			require.Equal(t, m.Values, d.Values, name)
+			require.Equal(t, m.Items, d.Items, name)
+			require.True(t, m.At.Equal(d.At), name)
+		}
+	}
+}
+
+func TestBinaryCodec(t *testing.T) {
+	m := message{Values: map[string]int{"a": 1, "b": 2, "c": 3}, Any: inner{N: 3}}
+	a, err := binaryCodec{}.Marshal(m)
+	require.NoError(t, err)
+	b, err := binaryCodec{}.Marshal(m)
+	require.NoError(t, err)
+	require.Equal(t, a, b)
+
+	d, err := binaryCodec{}.Unmarshal(a)
+	require.NoError(t, err)
+	require.Equal(t, inner{N: 3}, d.(message).Any)
+
+	_, err = binaryCodec{}.Unmarshal(a[:len(a)-1])
+	require.Error(t, err)
+	_, err = binaryCodec{}.Marshal(struct{ N int }{1})
+	require.Error(t, err)
+}
+
+func TestFrame(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, WriteFrame(&buf, []byte("first")))
+	require.NoError(t, WriteFrame(&buf, nil))
+	require.NoError(t, WriteFrame(&buf, []byte("second")))
+	for _, want := range []string{"first", "", "second"} {
+		b, err := ReadFrame(&buf)
+		require.NoError(t, err)
+		require.Equal(t, want, string(b))
+	}
+	_, err := ReadFrame(&buf)
+	require.Error(t, err)
+
+	_, err = ReadFrame(bytes.NewReader([]byte{0xff, 0xff, 0xff, 0xff}))
+	require.Error(t, err)
+}
+
+func TestSizes(t *testing.T) {
+	record(&inner{}, 10)
+	record(inner{}, 20)
+	s := Sizes()["transport.inner"]
+	require.Equal(t, uint64(2), s.Count)
+	require.Equal(t, uint64(30), s.Bytes)
+	require.Equal(t, 15.0, s.Average())
+	require.Contains(t, SizesString(), "transport.inner: 2 messages")
+}
+
+// the frame size leaves out the type descriptors that a connection sends only once
+func TestFrameSize(t *testing.T) {
+	defer func() { *CodecName = GOB }()
+	for _, name := range []string{GOB, JSON, BINARY} {
+		*CodecName = name
+		codec := NewCodec(name)
+		_, err := codec.Marshal(inner{N: 1})
+		require.NoError(t, err, name)
+		b, err := codec.Marshal(inner{N: 2})
+		require.NoError(t, err, name)
+		require.Equal(t, 4+len(b), FrameSize(inner{N: 2}), name)
+		require.Equal(t, 4+len(b), FrameSize(inner{N: 2}), name)
+	}
+}
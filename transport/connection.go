@@ -0,0 +1,233 @@
+package transport
+
+import (
+	"crypto/tls"
+	"flag"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/gitferry/bamboo/log"
+)
+
+// status of the connections of a transport
+const (
+	CONNECTING = "connecting"
+	CONNECTED  = "connected"
+	CLOSED     = "closed"
+)
+
+// policies of a transport whose send queue is full
+const (
+	BLOCK = "block" // the sender waits for room in the queue while the peer is connected, up to blockTimeout
+	DROP  = "drop"  // the message is dropped
+)
+
+var SendPolicy = flag.String("send_policy", BLOCK, "policy when the send queue to a peer is full (block, drop), default block")
+
+const (
+	dialTimeout  = 2 * time.Second
+	writeTimeout = 10 * time.Second
+	blockTimeout = time.Second
+	keepAlive    = 5 * time.Second
+	minBackoff   = 50 * time.Millisecond
+	maxBackoff   = 5 * time.Second
+)
+
+// State is the state of the connections of a transport to its peer
+type State struct {
+	Status     string    `json:"status"`
+	Since      time.Time `json:"since"`      // time of the last change of status
+	Reconnects int       `json:"reconnects"` // number of connections established after a broken one
+	Dropped    uint64    `json:"dropped"`    // messages dropped because the queue was full or the connection broke
+	Queued     int       `json:"queued"`     // messages waiting in the send queue
+	Error      string    `json:"error,omitempty"`
+}
+
+// state tracks the connections of the streams of a transport, a transport is connected once all of its streams are
+type state struct {
+	State
+	streams int // streams dialing or connected
+	up      int // streams connected
+	sync.Mutex
+}
+
+func (s *state) set(status string) {
+	if s.Status != status {
+		s.Status = status
+		s.Since = time.Now()
+	}
+}
+
+// opened records a stream that starts dialing
+func (s *state) opened() {
+	s.Lock()
+	defer s.Unlock()
+	s.streams++
+	s.set(CONNECTING)
+}
+
+// connected records a stream that is connected, after a broken connection if reconnect is set
+func (s *state) connected(reconnect bool) {
+	s.Lock()
+	defer s.Unlock()
+	s.up++
+	if reconnect {
+		s.Reconnects++
+	}
+	if s.up == s.streams && s.Status != CLOSED {
+		s.set(CONNECTED)
+		s.Error = ""
+	}
+}
+
+// broken records a stream whose connection broke or failed to open
+func (s *state) broken(err error, up bool) {
+	s.Lock()
+	defer s.Unlock()
+	if up {
+		s.up--
+	}
+	s.Error = err.Error()
+	if s.Status != CLOSED {
+		s.set(CONNECTING)
+	}
+}
+
+// online tells if every stream is connected
+func (s *state) online() bool {
+	s.Lock()
+	defer s.Unlock()
+	return s.Status == CONNECTED
+}
+
+func (s *state) dropped() {
+	s.Lock()
+	defer s.Unlock()
+	s.Dropped++
+}
+
+func (s *state) closed() {
+	s.Lock()
+	defer s.Unlock()
+	s.set(CLOSED)
+}
+
+// State returns the state of the connections to the peer
+func (t *transport) State() State {
+	t.state.Lock()
+	defer t.state.Unlock()
+	s := t.state.State
+	s.Queued = len(t.send)
+	return s
+}
+
+// stream sends the messages of the queue on a connection to the peer until the transport is closed,
+// a broken connection is dialed again with exponential backoff while the messages wait in the queue
+func (t *transport) stream(send <-chan interface{}) {
+	t.state.opened()
+	conn := t.redial(false)
+	if conn == nil {
+		return
+	}
+	codec := NewCodec(t.codec)
+	for {
+		var m interface{}
+		select {
+		case <-t.close:
+			conn.Close()
+			return
+		case m = <-send:
+		}
+		b, err := codec.Marshal(m)
+		if err != nil {
+			log.Errorf("cannot encode %T: %v", m, err)
+			continue
+		}
+		record(m, 4+len(b))
+		conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+		err = WriteFrame(conn, b)
+		if err == nil {
+			continue
+		}
+		log.Warningf("[%v] connection to %v is broken: %v", t.self, t.uri.Host, err)
+		conn.Close()
+		t.state.dropped()
+		t.state.broken(err, true)
+		conn = t.redial(true)
+		if conn == nil {
+			return
+		}
+		// the codec of a new connection starts from scratch, e.g., gob sends the types again
+		codec = NewCodec(t.codec)
+	}
+}
+
+// redial connects to the peer, backing off exponentially after each failure, it returns nil once the transport is closed
+func (t *transport) redial(reconnect bool) net.Conn {
+	backoff := time.Duration(0)
+	if reconnect {
+		backoff = minBackoff
+	}
+	for {
+		select {
+		case <-t.close:
+			return nil
+		case <-time.After(backoff):
+		}
+		conn, err := t.connect()
+		if err == nil {
+			t.state.connected(reconnect)
+			if reconnect {
+				log.Infof("[%v] reconnected to %v", t.self, t.uri.Host)
+			}
+			return conn
+		}
+		t.state.broken(err, false)
+		backoff *= 2
+		if backoff < minBackoff {
+			backoff = minBackoff
+		}
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+		log.Debugf("[%v] cannot connect to %v, retrying in %v: %v", t.self, t.uri.Host, backoff, err)
+	}
+}
+
+// connect opens a tcp connection to the peer, authenticated under tls
+func (t *transport) connect() (net.Conn, error) {
+	dialer := net.Dialer{Timeout: dialTimeout, KeepAlive: keepAlive}
+	conn, err := dialer.Dial("tcp", t.uri.Host)
+	if err != nil {
+		return nil, err
+	}
+	if t.security == TLS {
+		conn, err = t.handshake(conn, tls.Client)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// accepted tracks the connection accepted by the listener so that Close closes it,
+// it returns false if the transport is already closed
+func (t *transport) accepted(conn net.Conn) bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	select {
+	case <-t.close:
+		return false
+	default:
+	}
+	t.conns[conn] = struct{}{}
+	return true
+}
+
+func (t *transport) released(conn net.Conn) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	delete(t.conns, conn)
+}
@@ -0,0 +1,100 @@
+package transport
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// a peer that starts late or restarts receives the messages once it is up
+func TestReconnect(t *testing.T) {
+	addr := "tcp://127.0.0.1:17601"
+	client := NewTransport(addr)
+	require.NoError(t, client.Dial())
+	client.Send(inner{N: 1})
+	time.Sleep(100 * time.Millisecond)
+	require.Equal(t, CONNECTING, client.State().Status)
+	require.NotEmpty(t, client.State().Error)
+
+	server := NewTransport(addr)
+	server.Listen()
+	require.Equal(t, inner{N: 1}, recv(server))
+	require.Equal(t, CONNECTED, client.State().Status)
+
+	// the server crashes and restarts on the same address
+	server.Close()
+	require.Equal(t, CLOSED, server.State().Status)
+	server = NewTransport(addr)
+	server.Listen()
+	var received interface{}
+	for i := 2; i < 20 && received == nil; i++ {
+		client.Send(inner{N: i})
+		received = recv(server)
+	}
+	require.NotNil(t, received)
+	require.Equal(t, CONNECTED, client.State().Status)
+	require.Equal(t, 1, client.State().Reconnects)
+
+	client.Close()
+	server.Close()
+	client.Send(inner{N: 20})
+	require.Equal(t, CLOSED, client.State().Status)
+}
+
+func TestSendPolicy(t *testing.T) {
+	defer func() { *SendPolicy = BLOCK }()
+	*SendPolicy = DROP
+
+	// nothing listens on the address so the queue fills up
+	client := NewTransport("tcp://127.0.0.1:17602")
+	require.NoError(t, client.Dial())
+	for i := 0; i < cap(client.(*tcp).send)+10; i++ {
+		client.Send(inner{N: i})
+	}
+	require.Equal(t, uint64(10), client.State().Dropped)
+	require.Equal(t, cap(client.(*tcp).send), client.State().Queued)
+	client.Close()
+
+	// a peer that is down does not hold up the sender under the block policy either
+	*SendPolicy = BLOCK
+	client = NewTransport("tcp://127.0.0.1:17602")
+	require.NoError(t, client.Dial())
+	for i := 0; i < cap(client.(*tcp).send)+10; i++ {
+		client.Send(inner{N: i})
+	}
+	require.Equal(t, uint64(10), client.State().Dropped)
+	client.Close()
+
+	// the sender waits for a connected peer, the queue is not drained since the transport is not dialed
+	client = NewTransport("tcp://127.0.0.1:17602")
+	client.(*tcp).state.opened()
+	client.(*tcp).state.connected(false)
+	for i := 0; i < cap(client.(*tcp).send); i++ {
+		client.Send(inner{N: i})
+	}
+	sent := make(chan struct{})
+	go func() {
+		client.Send(inner{})
+		close(sent)
+	}()
+	select {
+	case <-sent:
+		t.Fatal("the sender does not wait for room in the queue")
+	case <-time.After(100 * time.Millisecond):
+	}
+	// but not for ever
+	<-sent
+	require.Equal(t, uint64(1), client.State().Dropped)
+
+	// closing the transport releases the sender
+	sent = make(chan struct{})
+	go func() {
+		client.Send(inner{})
+		close(sent)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	client.Close()
+	<-sent
+	require.Equal(t, uint64(1), client.State().Dropped)
+}
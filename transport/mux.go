@@ -0,0 +1,112 @@
+package transport
+
+import (
+	"reflect"
+	"sort"
+	"sync"
+
+	"github.com/gitferry/bamboo/log"
+)
+
+// MUX is the scheme of the transport with one stream per class of message
+const MUX = "mux"
+
+// DEFAULT is the class of the messages registered without a class
+const DEFAULT = "default"
+
+var (
+	classes     = make(map[reflect.Type]string)
+	classesLock sync.RWMutex
+)
+
+// RegisterClass registers the message as Register does and sends it on the stream of the class under mux,
+// so that the messages of a class are never held up behind the ones of another
+func RegisterClass(m interface{}, class string) {
+	Register(m)
+	t := reflect.TypeOf(m)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	classesLock.Lock()
+	defer classesLock.Unlock()
+	classes[t] = class
+}
+
+// classOf returns the class of the message, the default one if it has none
+func classOf(m interface{}) string {
+	t := reflect.TypeOf(m)
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	classesLock.RLock()
+	defer classesLock.RUnlock()
+	if class, ok := classes[t]; ok {
+		return class
+	}
+	return DEFAULT
+}
+
+// classNames returns the registered classes sorted by name, including the default one
+func classNames() []string {
+	classesLock.RLock()
+	defer classesLock.RUnlock()
+	names := []string{DEFAULT}
+	seen := map[string]bool{DEFAULT: true}
+	for _, class := range classes {
+		if !seen[class] {
+			seen[class] = true
+			names = append(names, class)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+/******************************
+/*  Multiplexed communication *
+/******************************/
+
+// mux is a tcp transport with one connection per class of message,
+// the messages keep their order within a class only
+type mux struct {
+	*transport
+	streams map[string]chan interface{}
+}
+
+func newMux(t *transport) *mux {
+	return &mux{transport: t, streams: make(map[string]chan interface{})}
+}
+
+// Dial starts one stream per class, each with its own connection to the peer
+func (m *mux) Dial() error {
+	for _, class := range classNames() {
+		m.streams[class] = make(chan interface{}, cap(m.send))
+		go m.stream(m.streams[class])
+	}
+	log.Debugf("[%v] opening %v streams to %v", m.self, len(m.streams), m.uri.Host)
+	return nil
+}
+
+// Send queues the message on the stream of its class, a full stream holds up the messages of its class only
+func (m *mux) Send(msg interface{}) {
+	stream, ok := m.streams[classOf(msg)]
+	if !ok {
+		// the class is registered after the dial
+		stream = m.streams[DEFAULT]
+	}
+	m.enqueue(stream, msg)
+}
+
+// State adds the messages waiting in the streams to the state of the transport
+func (m *mux) State() State {
+	s := m.transport.State()
+	for _, stream := range m.streams {
+		s.Queued += len(stream)
+	}
+	return s
+}
+
+// Listen accepts the streams as any tcp connection since every frame carries a whole message
+func (m *mux) Listen() {
+	(&tcp{m.transport}).Listen()
+}
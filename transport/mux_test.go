@@ -0,0 +1,86 @@
+package transport
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type bulk struct {
+	Data []byte
+}
+
+type vote struct {
+	N int
+}
+
+func init() {
+	RegisterClass(bulk{}, "bulk")
+	RegisterClass(vote{}, "vote")
+}
+
+func TestClassOf(t *testing.T) {
+	require.Equal(t, "bulk", classOf(&bulk{}))
+	require.Equal(t, "vote", classOf(vote{}))
+	require.Equal(t, DEFAULT, classOf(inner{}))
+	require.Subset(t, classNames(), []string{DEFAULT, "bulk", "vote"})
+}
+
+// a vote sent after a large message overtakes it under mux only
+func TestMux(t *testing.T) {
+	defer func() { *Scheme = "tcp" }()
+	for _, scheme := range []string{"tcp", MUX} {
+		*Scheme = scheme
+		addr := map[string]string{"tcp": "tcp://127.0.0.1:17501", MUX: "tcp://127.0.0.1:17502"}[scheme]
+		server := NewTransport(addr)
+		require.Equal(t, scheme, server.Scheme())
+		server.Listen()
+		client := NewTransport(addr)
+		require.NoError(t, client.Dial())
+
+		client.Send(bulk{Data: make([]byte, 8<<20)})
+		client.Send(vote{N: 1})
+		client.Send(vote{N: 2})
+		first, second, third := recv(server), recv(server), recv(server)
+		if scheme == MUX {
+			require.Equal(t, vote{N: 1}, first)
+			require.Equal(t, vote{N: 2}, second)
+			require.IsType(t, bulk{}, third)
+		} else {
+			require.IsType(t, bulk{}, first)
+			require.Equal(t, vote{N: 1}, second)
+			require.Equal(t, vote{N: 2}, third)
+		}
+		client.Close()
+	}
+}
+
+// a class whose stream is full does not hold up the other classes
+func TestMux_FullStream(t *testing.T) {
+	defer func() { *Scheme = "tcp" }()
+	*Scheme = MUX
+	client := NewTransport("tcp://127.0.0.1:17503")
+	m := client.(*mux)
+	// the streams are not drained since the transport is not dialed
+	for _, class := range classNames() {
+		m.streams[class] = make(chan interface{}, 1)
+	}
+	m.state.opened()
+	m.state.connected(false)
+	client.Send(bulk{})
+	go client.Send(bulk{})
+	time.Sleep(100 * time.Millisecond)
+	sent := make(chan struct{})
+	go func() {
+		client.Send(vote{N: 1})
+		close(sent)
+	}()
+	select {
+	case <-sent:
+	case <-time.After(100 * time.Millisecond):
+		t.Fatal("the vote is held up behind the bulk messages")
+	}
+	require.Equal(t, vote{N: 1}, <-m.streams["vote"])
+	client.Close()
+}
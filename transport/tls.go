@@ -0,0 +1,206 @@
+package transport
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gitferry/bamboo/identity"
+)
+
+// security of the connections between nodes
+const (
+	NONE = "none"
+	TLS  = "tls"
+)
+
+var Security = flag.String("security", NONE, "security of the connections between nodes (none, tls), default none")
+var KeyDir = flag.String("keys", "", "directory of the node keys of tls, required by tls")
+
+// handshakeTimeout bounds the handshake so that a silent peer cannot hold a connection
+const handshakeTimeout = 5 * time.Second
+
+var (
+	certificates = make(map[identity.NodeID]tls.Certificate)
+	publicKeys   = make(map[identity.NodeID]ed25519.PublicKey)
+	keysLock     sync.Mutex
+)
+
+// derivedKeys lets the tests run without a key directory by deriving the keys from the node ids,
+// anyone can compute these keys so they never authenticate a node outside of tests
+var derivedKeys = false
+
+// GenerateKeys writes a new key pair for each node into the directory,
+// a node needs its own <id>.key and the <id>.pub of every node
+func GenerateKeys(dir string, ids []identity.NodeID) error {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		pub, priv, err := ed25519.GenerateKey(nil)
+		if err != nil {
+			return err
+		}
+		err = ioutil.WriteFile(filepath.Join(dir, string(id)+".key"), []byte(hex.EncodeToString(priv.Seed())), 0600)
+		if err != nil {
+			return err
+		}
+		err = ioutil.WriteFile(filepath.Join(dir, string(id)+".pub"), []byte(hex.EncodeToString(pub)), 0644)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readKey reads a hex encoded key of the node from the key directory
+func readKey(id identity.NodeID, ext string, size int) ([]byte, error) {
+	data, err := ioutil.ReadFile(filepath.Join(*KeyDir, string(id)+ext))
+	if err != nil {
+		return nil, err
+	}
+	b, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid key of node %v: %w", id, err)
+	}
+	if len(b) != size {
+		return nil, fmt.Errorf("key of node %v has %v bytes instead of %v", id, len(b), size)
+	}
+	return b, nil
+}
+
+// privateKey returns the private key of the node read from the key directory
+func privateKey(id identity.NodeID) (ed25519.PrivateKey, error) {
+	if *KeyDir == "" {
+		if !derivedKeys {
+			return nil, errors.New("no key directory")
+		}
+		seed := sha256.Sum256([]byte("bamboo-tls-key-" + string(id)))
+		return ed25519.NewKeyFromSeed(seed[:]), nil
+	}
+	seed, err := readKey(id, ".key", ed25519.SeedSize)
+	if err != nil {
+		return nil, err
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+// publicKey returns the public key that identifies the node
+func publicKey(id identity.NodeID) (ed25519.PublicKey, error) {
+	keysLock.Lock()
+	defer keysLock.Unlock()
+	if pub, ok := publicKeys[id]; ok {
+		return pub, nil
+	}
+	var pub ed25519.PublicKey
+	if *KeyDir == "" {
+		priv, err := privateKey(id)
+		if err != nil {
+			return nil, err
+		}
+		pub = priv.Public().(ed25519.PublicKey)
+	} else {
+		b, err := readKey(id, ".pub", ed25519.PublicKeySize)
+		if err != nil {
+			return nil, err
+		}
+		pub = b
+	}
+	publicKeys[id] = pub
+	return pub, nil
+}
+
+// certificate returns the self-signed certificate of the node, its common name is the id
+func certificate(id identity.NodeID) (tls.Certificate, error) {
+	keysLock.Lock()
+	defer keysLock.Unlock()
+	if cert, ok := certificates[id]; ok {
+		return cert, nil
+	}
+	priv, err := privateKey(id)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: string(id)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(10 * 365 * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(nil, template, template, priv.Public(), priv)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	cert := tls.Certificate{Certificate: [][]byte{der}, PrivateKey: priv}
+	certificates[id] = cert
+	return cert, nil
+}
+
+// verify returns a check that the peer presents the certificate of the key of its id,
+// which must be the expected one unless it is empty
+func verify(expected identity.NodeID) func([][]byte, [][]*x509.Certificate) error {
+	return func(certs [][]byte, _ [][]*x509.Certificate) error {
+		if len(certs) == 0 {
+			return errors.New("the peer presents no certificate")
+		}
+		cert, err := x509.ParseCertificate(certs[0])
+		if err != nil {
+			return err
+		}
+		id := identity.NodeID(cert.Subject.CommonName)
+		if expected != "" && id != expected {
+			return fmt.Errorf("the peer is node %v instead of node %v", id, expected)
+		}
+		pub, err := publicKey(id)
+		if err != nil {
+			return fmt.Errorf("unknown node %v: %w", id, err)
+		}
+		key, ok := cert.PublicKey.(ed25519.PublicKey)
+		if !ok || !bytes.Equal(key, pub) {
+			return fmt.Errorf("the peer does not hold the key of node %v", id)
+		}
+		return cert.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature)
+	}
+}
+
+// tlsConfig returns the configuration of the connections of node self to the peer,
+// the certificates are checked against the node keys instead of a certificate authority
+func tlsConfig(self, peer identity.NodeID) (*tls.Config, error) {
+	cert, err := certificate(self)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		Certificates:          []tls.Certificate{cert},
+		MinVersion:            tls.VersionTLS13,
+		ClientAuth:            tls.RequireAnyClientCert,
+		InsecureSkipVerify:    true, // the certificates are self-signed, verify checks them instead
+		VerifyPeerCertificate: verify(peer),
+	}, nil
+}
+
+// peerOf returns the node authenticated by the handshake of the connection
+func peerOf(conn *tls.Conn) identity.NodeID {
+	certs := conn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return ""
+	}
+	return identity.NodeID(certs[0].Subject.CommonName)
+}
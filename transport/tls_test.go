@@ -0,0 +1,114 @@
+package transport
+
+import (
+	"crypto/ed25519"
+	"crypto/tls"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/gitferry/bamboo/identity"
+)
+
+var (
+	receivers     = make(map[Transport]chan interface{})
+	receiversLock sync.Mutex
+)
+
+// recv returns the message received in time, nil if there is none,
+// one goroutine receives the messages of a transport so that none is lost after a timeout
+func recv(t Transport) interface{} {
+	receiversLock.Lock()
+	c, ok := receivers[t]
+	if !ok {
+		c = make(chan interface{})
+		receivers[t] = c
+		go func() {
+			for {
+				c <- t.Recv()
+			}
+		}()
+	}
+	receiversLock.Unlock()
+	select {
+	case m := <-c:
+		return m
+	case <-time.After(time.Second):
+		return nil
+	}
+}
+
+// setKeys switches to the keys of the directory
+func setKeys(dir string) {
+	keysLock.Lock()
+	defer keysLock.Unlock()
+	*KeyDir = dir
+	certificates = make(map[identity.NodeID]tls.Certificate)
+	publicKeys = make(map[identity.NodeID]ed25519.PublicKey)
+}
+
+func TestTLS(t *testing.T) {
+	defer setKeys("")
+	defer func() { *Security = NONE }()
+	defer func() { derivedKeys = false }()
+	*Security = TLS
+	derivedKeys = true
+	server := NewPeerTransport("tcp://127.0.0.1:17401", "1", "1")
+	server.Listen()
+
+	client := NewPeerTransport("tcp://127.0.0.1:17401", "2", "1")
+	require.NoError(t, client.Dial())
+	client.Send(inner{N: 1})
+	require.Equal(t, inner{N: 1}, recv(server))
+
+	// node 1 is not the expected peer
+	wrong := NewPeerTransport("tcp://127.0.0.1:17401", "2", "3")
+	require.NoError(t, wrong.Dial())
+	wrong.Send(inner{N: 9})
+	require.Nil(t, recv(server))
+	require.Equal(t, CONNECTING, wrong.State().Status)
+	require.Contains(t, wrong.State().Error, "instead of node 3")
+	wrong.Close()
+
+	// the keys are read from the key directory
+	dir, err := ioutil.TempDir("", "keys")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	require.NoError(t, GenerateKeys(dir, []identity.NodeID{"1", "2"}))
+	setKeys(dir)
+	server = NewPeerTransport("tcp://127.0.0.1:17402", "1", "1")
+	server.Listen()
+	client = NewPeerTransport("tcp://127.0.0.1:17402", "2", "1")
+	require.NoError(t, client.Dial())
+	client.Send(inner{N: 2})
+	require.Equal(t, inner{N: 2}, recv(server))
+
+	// the impostor holds the derived key of node 2 instead of the generated one
+	keysLock.Lock()
+	*KeyDir = ""
+	delete(certificates, "2")
+	keysLock.Unlock()
+	_, err = certificate("2")
+	require.NoError(t, err)
+	keysLock.Lock()
+	*KeyDir = dir
+	keysLock.Unlock()
+	impostor := NewPeerTransport("tcp://127.0.0.1:17402", "2", "1")
+	require.NoError(t, impostor.Dial())
+	impostor.Send(inner{N: 3})
+	require.Nil(t, recv(server))
+	impostor.Close()
+}
+
+// without a key directory the keys are not derived outside of tests
+func TestTLS_NoKeys(t *testing.T) {
+	setKeys("")
+	_, err := certificate("1")
+	require.Error(t, err)
+	_, err = publicKey("1")
+	require.Error(t, err)
+}
@@ -1,19 +1,23 @@
 package transport
 
 import (
+	"bufio"
 	"bytes"
-	"encoding/gob"
+	"crypto/tls"
 	"errors"
 	"flag"
+	"io"
 	"net"
 	"net/url"
 	"strings"
 	"sync"
+	"time"
 
+	"github.com/gitferry/bamboo/identity"
 	"github.com/gitferry/bamboo/log"
 )
 
-var Scheme = flag.String("transport", "tcp", "transport scheme (tcp, udp, chan), default tcp")
+var Scheme = flag.String("transport", "tcp", "transport scheme (tcp, udp, chan, mux), default tcp")
 
 // Transport = transport + pipe + client + server
 type Transport interface {
@@ -34,10 +38,19 @@ type Transport interface {
 
 	// Close closes send channel and stops listener
 	Close()
+
+	// State returns the state of the connections to the peer
+	State() State
 }
 
 // NewTransport creates new transport object with url
 func NewTransport(addr string) Transport {
+	return NewPeerTransport(addr, "", "")
+}
+
+// NewPeerTransport creates the transport of node self to the peer at the url, the identities authenticate
+// the connections under tls, the listening transport of a node is the one to itself
+func NewPeerTransport(addr string, self, peer identity.NodeID) Transport {
 	if !strings.Contains(addr, "://") {
 		addr = *Scheme + "://" + addr
 	}
@@ -47,10 +60,26 @@ func NewTransport(addr string) Transport {
 	}
 
 	transport := &transport{
-		uri:   uri,
-		send:  make(chan interface{}, 10240),
-		recv:  make(chan interface{}, 10240),
-		close: make(chan struct{}),
+		uri:      uri,
+		self:     self,
+		peer:     peer,
+		codec:    *CodecName,
+		security: *Security,
+		policy:   *SendPolicy,
+		conns:    make(map[net.Conn]struct{}),
+		send:     make(chan interface{}, 10240),
+		recv:     make(chan interface{}, 10240),
+		close:    make(chan struct{}),
+	}
+	// the streams of mux run over the tcp addresses of the nodes
+	if *Scheme == MUX && uri.Scheme == "tcp" {
+		uri.Scheme = MUX
+	}
+	if transport.security == TLS && ((uri.Scheme == "tcp" || uri.Scheme == MUX) && self == "" || uri.Scheme == "udp") {
+		log.Fatalf("tls needs the node identities of a tcp transport, got %s", addr)
+	}
+	if transport.security == TLS && *KeyDir == "" && !derivedKeys {
+		log.Fatal("tls needs the keys of the nodes, set a key directory")
 	}
 
 	switch uri.Scheme {
@@ -66,6 +95,8 @@ func NewTransport(addr string) Transport {
 		t := new(udp)
 		t.transport = transport
 		return t
+	case MUX:
+		return newMux(transport)
 	default:
 		log.Fatalf("unknown scheme %s", uri.Scheme)
 	}
@@ -73,48 +104,82 @@ func NewTransport(addr string) Transport {
 }
 
 type transport struct {
-	uri   *url.URL
-	send  chan interface{}
-	recv  chan interface{}
-	close chan struct{}
+	uri      *url.URL
+	self     identity.NodeID
+	peer     identity.NodeID
+	codec    string
+	security string
+	policy   string
+	send     chan interface{}
+	recv     chan interface{}
+	close    chan struct{}
+	once     sync.Once
+	state    state
+
+	listener net.Listener
+	conns    map[net.Conn]struct{} // connections accepted by the listener
+	lock     sync.Mutex            // locking listener and conns
 }
 
+// Send queues the message, it waits for room in the queue or drops the message according to the send policy
 func (t *transport) Send(m interface{}) {
-	t.send <- m
+	t.enqueue(t.send, m)
+}
+
+// enqueue puts the message into the queue, a message not fitting in is dropped under the drop policy.
+// Under the block policy the sender waits for room up to blockTimeout while the peer is connected,
+// a peer that is down or does not drain its queue in time has the message dropped so that it never holds up the sender.
+func (t *transport) enqueue(queue chan<- interface{}, m interface{}) {
+	select {
+	case queue <- m:
+		return
+	case <-t.close:
+		return
+	default:
+	}
+	if t.policy == BLOCK && t.state.online() {
+		timer := time.NewTimer(blockTimeout)
+		defer timer.Stop()
+		select {
+		case queue <- m:
+			return
+		case <-t.close:
+			return
+		case <-timer.C:
+		}
+	}
+	t.state.dropped()
+	log.Debugf("[%v] the queue to %v is full, dropping %T", t.self, t.uri.Host, m)
 }
 
 func (t *transport) Recv() interface{} {
 	return <-t.recv
 }
 
+// Close stops sending and listening and closes the connections, the queued messages are dropped
 func (t *transport) Close() {
-	close(t.send)
-	close(t.close)
+	t.once.Do(func() {
+		t.lock.Lock()
+		defer t.lock.Unlock()
+		close(t.close)
+		if t.listener != nil {
+			t.listener.Close()
+		}
+		for conn := range t.conns {
+			conn.Close()
+		}
+		t.state.closed()
+	})
 }
 
 func (t *transport) Scheme() string {
 	return t.uri.Scheme
 }
 
+// Dial starts sending the queued messages to the peer, the connection is opened in the background and opened again
+// whenever it breaks, so that a peer that is not up yet or restarts receives the messages once it is back
 func (t *transport) Dial() error {
-	conn, err := net.Dial(t.Scheme(), t.uri.Host)
-	if err != nil {
-		return err
-	}
-
-	go func(conn net.Conn) {
-		// w := bufio.NewWriter(conn)
-		// codec := NewCodec(config.Codec, conn)
-		encoder := gob.NewEncoder(conn)
-		defer conn.Close()
-		for m := range t.send {
-			err := encoder.Encode(&m)
-			if err != nil {
-				log.Error(err)
-			}
-		}
-	}(conn)
-
+	go t.stream(t.send)
 	return nil
 }
 
@@ -131,30 +196,57 @@ func (t *tcp) Listen() {
 	if err != nil {
 		log.Fatal("TCP Listener error: ", err)
 	}
+	t.lock.Lock()
+	t.listener = listener
+	t.lock.Unlock()
 
 	go func(listener net.Listener) {
 		defer listener.Close()
 		for {
 			conn, err := listener.Accept()
 			if err != nil {
+				select {
+				case <-t.close:
+					return
+				default:
+				}
 				log.Error("TCP Accept error: ", err)
 				continue
 			}
+			if !t.accepted(conn) {
+				conn.Close()
+				return
+			}
 
 			go func(conn net.Conn) {
-				// codec := NewCodec(config.Codec, conn)
-				decoder := gob.NewDecoder(conn)
+				defer t.released(conn)
 				defer conn.Close()
-				//r := bufio.NewReader(conn)
+				if t.security == TLS {
+					c, err := t.handshake(conn, tls.Server)
+					if err != nil {
+						log.Errorf("rejecting the connection from %v: %v", conn.RemoteAddr(), err)
+						return
+					}
+					conn = c
+				}
+				codec := NewCodec(t.codec)
+				r := bufio.NewReader(conn)
 				for {
 					select {
 					case <-t.close:
 						return
 					default:
-						var m interface{}
-						err := decoder.Decode(&m)
+						b, err := ReadFrame(r)
+						if err != nil {
+							// the frames cannot be told apart any more
+							if err != io.EOF {
+								log.Errorf("closing the connection from %v: %v", conn.RemoteAddr(), err)
+							}
+							return
+						}
+						m, err := codec.Unmarshal(b)
 						if err != nil {
-							log.Error(err)
+							log.Errorf("cannot decode a message from %v: %v", conn.RemoteAddr(), err)
 							continue
 						}
 						t.recv <- m
@@ -166,6 +258,29 @@ func (t *tcp) Listen() {
 	}(listener)
 }
 
+// handshake authenticates the connection with tls, the client expects the peer of the transport and
+// the server accepts any node holding its key
+func (t *transport) handshake(conn net.Conn, wrap func(net.Conn, *tls.Config) *tls.Conn) (net.Conn, error) {
+	peer := t.peer
+	if t.peer == t.self {
+		peer = ""
+	}
+	config, err := tlsConfig(t.self, peer)
+	if err != nil {
+		return conn, err
+	}
+	start := time.Now()
+	c := wrap(conn, config)
+	c.SetDeadline(start.Add(handshakeTimeout))
+	err = c.Handshake()
+	if err != nil {
+		return conn, err
+	}
+	c.SetDeadline(time.Time{})
+	log.Debugf("[%v] tls handshake with node %v in %v", t.self, peerOf(c), time.Since(start))
+	return c, nil
+}
+
 /******************************
 /*     UDP communication      *
 /******************************/
@@ -182,14 +297,30 @@ func (u *udp) Dial() error {
 	if err != nil {
 		return err
 	}
+	u.state.opened()
+	u.state.connected(false)
 
 	go func(conn *net.UDPConn) {
 		// packet := make([]byte, 1500)
 		// w := bytes.NewBuffer(packet)
 		w := new(bytes.Buffer)
-		for m := range u.send {
-			gob.NewEncoder(w).Encode(&m)
-			_, err := conn.Write(w.Bytes())
+		defer conn.Close()
+		for {
+			var m interface{}
+			select {
+			case <-u.close:
+				return
+			case m = <-u.send:
+			}
+			// every packet is encoded by itself since packets may be lost
+			b, err := NewCodec(u.codec).Marshal(m)
+			if err != nil {
+				log.Errorf("cannot encode %T: %v", m, err)
+				continue
+			}
+			record(m, 4+len(b))
+			WriteFrame(w, b)
+			_, err = conn.Write(w.Bytes())
 			if err != nil {
 				log.Error(err)
 			}
@@ -217,14 +348,21 @@ func (u *udp) Listen() {
 			case <-u.close:
 				return
 			default:
-				_, err := conn.Read(packet)
+				n, err := conn.Read(packet)
 				if err != nil {
 					log.Error(err)
 					continue
 				}
-				r := bytes.NewReader(packet)
-				var m interface{}
-				gob.NewDecoder(r).Decode(&m)
+				b, err := ReadFrame(bytes.NewReader(packet[:n]))
+				if err != nil {
+					log.Errorf("invalid packet: %v", err)
+					continue
+				}
+				m, err := NewCodec(u.codec).Unmarshal(b)
+				if err != nil {
+					log.Errorf("cannot decode a packet: %v", err)
+					continue
+				}
 				u.recv <- m
 			}
 		}
@@ -253,9 +391,16 @@ func (c *channel) Dial() error {
 	if !ok {
 		return errors.New("server not ready")
 	}
+	c.state.opened()
+	c.state.connected(false)
 	go func(conn chan<- interface{}) {
-		for m := range c.send {
-			conn <- m
+		for {
+			select {
+			case <-c.close:
+				return
+			case m := <-c.send:
+				conn <- m
+			}
 		}
 	}(conn)
 	return nil